@@ -0,0 +1,372 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+	"github.com/jxsl13/goripr/v2"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// state describes whether an ip was found in the allowlist, the blacklist or the whitelist
+type state struct {
+	Found  bool           `json:"found"`
+	Reason string         `json:"reason,omitempty"`
+	Range  *vpn.RangeInfo `json:"range,omitempty"`
+	Since  *time.Time     `json:"since,omitempty"`
+	// TTL is the remaining time to live in seconds, -1 for entries that never expire
+	TTL *int64 `json:"ttl,omitempty"`
+	// Entries are the whitelisted ip and ranges that contain the ip
+	Entries []whitelistEntry `json:"entries,omitempty"`
+}
+
+// whitelistEntry is a whitelisted ip or CIDR range with its remaining time to live
+type whitelistEntry struct {
+	Key   string     `json:"key"`
+	Since *time.Time `json:"since,omitempty"`
+	// TTL is the remaining time to live in seconds, -1 for entries that never expire
+	TTL int64 `json:"ttl"`
+}
+
+type lookupResponse struct {
+	IP        string `json:"ip"`
+	Allowlist state  `json:"allowlist"`
+	Blacklist state  `json:"blacklist"`
+	Whitelist state  `json:"whitelist"`
+}
+
+type recheckResponse struct {
+	IP     string `json:"ip"`
+	VPN    bool   `json:"vpn"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type listResponse struct {
+	Total  int             `json:"total"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Ranges []vpn.RangeInfo `json:"ranges"`
+}
+
+type addRequest struct {
+	Range  string `json:"range"`
+	Reason string `json:"reason"`
+	// TTL is a duration like 720h, empty keeps the range forever
+	TTL string `json:"ttl"`
+	// Disallow removes the overlapping allowed ranges, which take precedence over the blacklist
+	Disallow bool `json:"disallow"`
+}
+
+type addResponse struct {
+	vpn.RangeInfo
+	// Allowlisted are the overlapping allowed ranges, they were removed in case Disallowed is true
+	Allowlisted []vpn.RangeInfo `json:"allowlisted,omitempty"`
+	Disallowed  bool            `json:"disallowed,omitempty"`
+}
+
+type removeResponse struct {
+	Removed string `json:"removed"`
+	Allowed bool   `json:"allowed"`
+}
+
+// handleIP serves GET /api/v1/ips/{ip} and POST /api/v1/ips/{ip}/recheck
+func (s *Server) handleIP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, ipsPath)
+	ip, recheck := strings.CutSuffix(path, "/recheck")
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ip: %s", ip))
+		return
+	}
+	ip = addr.Unmap().WithZone("").String()
+
+	switch {
+	case recheck && r.Method == http.MethodPost:
+		s.recheck(w, r, ip)
+	case !recheck && r.Method == http.MethodGet:
+		s.lookup(w, r, ip)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+	}
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request, ip string) {
+	ctx := r.Context()
+	resp := lookupResponse{IP: ip}
+
+	reason, err := s.al.Find(ctx, ip)
+	if err == nil {
+		resp.Allowlist = state{Found: true, Reason: reason}
+	} else if !errors.Is(err, goripr.ErrIPNotFound) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	reason, err = s.bl.Find(ctx, ip)
+	if err == nil {
+		resp.Blacklist = state{Found: true, Reason: reason}
+
+		info, found, err := s.bl.Lookup(ctx, ip)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if found {
+			resp.Blacklist.Range = &info
+			resp.Blacklist.TTL = ptr(ttl(info.Expires))
+		}
+	} else if !errors.Is(err, goripr.ErrIPNotFound) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if s.wl != nil {
+		entries, err := s.wl.Get(ip)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Whitelist = whitelistState(entries)
+
+		since, found, err := s.wl.Since(ip)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if found {
+			resp.Whitelist.Since = &since
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) recheck(w http.ResponseWriter, r *http.Request, ip string) {
+	isVPN, reason, err := s.checker.Recheck(ip)
+	if errors.Is(err, vpn.ErrNoQuorum) {
+		// the apis are unavailable, which is why the ip was not actually checked
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recheckResponse{
+		IP:     ip,
+		VPN:    isVPN,
+		Reason: reason,
+	})
+}
+
+// handleRanges serves GET, POST and DELETE /api/v1/ranges
+func (s *Server) handleRanges(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.list(w, r)
+	case http.MethodPost:
+		s.add(w, r)
+	case http.MethodDelete:
+		s.remove(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %s", query.Get("offset")))
+		return
+	}
+	limit, err := intParam(query.Get("limit"), defaultLimit)
+	if err != nil || limit <= 0 || limit > maxLimit {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit, must be between 1 and %d: %s", maxLimit, query.Get("limit")))
+		return
+	}
+
+	infos, err := s.bl.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page := infos[min(offset, len(infos)):min(offset+limit, len(infos))]
+	writeJSON(w, http.StatusOK, listResponse{
+		Total:  len(infos),
+		Offset: offset,
+		Limit:  limit,
+		Ranges: page,
+	})
+}
+
+func (s *Server) add(w http.ResponseWriter, r *http.Request) {
+	var req addRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	ipRange := strings.TrimSpace(req.Range)
+	_, _, err = vpn.ParseRange(ipRange)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid range: %s: %w", req.Range, err))
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl: %s", req.TTL))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	err = s.bl.Insert(ctx, ipRange, req.Reason, vpn.SourceManual, ttl)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if s.wl != nil {
+		_, err = s.wl.Evict(ipRange)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	resp := addResponse{}
+	if s.al != nil {
+		resp.Allowlisted, err = s.al.Overlapping(ctx, ipRange)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	for _, allowed := range resp.Allowlisted {
+		if !req.Disallow {
+			log.Printf("[WARNING]: the allowed range %s (%s) overlaps with the added range %s and takes precedence\n", allowed.Range, allowed.Reason, ipRange)
+			continue
+		}
+		err = s.al.Remove(ctx, allowed.Range)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Disallowed = true
+	}
+
+	resp.RangeInfo, _, err = s.bl.Info(ctx, ipRange)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) remove(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ipRange := strings.TrimSpace(query.Get("range"))
+	_, _, err := vpn.ParseRange(ipRange)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid range: %s: %w", ipRange, err))
+		return
+	}
+
+	allow := false
+	if v := query.Get("allow"); v != "" {
+		allow, err = strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid allow parameter: %s", v))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	err = s.bl.Remove(ctx, ipRange)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// prevents the apis from blacklisting the range again
+	if allow {
+		err = s.al.Add(ctx, ipRange, query.Get("reason"), vpn.SourceManual)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, removeResponse{
+		Removed: ipRange,
+		Allowed: allow,
+	})
+}
+
+// ttl returns the remaining seconds until the expiry, -1 for entries that never expire
+func ttl(expires time.Time) int64 {
+	if expires.IsZero() {
+		return -1
+	}
+	return max(int64(time.Until(expires).Seconds()), 0)
+}
+
+// whitelistState reports every entry with its own expiry, the ip stays whitelisted
+// until the entry that expires last is gone.
+func whitelistState(entries []vpn.WhitelistEntry) state {
+	if len(entries) == 0 {
+		return state{}
+	}
+
+	s := state{
+		Found:   true,
+		Entries: make([]whitelistEntry, 0, len(entries)),
+	}
+	var longest int64
+	for i, e := range entries {
+		entry := whitelistEntry{
+			Key: e.Key,
+			TTL: ttl(e.Expires),
+		}
+		if !e.Since.IsZero() {
+			entry.Since = &e.Since
+		}
+		s.Entries = append(s.Entries, entry)
+
+		if i == 0 || longest >= 0 && (entry.TTL < 0 || entry.TTL > longest) {
+			longest = entry.TTL
+		}
+	}
+	s.TTL = &longest
+	return s
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func intParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
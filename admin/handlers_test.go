@@ -0,0 +1,327 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+	"github.com/jxsl13/goripr/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const testToken = "secret"
+
+// newTestLists creates a blacklist and an allowlist in an in-memory redis server
+func newTestLists(t *testing.T) (*vpn.Blacklist, *vpn.Allowlist) {
+	t.Helper()
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	ripr, err := goripr.NewClient(ctx, goripr.Options{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := vpn.NewBlacklist(ripr, rdb, "test:blacklist:")
+	t.Cleanup(func() { _ = bl.Close() })
+
+	allowRipr, err := goripr.NewClient(ctx, goripr.Options{Addr: mr.Addr(), DB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	al := vpn.NewAllowlist(allowRipr, rdb, "test:allow:")
+	t.Cleanup(func() { _ = al.Close() })
+	return bl, al
+}
+
+// do sends the authorized request to the server
+func do(t *testing.T, srv *httptest.Server, method, path string, body any) *http.Response {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&buf).Encode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, srv.URL+path, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestAddRangeOverlappingAllowlist(t *testing.T) {
+	tests := []struct {
+		name        string
+		request     addRequest
+		allowlisted []string
+		// whether the allowed range is kept
+		allowed bool
+	}{
+		{
+			name:    "disjoint",
+			request: addRequest{Range: "10.1.0.0/16", Reason: "abuse"},
+			allowed: true,
+		},
+		{
+			name:        "overlapping ranges are reported",
+			request:     addRequest{Range: "10.0.0.0/8", Reason: "abuse"},
+			allowlisted: []string{"10.0.0.0/24"},
+			allowed:     true,
+		},
+		{
+			name:        "overlapping ranges are removed",
+			request:     addRequest{Range: "10.0.0.0/8", Reason: "abuse", Disallow: true},
+			allowlisted: []string{"10.0.0.0/24"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			bl, al := newTestLists(t)
+			err := al.Add(ctx, "10.0.0.0/24", "staff", vpn.SourceManual)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := NewServer("", testToken, bl, al, nil, nil)
+			srv := httptest.NewServer(s.srv.Handler)
+			defer srv.Close()
+
+			resp := do(t, srv, http.MethodPost, rangesPath, tt.request)
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+			}
+
+			var added addResponse
+			err = json.NewDecoder(resp.Body).Decode(&added)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added.Range != tt.request.Range || added.Source != vpn.SourceManual {
+				t.Errorf("expected the added range %s, got %s (%s)", tt.request.Range, added.Range, added.Source)
+			}
+			if len(added.Allowlisted) != len(tt.allowlisted) {
+				t.Fatalf("expected allowlisted %v, got %v", tt.allowlisted, added.Allowlisted)
+			}
+			for idx, info := range added.Allowlisted {
+				if info.Range != tt.allowlisted[idx] {
+					t.Errorf("expected allowlisted %s, got %s", tt.allowlisted[idx], info.Range)
+				}
+			}
+			if added.Disallowed != !tt.allowed {
+				t.Errorf("expected disallowed %t, got %t", !tt.allowed, added.Disallowed)
+			}
+
+			_, err = al.Find(ctx, "10.0.0.1")
+			if errors.Is(err, goripr.ErrIPNotFound) == tt.allowed {
+				t.Fatalf("expected allowed %t, got %v", tt.allowed, err)
+			}
+		})
+	}
+}
+
+// fakeAPI answers every request with the same verdict or error
+type fakeAPI struct {
+	verdict vpn.Verdict
+	err     error
+}
+
+func (f fakeAPI) String() string { return "fake" }
+
+func (f fakeAPI) IsVPN(ctx context.Context, ip string) (vpn.Verdict, error) {
+	return f.verdict, f.err
+}
+
+func TestRecheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		api    fakeAPI
+		status int
+		vpn    bool
+	}{
+		{
+			name:   "detected",
+			api:    fakeAPI{verdict: vpn.Verdict{Confidence: 1, Category: vpn.CategoryVPN}},
+			status: http.StatusOK,
+			vpn:    true,
+		},
+		{
+			name:   "clean",
+			api:    fakeAPI{},
+			status: http.StatusOK,
+		},
+		{
+			name:   "no answer",
+			api:    fakeAPI{err: vpn.ErrQuotaExhausted},
+			status: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			bl, al := newTestLists(t)
+			checker := vpn.NewVPNChecker(ctx, bl, al, 0, nil, 0, []vpn.VPN{tt.api}, nil, nil, time.Second, 1, false, 0.6)
+
+			s := NewServer("", testToken, bl, al, nil, checker)
+			srv := httptest.NewServer(s.srv.Handler)
+			defer srv.Close()
+
+			resp := do(t, srv, http.MethodPost, ipsPath+"1.2.3.4/recheck", nil)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			var recheck recheckResponse
+			err := json.NewDecoder(resp.Body).Decode(&recheck)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recheck.VPN != tt.vpn {
+				t.Fatalf("expected vpn %t, got %t", tt.vpn, recheck.VPN)
+			}
+		})
+	}
+}
+
+func TestLookupWhitelist(t *testing.T) {
+	tests := []struct {
+		name string
+		// whitelistTTL is used for the single ip
+		whitelistTTL time.Duration
+		ip           bool
+		ranges       map[string]time.Duration
+
+		found bool
+		since bool
+		// ttl and the ttls of the entries are in seconds, nil for ips that are not whitelisted
+		ttl  *int64
+		ttls map[string]int64
+	}{
+		{
+			name: "not whitelisted",
+		},
+		{
+			name:         "single ip",
+			whitelistTTL: time.Hour,
+			ip:           true,
+			found:        true,
+			since:        true,
+			ttl:          ptr[int64](3600),
+			ttls:         map[string]int64{"1.2.3.4": 3600},
+		},
+		{
+			name:         "expiring single ip",
+			whitelistTTL: 500 * time.Millisecond,
+			ip:           true,
+			found:        true,
+			since:        true,
+			ttl:          ptr[int64](0),
+			ttls:         map[string]int64{"1.2.3.4": 0},
+		},
+		{
+			name:   "range with its own ttl",
+			ranges: map[string]time.Duration{"1.2.3.0/24": 24 * time.Hour},
+			found:  true,
+			ttl:    ptr[int64](86400),
+			ttls:   map[string]int64{"1.2.3.0/24": 86400},
+		},
+		{
+			name:         "range that never expires",
+			whitelistTTL: time.Hour,
+			ip:           true,
+			ranges:       map[string]time.Duration{"1.2.0.0/16": 0},
+			found:        true,
+			since:        true,
+			ttl:          ptr[int64](-1),
+			ttls:         map[string]int64{"1.2.0.0/16": -1, "1.2.3.4": 3600},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			bl, al := newTestLists(t)
+
+			mr := miniredis.RunT(t)
+			rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { _ = rdb.Close() })
+			wl := vpn.NewRedisWhitelister(ctx, rdb, "test:whitelist:", tt.whitelistTTL)
+
+			if tt.ip {
+				err := wl.Whitelist("1.2.3.4")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for ipRange, ttl := range tt.ranges {
+				err := wl.WhitelistRange(ipRange, ttl)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := NewServer("", testToken, bl, al, wl, nil)
+			srv := httptest.NewServer(s.srv.Handler)
+			defer srv.Close()
+
+			resp := do(t, srv, http.MethodGet, ipsPath+"1.2.3.4", nil)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+
+			var lookup lookupResponse
+			err := json.NewDecoder(resp.Body).Decode(&lookup)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := lookup.Whitelist
+			if got.Found != tt.found || (got.Since != nil) != tt.since {
+				t.Fatalf("expected found %t and since %t, got %t and %v", tt.found, tt.since, got.Found, got.Since)
+			}
+			if (got.TTL == nil) != (tt.ttl == nil) || got.TTL != nil && !closeTTL(*got.TTL, *tt.ttl) {
+				t.Fatalf("expected ttl %v, got %v", tt.ttl, got.TTL)
+			}
+			if len(got.Entries) != len(tt.ttls) {
+				t.Fatalf("expected entries %v, got %v", tt.ttls, got.Entries)
+			}
+			for _, e := range got.Entries {
+				ttl, ok := tt.ttls[e.Key]
+				if !ok || !closeTTL(e.TTL, ttl) {
+					t.Errorf("expected the ttl of %s to be %d, got %d", e.Key, ttl, e.TTL)
+				}
+			}
+		})
+	}
+}
+
+// closeTTL allows the remaining seconds to decrease while the test is running
+func closeTTL(got, want int64) bool {
+	if want < 0 {
+		return got == want
+	}
+	return got <= want && got >= max(want-5, 0)
+}
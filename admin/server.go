@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+)
+
+// routes of the admin api
+const (
+	ipsPath     = "/api/v1/ips/"
+	rangesPath  = "/api/v1/ranges"
+	metricsPath = "/debug/vars"
+)
+
+// NewServer creates the http admin api that listens on the given address.
+// Every request must provide the token as Authorization: Bearer <token> header.
+// The whitelister may be nil.
+func NewServer(
+	addr string,
+	token string,
+	bl *vpn.Blacklist,
+	al *vpn.Allowlist,
+	wl vpn.Whitelister,
+	checker *vpn.VPNChecker,
+) *Server {
+	s := &Server{
+		token:   token,
+		bl:      bl,
+		al:      al,
+		wl:      wl,
+		checker: checker,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ipsPath, s.handleIP)
+	mux.HandleFunc(rangesPath, s.handleRanges)
+	// expvar metrics, e.g. the quota suspensions of the apis
+	mux.Handle(metricsPath, expvar.Handler())
+
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           s.authenticate(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Server is the http admin api for querying and managing the ban database
+type Server struct {
+	srv     *http.Server
+	token   string
+	bl      *vpn.Blacklist
+	al      *vpn.Allowlist
+	wl      vpn.Whitelister
+	checker *vpn.VPNChecker
+}
+
+// Run serves the admin api until the context is canceled.
+func (s *Server) Run(ctx context.Context) {
+	// requests are canceled on shutdown
+	s.srv.BaseContext = func(_ net.Listener) context.Context {
+		return ctx
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("[admin]: listening on %s\n", s.srv.Addr)
+	err := s.srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("[ERROR]: admin: %v\n", err)
+	}
+}
+
+// authenticate rejects requests without a valid bearer token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("[ERROR]: admin: failed to write response: %v\n", err)
+	}
+}
@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	s := NewServer("", "secret", nil, nil, nil, nil)
+	srv := httptest.NewServer(s.srv.Handler)
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "without token", status: http.StatusUnauthorized},
+		{name: "invalid token", token: "wrong", status: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+metricsPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			var vars map[string]json.RawMessage
+			err = json.NewDecoder(resp.Body).Decode(&vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"vpn_api_quota_suspensions", "vpn_api_quota_suspended_until"} {
+				if _, found := vars[name]; !found {
+					t.Errorf("expected metric %s", name)
+				}
+			}
+		})
+	}
+}
@@ -2,10 +2,15 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jxsl13/TeeworldsEconVPNDetection/config"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
 	"github.com/jxsl13/goripr/v2"
+	"github.com/nutsdb/nutsdb"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cobra"
 )
 
@@ -24,23 +29,43 @@ func NewAddCmd(ctx context.Context) *cobra.Command {
 		RunE:         addContext.RunE,
 		Args:         cobra.MinimumNArgs(1),
 		PostRunE: func(cmd *cobra.Command, args []string) error {
-			if addContext.Ripr != nil {
-				return addContext.Ripr.Close()
+			if addContext.Redis != nil {
+				defer addContext.Redis.Close()
+			}
+			if addContext.Nuts != nil {
+				defer addContext.Nuts.Close()
+			}
+			if addContext.Allowlist != nil {
+				defer addContext.Allowlist.Close()
+			}
+			if addContext.Blacklist != nil {
+				return addContext.Blacklist.Close()
 			}
 			return nil
 		},
 	}
 
+	cmd.Flags().DurationVar(&addContext.TTL, "ttl", 0, "time to live of the added ip ranges, 0 keeps them forever")
+	cmd.Flags().BoolVar(&addContext.Disallow, "disallow", false, "remove allowed ip ranges that overlap with the added ip ranges, otherwise they are only reported")
+	addContext.Import.registerFlags(cmd)
+
 	// register flags but defer parsing and validation of the final values
 	cmd.PreRunE = addContext.PreRunE(cmd)
 	return cmd
 }
 
 type addContext struct {
-	Ctx       context.Context
-	Config    *config.ConnectConfig
-	Ripr      *goripr.Client
-	FilePaths []string
+	Ctx         context.Context
+	Config      *config.ConnectConfig
+	Redis       *redis.Client
+	Blacklist   *vpn.Blacklist
+	Nuts        *nutsdb.DB
+	Whitelister vpn.Whitelister
+	Allowlist   *vpn.Allowlist
+	Disallow    bool
+	TTL         time.Duration
+	Import      importOptions
+	FilePaths   []string
 }
 
 func (c *addContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
@@ -67,7 +92,45 @@ func (c *addContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args [
 			return err
 		}
 
-		c.Ripr = ripr
+		c.Redis = redis.NewClient(&redis.Options{
+			Addr:     c.Config.RedisAddress,
+			Password: c.Config.RedisPassword,
+			DB:       c.Config.RedisDB,
+		})
+		c.Blacklist = vpn.NewBlacklist(ripr, c.Redis, c.Config.RedisBlacklistKeyPrefix)
+		err = checkLegacy(c.Ctx, c.Blacklist)
+		if err != nil {
+			return err
+		}
+
+		// the allowlist takes precedence over the added ranges
+		c.Allowlist, err = newAllowlist(
+			c.Ctx,
+			c.Config.RedisAddress,
+			c.Config.RedisPassword,
+			c.Config.RedisAllowDB,
+			c.Redis,
+			c.Config.RedisAllowKeyPrefix,
+		)
+		if err != nil {
+			return err
+		}
+
+		// the whitelist ttl is irrelevant, as ips are only evicted
+		c.Whitelister, c.Nuts, err = openWhitelister(
+			c.Ctx,
+			c.Config.WhitelistStore,
+			c.Config.NutsDBDir,
+			c.Config.NutsDBBucket,
+			c.Redis,
+			c.Config.RedisWhitelistKeyPrefix,
+			0,
+		)
+		if errors.Is(err, nutsdb.ErrDirLocked) {
+			fmt.Printf("cannot evict overlapping whitelist entries, the nutsdb database is used by a running detector (use whitelist.store=redis): %v\n", err)
+		} else if err != nil {
+			return err
+		}
 
 		c.FilePaths = args
 		return nil
@@ -79,8 +142,13 @@ func (c *addContext) RunE(cmd *cobra.Command, args []string) error {
 		fmt.Printf("adding ips from %s\n", file)
 		added, err := parseFileAndAddIPsToCache(
 			c.Ctx,
-			c.Ripr,
+			c.Blacklist,
+			c.Whitelister,
+			c.Allowlist,
 			file,
+			c.Import,
+			c.TTL,
+			c.Disallow,
 		)
 		if err != nil {
 			return err
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/config"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+	"github.com/jxsl13/goripr/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cobra"
+)
+
+func NewAllowCmd(ctx context.Context) *cobra.Command {
+
+	allowContext := allowContext{
+		Ctx:    ctx,
+		Config: config.NewConnect(),
+	}
+
+	cmd := &cobra.Command{
+		Use:          "allow",
+		Short:        "manage the allowlist of ip ranges that are never considered to be vpns",
+		SilenceUsage: true,
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if allowContext.Redis != nil {
+				defer allowContext.Redis.Close()
+			}
+			if allowContext.Allowlist != nil {
+				return allowContext.Allowlist.Close()
+			}
+			return nil
+		},
+	}
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PersistentPreRunE = allowContext.PreRunE(cmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:          "add <ip range> [reason...]",
+		Short:        "allow an ip, CIDR range or from-to range",
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE:         allowContext.AddRunE,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:          "remove <ip range> [more ip ranges...]",
+		Short:        "remove ip ranges from the allowlist",
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE:         allowContext.RemoveRunE,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:          "list",
+		Short:        "list all allowed ip ranges",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE:         allowContext.ListRunE,
+	})
+	return cmd
+}
+
+type allowContext struct {
+	Ctx       context.Context
+	Config    *config.ConnectConfig
+	Redis     *redis.Client
+	Allowlist *vpn.Allowlist
+}
+
+func (c *allowContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+	runParser := config.RegisterFlags(
+		c.Config,
+		true,
+		cmd,
+		config.WithEnvPrefix("TWVPN_"),
+	)
+	return func(cmd *cobra.Command, args []string) error {
+		err := runParser()
+		if err != nil {
+			return err
+		}
+
+		c.Redis = redis.NewClient(&redis.Options{
+			Addr:     c.Config.RedisAddress,
+			Password: c.Config.RedisPassword,
+			DB:       c.Config.RedisDB,
+		})
+		c.Allowlist, err = newAllowlist(
+			c.Ctx,
+			c.Config.RedisAddress,
+			c.Config.RedisPassword,
+			c.Config.RedisAllowDB,
+			c.Redis,
+			c.Config.RedisAllowKeyPrefix,
+		)
+		return err
+	}
+}
+
+func (c *allowContext) AddRunE(cmd *cobra.Command, args []string) error {
+	ipRange, _, err := parseIPLine(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid ip range: %s: %w", args[0], err)
+	}
+	reason := strings.Join(args[1:], " ")
+
+	err = c.Allowlist.Add(c.Ctx, ipRange, reason, vpn.SourceManual)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("allowed %s\n", ipRange)
+	return nil
+}
+
+func (c *allowContext) RemoveRunE(cmd *cobra.Command, args []string) error {
+	for _, arg := range args {
+		ipRange, _, err := parseIPLine(arg)
+		if err != nil {
+			return fmt.Errorf("invalid ip range: %s: %w", arg, err)
+		}
+
+		err = c.Allowlist.Remove(c.Ctx, ipRange)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %s from the allowlist\n", ipRange)
+	}
+	return nil
+}
+
+func (c *allowContext) ListRunE(cmd *cobra.Command, args []string) error {
+	entries, err := c.Allowlist.List(c.Ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		// parts of the range that were removed are not allowed anymore
+		for _, part := range e.Parts() {
+			fmt.Println(formatIPLine(part, e.Reason))
+		}
+	}
+	return nil
+}
+
+// newAllowlist connects to the redis database of the allowlist, which must differ from the
+// database of the blacklist, as both goripr caches would otherwise share their keys.
+func newAllowlist(ctx context.Context, address, password string, db int, rdb *redis.Client, keyPrefix string) (*vpn.Allowlist, error) {
+	ripr, err := goripr.NewClient(
+		ctx,
+		goripr.Options{
+			Addr:     address,
+			Password: password,
+			DB:       db,
+		})
+	if err != nil {
+		return nil, err
+	}
+	return vpn.NewAllowlist(ripr, rdb, keyPrefix), nil
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/config"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+	"github.com/spf13/cobra"
+)
+
+func NewCheckCmd(ctx context.Context) *cobra.Command {
+
+	checkContext := checkContext{
+		rootContext: rootContext{
+			Ctx:    ctx,
+			Config: config.NewWithoutEcon(),
+			// the check is usually run next to the detector
+			AllowLockedNutsDB: true,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:          "check <ip> [more ips...]",
+		Short:        "run the vpn detection for the given ips and explain the verdict",
+		SilenceUsage: true,
+		RunE:         checkContext.RunE,
+		Args:         cobra.MinimumNArgs(1),
+		PostRunE: func(cmd *cobra.Command, args []string) error {
+			if checkContext.Redis != nil {
+				defer checkContext.Redis.Close()
+			}
+			if checkContext.Nuts != nil {
+				defer checkContext.Nuts.Close()
+			}
+			if checkContext.Allowlist != nil {
+				defer checkContext.Allowlist.Close()
+			}
+			if checkContext.Blacklist != nil {
+				return checkContext.Blacklist.Close()
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&checkContext.NoCache, "no-cache", false, "ignore the allowlist, the blacklist and the whitelist and always ask the local databases and the apis")
+	cmd.Flags().BoolVar(&checkContext.NoWrite, "no-write", false, "dry run, do not cache the results of the apis")
+	cmd.Flags().BoolVar(&checkContext.JSON, "json", false, "print the results as json")
+	cmd.Flags().BoolVar(&checkContext.ForceAPIs, "force", false, "ask the apis even if their rate limits are used by a running detector, the requests are not counted against its rate limits")
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PreRunE = checkContext.PreRunE(cmd)
+	return cmd
+}
+
+type checkContext struct {
+	rootContext
+
+	NoCache bool
+	NoWrite bool
+	JSON    bool
+}
+
+// checkResult is the report of the checker with the remaining quota of the apis after the check
+type checkResult struct {
+	vpn.Report
+	// Quota is the number of remaining requests per rate limit window, e.g. iphub.info:1000/24h0m0s
+	Quota map[string]int `json:"quota,omitempty"`
+	// the states below are unknown in case the nutsdb database is used by a running detector
+	QuotaUnknown     bool `json:"quota_unknown,omitempty"`
+	WhitelistUnknown bool `json:"whitelist_unknown,omitempty"`
+	APIsSkipped      bool `json:"apis_skipped,omitempty"`
+}
+
+func (c *checkContext) RunE(cmd *cobra.Command, args []string) error {
+	results := make([]checkResult, 0, len(args))
+	for _, ip := range args {
+		report, err := c.Checker.Explain(ip, c.NoCache, c.NoWrite)
+		if err != nil {
+			return err
+		}
+
+		result := checkResult{
+			Report:           report,
+			Quota:            make(map[string]int, len(report.Online)),
+			QuotaUnknown:     c.LimitsUnknown,
+			WhitelistUnknown: c.NutsLocked && c.Whitelister == nil,
+			APIsSkipped:      c.LimitsUnknown && !c.ForceAPIs,
+		}
+		for _, answer := range report.Online {
+			if result.QuotaUnknown {
+				// the in-memory rate limits do not know the requests of the detector
+				break
+			}
+			for name, limiter := range c.Limiters {
+				if !strings.HasPrefix(name, answer.API+":") {
+					continue
+				}
+				remaining, err := limiter.Remaining(c.Ctx)
+				if err != nil {
+					return err
+				}
+				result.Quota[name] = remaining
+			}
+		}
+		results = append(results, result)
+	}
+
+	if c.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	for _, result := range results {
+		printCheckResult(result)
+	}
+	return nil
+}
+
+func printCheckResult(r checkResult) {
+	fmt.Println(r.IP)
+	if r.Allowed {
+		fmt.Printf("  allowlist: %s\n", r.AllowReason)
+	} else {
+		fmt.Println("  allowlist: no")
+	}
+	if r.Cached {
+		fmt.Printf("  cache:     %s\n", r.CacheReason)
+	} else {
+		fmt.Println("  cache:     miss")
+	}
+	if r.WhitelistUnknown {
+		fmt.Println("  whitelist: unknown (used by a running detector)")
+	} else {
+		fmt.Printf("  whitelist: %s\n", yesNo(r.Whitelisted))
+	}
+
+	for _, a := range r.Local {
+		fmt.Printf("  local:     %s: %s\n", a.API, answerString(a))
+	}
+	if r.APIsSkipped {
+		fmt.Println("  online:    skipped, the rate limits are used by a running detector (use --force)")
+	}
+	for _, a := range r.Online {
+		quota := quotaString(a.API, r.Quota)
+		if r.QuotaUnknown {
+			quota = ", quota unknown"
+		}
+		fmt.Printf("  online:    %s: %s (weight %.2f)%s\n", a.API, answerString(a), a.Weight, quota)
+	}
+	if len(r.Online) > 0 {
+		fmt.Printf("  score:     %.2f (threshold %.2f)\n", r.Score, r.Threshold)
+		fmt.Printf("  answers:   %d (quorum %d)\n", r.Answers, r.Quorum)
+	}
+
+	if r.VPN {
+		fmt.Printf("  verdict:   %s (%s)\n", r.Reason, r.Source)
+	} else {
+		fmt.Printf("  verdict:   no vpn (%s)\n", r.Source)
+	}
+}
+
+func answerString(a vpn.APIAnswer) string {
+	if !a.Valid {
+		return "error: " + a.Error
+	}
+	return vpn.Verdict{
+		Confidence: a.Confidence,
+		Category:   a.Category,
+		ASN:        a.ASN,
+		ISP:        a.ISP,
+		Country:    a.Country,
+	}.String()
+}
+
+// quotaString returns the remaining requests of the rate limit windows of the api, e.g. quota 1000/24h0m0s: 987 left
+func quotaString(api string, quota map[string]int) string {
+	var windows []string
+	for name, remaining := range quota {
+		window, found := strings.CutPrefix(name, api+":")
+		if found {
+			windows = append(windows, fmt.Sprintf("%s: %d left", window, remaining))
+		}
+	}
+	if len(windows) == 0 {
+		return ""
+	}
+	sort.Strings(windows)
+	return ", quota " + strings.Join(windows, ", ")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/config"
+)
+
+func TestCheckLockedNutsDB(t *testing.T) {
+	tests := []struct {
+		name           string
+		rateLimitStore string
+		whitelistStore string
+		force          bool
+
+		limitsUnknown    bool
+		whitelistUnknown bool
+		source           string
+	}{
+		{
+			name:             "apis are skipped",
+			rateLimitStore:   "nutsdb",
+			whitelistStore:   "nutsdb",
+			limitsUnknown:    true,
+			whitelistUnknown: true,
+			source:           "offline",
+		},
+		{
+			name:             "apis are forced",
+			rateLimitStore:   "nutsdb",
+			whitelistStore:   "nutsdb",
+			force:            true,
+			limitsUnknown:    true,
+			whitelistUnknown: true,
+			source:           "online",
+		},
+		{
+			name:           "rate limits and whitelist in redis",
+			rateLimitStore: "redis",
+			whitelistStore: "redis",
+			source:         "online",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			nuts, err := openNutsDB(dir, "whitelist", "ratelimit")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer nuts.Close()
+
+			mr := miniredis.RunT(t)
+			cfg := config.NewWithoutEcon()
+			cfg.RedisAddress = mr.Addr()
+			cfg.NutsDBDir = dir
+			cfg.RateLimitStore = tt.rateLimitStore
+			cfg.WhitelistStore = tt.whitelistStore
+
+			c := checkContext{
+				rootContext: rootContext{
+					Ctx:               context.Background(),
+					Config:            cfg,
+					AllowLockedNutsDB: true,
+					ForceAPIs:         tt.force,
+				},
+			}
+			err = c.connect()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				_ = c.Redis.Close()
+				_ = c.Blacklist.Close()
+				_ = c.Allowlist.Close()
+			})
+
+			if !c.NutsLocked || c.LimitsUnknown != tt.limitsUnknown {
+				t.Fatalf("expected locked nutsdb with unknown limits %t, got %t %t", tt.limitsUnknown, c.NutsLocked, c.LimitsUnknown)
+			}
+			if whitelistUnknown := c.Whitelister == nil; whitelistUnknown != tt.whitelistUnknown {
+				t.Fatalf("expected unknown whitelist %t, got %t", tt.whitelistUnknown, whitelistUnknown)
+			}
+
+			// no apis are configured, which is why forced checks do not send any requests
+			report, err := c.Checker.Explain("1.2.3.4", false, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Source != tt.source {
+				t.Fatalf("expected verdict from %s, got %s", tt.source, report.Source)
+			}
+		})
+	}
+}
@@ -0,0 +1,665 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// ipFormat is the format of an ip file
+type ipFormat string
+
+const (
+	// formatAuto detects the format by the first lines of the file
+	formatAuto ipFormat = "auto"
+	// formatText are ip addresses, CIDR ranges or from-to ranges with an optional # reason, one per line
+	formatText ipFormat = "text"
+	// formatCSV has a header that names the ip and reason columns, see csvRangeColumns
+	formatCSV ipFormat = "csv"
+	// formatJSON is an array of strings in the text format or objects with ip and reason fields
+	formatJSON ipFormat = "json"
+	// formatNginx are deny 1.2.3.4; directives, allow directives are ignored
+	formatNginx ipFormat = "nginx"
+	// formatIPTables are iptables-save rules or iptables commands with a DROP or REJECT target
+	formatIPTables ipFormat = "iptables"
+	// formatIPSet is the output of ipset save
+	formatIPSet ipFormat = "ipset"
+)
+
+// ipFormats contains all supported formats of ip files
+var ipFormats = []ipFormat{formatAuto, formatText, formatCSV, formatJSON, formatNginx, formatIPTables, formatIPSet}
+
+// normalized csv header names and json field names
+var (
+	csvRangeColumns  = []string{"ip", "ips", "ipaddress", "ipaddr", "address", "addr", "range", "iprange", "cidr", "network", "net", "prefix", "ipprefix", "subnet"}
+	csvFromColumns   = []string{"from", "start", "first", "begin", "fromip", "startip", "firstip", "ipfrom", "ipstart", "rangestart"}
+	csvToColumns     = []string{"to", "end", "last", "toip", "endip", "lastip", "ipto", "ipend", "rangeend"}
+	csvReasonColumns = []string{"reason", "comment", "description", "note", "notes"}
+)
+
+// gzip compressed files are detected by their magic number
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// byte order mark of files that were exported by spreadsheet programs
+const utf8BOM = "\ufeff"
+
+// importOptions configure how ip files are read
+type importOptions struct {
+	Format string
+	// CSVRange and CSVReason are the csv header names of the ip range and the reason columns
+	CSVRange  string
+	CSVReason string
+}
+
+func (o *importOptions) registerFlags(cmd *cobra.Command) {
+	formats := make([]string, 0, len(ipFormats))
+	for _, f := range ipFormats {
+		formats = append(formats, string(f))
+	}
+	cmd.Flags().StringVar(&o.Format, "format", string(formatAuto), "format of the files: "+strings.Join(formats, ", "))
+	cmd.Flags().StringVar(&o.CSVRange, "csv-range", "", "csv header of the ip range column, detected by common names like ip, cidr or network by default")
+	cmd.Flags().StringVar(&o.CSVReason, "csv-reason", "", "csv header of the reason column, detected by common names like reason or comment by default")
+}
+
+func (o *importOptions) format() (ipFormat, error) {
+	if o.Format == "" {
+		return formatAuto, nil
+	}
+	format := ipFormat(strings.ToLower(o.Format))
+	if !slices.Contains(ipFormats, format) {
+		return "", fmt.Errorf("unknown format: %s", o.Format)
+	}
+	return format, nil
+}
+
+// readIPFile calls fn for every ip range of the file, which may be gzip compressed, - reads from stdin.
+// Lines that do not contain an ip range are reported with their line number.
+func readIPFile(filename string, opts importOptions, fn func(ipRange, reason string) error) error {
+	format, err := opts.format()
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	magic, _ := br.Peek(len(gzipMagic))
+	if bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		defer gz.Close()
+		br = bufio.NewReaderSize(gz, 64*1024)
+	}
+
+	if format == formatAuto {
+		// fails with bufio.ErrBufferFull or io.EOF in case the file is bigger or smaller than the buffer
+		sample, _ := br.Peek(br.Size())
+		format = detectFormat(sample)
+	}
+
+	p := &ipParser{
+		name: filename,
+		fn:   fn,
+		out:  os.Stdout,
+	}
+	err = p.parse(br, format, opts)
+	if err != nil {
+		return err
+	}
+
+	if p.rejected > 0 {
+		fmt.Printf("rejected %d lines of %s (format: %s)\n", p.rejected, filename, format)
+	}
+	return nil
+}
+
+// detectFormat returns the format of the first line that is neither empty nor a comment
+// and can be assigned to a format.
+func detectFormat(sample []byte) ipFormat {
+	sample = bytes.TrimPrefix(sample, []byte(utf8BOM))
+	if trimmed := bytes.TrimSpace(sample); bytes.HasPrefix(trimmed, []byte("[")) {
+		return formatJSON
+	}
+
+	lines := strings.Split(string(sample), "\n")
+	if len(lines) > 1 {
+		// the last line may be incomplete
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case fields[0] == "create" || fields[0] == "add":
+			return formatIPSet
+		case fields[0] == "deny" || fields[0] == "allow":
+			return formatNginx
+		case fields[0] == "iptables" || fields[0] == "ip6tables" || fields[0] == "-A" || fields[0] == "-I" || isIPTablesMeta(line):
+			return formatIPTables
+		}
+
+		if _, _, err := parseIPLine(line); err == nil {
+			return formatText
+		}
+		if strings.ContainsAny(line, ",;\t") {
+			return formatCSV
+		}
+	}
+	return formatText
+}
+
+// isIPTablesMeta returns whether the line is a table, a chain policy or a commit of iptables-save
+func isIPTablesMeta(line string) bool {
+	switch {
+	case line == "COMMIT" || strings.HasPrefix(line, "*"):
+		return true
+	case len(line) > 1 && line[0] == ':':
+		// chain policies like :INPUT ACCEPT [0:0] or :f2b-sshd - [0:0], ipv6 addresses can only start with ::
+		return line[1] != ':'
+	default:
+		return false
+	}
+}
+
+// ipParser parses the ip ranges of a single file and reports rejected lines
+type ipParser struct {
+	name     string
+	fn       func(ipRange, reason string) error
+	out      io.Writer
+	rejected int
+}
+
+// parse parses the file in the given format, which must not be auto
+func (p *ipParser) parse(br *bufio.Reader, format ipFormat, opts importOptions) error {
+	switch format {
+	case formatCSV:
+		return p.parseCSV(br, opts.CSVRange, opts.CSVReason)
+	case formatJSON:
+		return p.parseJSON(br)
+	case formatNginx:
+		return p.parseLines(br, p.nginxLine)
+	case formatIPTables:
+		return p.parseLines(br, p.iptablesLine)
+	case formatIPSet:
+		return p.parseLines(br, p.ipsetLine)
+	default:
+		return p.parseLines(br, p.textLine)
+	}
+}
+
+// accept validates the ip range and passes it to the callback
+func (p *ipParser) accept(line int, text, ipRange, reason string) error {
+	ipRange, _, err := parseIPLine(ipRange)
+	if err != nil {
+		p.reject(line, text, err)
+		return nil
+	}
+
+	err = p.fn(ipRange, reason)
+	if err != nil {
+		return fmt.Errorf("%s:%d: %w", p.name, line, err)
+	}
+	return nil
+}
+
+func (p *ipParser) reject(line int, text string, err error) {
+	p.rejected++
+	fmt.Fprintf(p.out, "%s:%d: rejected: %v: %s\n", p.name, line, err, text)
+}
+
+// parseLines calls parseLine for every line that is neither empty nor a comment
+func (p *ipParser) parseLines(r io.Reader, parseLine func(line int, text string) error) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, utf8BOM)
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		err := parseLine(line, text)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *ipParser) textLine(line int, text string) error {
+	ipRange, reason, err := parseIPLine(text)
+	if err != nil {
+		p.reject(line, text, err)
+		return nil
+	}
+	return p.accept(line, text, ipRange, reason)
+}
+
+// nginxLine parses deny 1.2.3.0/24; # reason
+func (p *ipParser) nginxLine(line int, text string) error {
+	directive, reason, _ := strings.Cut(text, "#")
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(directive), ";"))
+
+	switch {
+	case len(fields) == 2 && fields[0] == "deny":
+		return p.accept(line, text, fields[1], strings.TrimSpace(reason))
+	case len(fields) > 0 && fields[0] == "allow":
+		// exceptions of the deny list
+		return nil
+	default:
+		p.reject(line, text, errors.New("not a deny directive"))
+		return nil
+	}
+}
+
+// iptablesLine parses rules like -A INPUT -s 1.2.3.0/24 -m comment --comment "reason" -j DROP
+func (p *ipParser) iptablesLine(line int, text string) error {
+	if isIPTablesMeta(text) {
+		return nil
+	}
+	fields := splitQuoted(text)
+
+	var (
+		rule    bool
+		negated bool
+		sources []string
+		target  string
+		reason  string
+	)
+	for idx := 0; idx < len(fields); idx++ {
+		value := ""
+		if idx+1 < len(fields) {
+			value = fields[idx+1]
+		}
+
+		switch fields[idx] {
+		case "-A", "--append", "-I", "--insert":
+			rule = true
+		case "!":
+			negated = true
+			continue
+		case "-s", "--source", "--src", "--src-range":
+			if negated {
+				p.reject(line, text, errors.New("negated source address"))
+				return nil
+			}
+			sources = append(sources, strings.Split(value, ",")...)
+			idx++
+		case "-j", "--jump":
+			target = value
+			idx++
+		case "--comment":
+			reason = value
+			idx++
+		}
+		negated = false
+	}
+
+	switch {
+	case !rule:
+		p.reject(line, text, errors.New("not an iptables rule"))
+		return nil
+	case target != "DROP" && target != "REJECT":
+		// other targets do not block the source
+		return nil
+	case len(sources) == 0:
+		p.reject(line, text, errors.New("rule without source address"))
+		return nil
+	}
+
+	for _, source := range sources {
+		err := p.accept(line, text, source, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipsetLine parses add <set> 1.2.3.0/24 [timeout 300] [comment "reason"]
+func (p *ipParser) ipsetLine(line int, text string) error {
+	fields := splitQuoted(text)
+	switch fields[0] {
+	case "create":
+		return nil
+	case "add":
+	default:
+		p.reject(line, text, errors.New("not an ipset add command"))
+		return nil
+	}
+
+	// add [-exist] <set> <entry> [options...]
+	args := make([]string, 0, len(fields))
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "-") {
+			args = append(args, field)
+		}
+	}
+	if len(args) < 2 {
+		p.reject(line, text, errors.New("missing entry"))
+		return nil
+	}
+
+	reason := ""
+	for idx, option := range args[2:] {
+		switch option {
+		case "nomatch":
+			// exceptions of the set
+			return nil
+		case "comment":
+			if idx+3 < len(args) {
+				reason = args[idx+3]
+			}
+		}
+	}
+
+	// entries of hash:ip,port or hash:net,iface sets
+	ipRange, _, _ := strings.Cut(args[1], ",")
+	return p.accept(line, text, ipRange, reason)
+}
+
+// parseCSV parses csv files with a header, the delimiter may be a comma, a semicolon or a tab.
+// Files without a header must have the ip range in the first and the optional reason in the second column.
+func (p *ipParser) parseCSV(br *bufio.Reader, rangeColumn, reasonColumn string) error {
+	sample, _ := br.Peek(br.Size())
+	header, _, _ := strings.Cut(string(sample), "\n")
+
+	cr := csv.NewReader(br)
+	cr.Comma = ','
+	for _, delimiter := range []rune{';', '\t'} {
+		if strings.Count(header, string(delimiter)) > strings.Count(header, string(cr.Comma)) {
+			cr.Comma = delimiter
+		}
+	}
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	var (
+		first   = true
+		columns csvColumns
+	)
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			p.reject(parseErr.Line, strings.Join(record, string(cr.Comma)), parseErr.Err)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", p.name, err)
+		}
+
+		line, _ := cr.FieldPos(0)
+		if first {
+			first = false
+			record[0] = strings.TrimPrefix(record[0], utf8BOM)
+
+			columns, err = newCSVColumns(record, rangeColumn, reasonColumn)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p.name, err)
+			}
+			if columns.header {
+				continue
+			}
+		}
+
+		text := strings.Join(record, string(cr.Comma))
+		ipRange, err := columns.ipRange(record)
+		if err != nil {
+			p.reject(line, text, err)
+			continue
+		}
+
+		err = p.accept(line, text, ipRange, columns.reason(record))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// csvColumns are the indexes of the columns, -1 for missing columns
+type csvColumns struct {
+	header    bool
+	rangeIdx  int
+	fromIdx   int
+	toIdx     int
+	reasonIdx int
+}
+
+// newCSVColumns maps the header to the columns, records without a known header name are the first data record.
+func newCSVColumns(record []string, rangeColumn, reasonColumn string) (csvColumns, error) {
+	c := csvColumns{rangeIdx: -1, fromIdx: -1, toIdx: -1, reasonIdx: -1}
+	for idx, name := range record {
+		name = strings.TrimSpace(name)
+		normalized := normalizeColumn(name)
+		switch {
+		case rangeColumn != "" && name == rangeColumn:
+			c.rangeIdx = idx
+		case reasonColumn != "" && name == reasonColumn:
+			c.reasonIdx = idx
+		case rangeColumn == "" && c.rangeIdx < 0 && slices.Contains(csvRangeColumns, normalized):
+			c.rangeIdx = idx
+		case rangeColumn == "" && c.fromIdx < 0 && slices.Contains(csvFromColumns, normalized):
+			c.fromIdx = idx
+		case rangeColumn == "" && c.toIdx < 0 && slices.Contains(csvToColumns, normalized):
+			c.toIdx = idx
+		case reasonColumn == "" && c.reasonIdx < 0 && slices.Contains(csvReasonColumns, normalized):
+			c.reasonIdx = idx
+		}
+	}
+
+	switch {
+	case rangeColumn != "" && c.rangeIdx < 0:
+		return c, fmt.Errorf("csv header does not contain the range column %q: %s", rangeColumn, strings.Join(record, ","))
+	case reasonColumn != "" && c.reasonIdx < 0:
+		return c, fmt.Errorf("csv header does not contain the reason column %q: %s", reasonColumn, strings.Join(record, ","))
+	case c.rangeIdx >= 0 || (c.fromIdx >= 0 && c.toIdx >= 0):
+		c.header = true
+		return c, nil
+	}
+
+	if _, _, err := parseIPLine(record[0]); err != nil {
+		return c, fmt.Errorf("csv header does not contain an ip column, use --csv-range: %s", strings.Join(record, ","))
+	}
+	c = csvColumns{rangeIdx: 0, fromIdx: -1, toIdx: -1, reasonIdx: -1}
+	if len(record) > 1 {
+		c.reasonIdx = 1
+	}
+	return c, nil
+}
+
+func (c csvColumns) ipRange(record []string) (string, error) {
+	if c.rangeIdx >= 0 {
+		if c.rangeIdx >= len(record) || strings.TrimSpace(record[c.rangeIdx]) == "" {
+			return "", errors.New("missing ip range")
+		}
+		return record[c.rangeIdx], nil
+	}
+
+	if c.fromIdx >= len(record) || c.toIdx >= len(record) {
+		return "", errors.New("missing ip range")
+	}
+	return record[c.fromIdx] + "-" + record[c.toIdx], nil
+}
+
+func (c csvColumns) reason(record []string) string {
+	if c.reasonIdx < 0 || c.reasonIdx >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[c.reasonIdx])
+}
+
+// normalizeColumn removes case, whitespaces, dashes and underscores, e.g. IP_Address -> ipaddress
+func normalizeColumn(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '-', '_', '.':
+			return -1
+		}
+		return r
+	}, strings.ToLower(name))
+}
+
+// parseJSON parses an array of strings in the text format or objects with the same field names as the csv columns
+func (p *ipParser) parseJSON(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimPrefix(data, []byte(utf8BOM))
+
+	lines := lineCounter{data: data, line: 1}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	token, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("%s: invalid json: %w", p.name, err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("%s: json must be an array of ip ranges", p.name)
+	}
+
+	for dec.More() {
+		offset := dec.InputOffset()
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid json: %w", p.name, lines.lineAt(offset), err)
+		}
+
+		line := lines.lineAt(offset)
+		ipRange, reason, err := jsonIPRange(raw)
+		if err != nil {
+			p.reject(line, string(raw), err)
+			continue
+		}
+
+		err = p.accept(line, string(raw), ipRange, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineCounter counts the lines of the data up to increasing offsets
+type lineCounter struct {
+	data   []byte
+	offset int64
+	line   int
+}
+
+// lineAt returns the line of the next json value behind the offset
+func (lc *lineCounter) lineAt(offset int64) int {
+	for offset < int64(len(lc.data)) && bytes.IndexByte([]byte(" \t\r\n,"), lc.data[offset]) >= 0 {
+		offset++
+	}
+	lc.line += bytes.Count(lc.data[lc.offset:offset], []byte("\n"))
+	lc.offset = offset
+	return lc.line
+}
+
+func jsonIPRange(raw json.RawMessage) (ipRange, reason string, err error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return parseIPLine(s)
+	}
+
+	var obj map[string]any
+	err = json.Unmarshal(raw, &obj)
+	if err != nil {
+		return "", "", errors.New("neither a string nor an object")
+	}
+
+	fields := make(map[string]string, len(obj))
+	for key, value := range obj {
+		if s, ok := value.(string); ok {
+			fields[normalizeColumn(key)] = s
+		}
+	}
+
+	lookup := func(names []string) string {
+		for _, name := range names {
+			if value, found := fields[name]; found {
+				return value
+			}
+		}
+		return ""
+	}
+
+	reason = lookup(csvReasonColumns)
+	if ipRange = lookup(csvRangeColumns); ipRange != "" {
+		return ipRange, reason, nil
+	}
+	from, to := lookup(csvFromColumns), lookup(csvToColumns)
+	if from != "" && to != "" {
+		return from + "-" + to, reason, nil
+	}
+	return "", "", errors.New("missing ip range field")
+}
+
+// splitQuoted splits the line at whitespaces except for quoted strings, which are unquoted
+func splitQuoted(line string) []string {
+	var (
+		fields  []string
+		field   strings.Builder
+		quote   rune
+		inField bool
+	)
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
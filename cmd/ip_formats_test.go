@@ -0,0 +1,616 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// ipEntry is an accepted ip range of a parsed file
+type ipEntry struct {
+	ipRange string
+	reason  string
+}
+
+// parseTestIPs parses the content in the given format and returns the accepted entries and the rejected lines
+func parseTestIPs(t *testing.T, format ipFormat, content string, opts importOptions) ([]ipEntry, []int, error) {
+	t.Helper()
+	var (
+		entries []ipEntry
+		out     bytes.Buffer
+	)
+	p := &ipParser{
+		name: "test",
+		fn: func(ipRange, reason string) error {
+			entries = append(entries, ipEntry{ipRange, reason})
+			return nil
+		},
+		out: &out,
+	}
+	err := p.parse(bufio.NewReader(strings.NewReader(content)), format, opts)
+
+	var rejected []int
+	for _, line := range strings.Split(out.String(), "\n") {
+		// rejected json values may span multiple lines
+		after, found := strings.CutPrefix(line, "test:")
+		if !found {
+			continue
+		}
+		number, _, _ := strings.Cut(after, ":")
+		n, convErr := strconv.Atoi(number)
+		if convErr != nil {
+			t.Fatalf("unexpected output: %s", line)
+		}
+		rejected = append(rejected, n)
+	}
+	if len(rejected) != p.rejected {
+		t.Fatalf("expected %d rejected lines, got %d", p.rejected, len(rejected))
+	}
+	return entries, rejected, err
+}
+
+// expectEntries compares the accepted entries and the rejected lines
+func expectEntries(t *testing.T, entries []ipEntry, rejected []int, wantEntries []ipEntry, wantRejected []int) {
+	t.Helper()
+	if fmt.Sprint(entries) != fmt.Sprint(wantEntries) {
+		t.Errorf("expected entries %v, got %v", wantEntries, entries)
+	}
+	if fmt.Sprint(rejected) != fmt.Sprint(wantRejected) {
+		t.Errorf("expected rejected lines %v, got %v", wantRejected, rejected)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample string
+		want   ipFormat
+	}{
+		{name: "empty", sample: "", want: formatText},
+		{name: "text", sample: "1.2.3.4\n", want: formatText},
+		{name: "text with reason", sample: "# header\n\n10.0.0.0/8 # abuse\n", want: formatText},
+		{name: "ipv6 text", sample: "::1\n2001:db8::/32\n", want: formatText},
+		{name: "json", sample: "  [\"1.2.3.4\"]", want: formatJSON},
+		{name: "json with byte order mark", sample: utf8BOM + "[{\"ip\": \"1.2.3.4\"}]", want: formatJSON},
+		{name: "csv", sample: "ip,reason\n1.2.3.4,abuse\n", want: formatCSV},
+		{name: "csv with semicolons", sample: "network;comment\n", want: formatCSV},
+		{name: "csv without header", sample: "1.2.3.4,abuse\n", want: formatCSV},
+		{name: "nginx", sample: "# blocklist\ndeny 1.2.3.4;\n", want: formatNginx},
+		{name: "nginx allow", sample: "allow 10.0.0.0/8;\n", want: formatNginx},
+		{name: "ipset create", sample: "create blacklist hash:net family inet\n", want: formatIPSet},
+		{name: "ipset add", sample: "add blacklist 1.2.3.4\n", want: formatIPSet},
+		{name: "iptables-save table", sample: "# Generated by iptables-save\n*filter\n", want: formatIPTables},
+		{name: "iptables-save input chain", sample: ":INPUT ACCEPT [0:0]\n", want: formatIPTables},
+		{name: "iptables-save forward chain", sample: ":FORWARD DROP [0:0]\n", want: formatIPTables},
+		{name: "iptables-save custom chain", sample: ":f2b-sshd - [0:0]\n", want: formatIPTables},
+		{name: "iptables rule", sample: "-A INPUT -s 1.2.3.4 -j DROP\n", want: formatIPTables},
+		{name: "iptables command", sample: "iptables -I INPUT -s 1.2.3.4 -j DROP\n", want: formatIPTables},
+		{name: "ip6tables command", sample: "ip6tables -A INPUT -s ::1 -j DROP\n", want: formatIPTables},
+		{name: "incomplete last line", sample: "1.2.3.4\n-A INPUT", want: formatText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectFormat([]byte(tt.sample))
+			if got != tt.want {
+				t.Fatalf("expected format %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsIPTablesMeta(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{line: "*filter", want: true},
+		{line: "COMMIT", want: true},
+		{line: ":INPUT ACCEPT [0:0]", want: true},
+		{line: ":FORWARD DROP [0:0]", want: true},
+		{line: ":DOCKER-USER - [0:0]", want: true},
+		{line: ":f2b-sshd - [0:0]", want: true},
+		{line: "::1", want: false},
+		{line: "::ffff:1.2.3.4 # mapped", want: false},
+		{line: ":", want: false},
+		{line: "-A INPUT -s 1.2.3.4 -j DROP", want: false},
+		{line: "1.2.3.4", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := isIPTablesMeta(tt.line); got != tt.want {
+				t.Fatalf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseText(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		entries  []ipEntry
+		rejected []int
+	}{
+		{
+			name:    "ranges and reasons",
+			content: "1.2.3.4\n10.0.0.0/8 # abuse\n1.2.3.4 - 1.2.3.10 #  spaced reason \n2001:db8::/32#tor\n",
+			entries: []ipEntry{{"1.2.3.4", ""}, {"10.0.0.0/8", "abuse"}, {"1.2.3.4-1.2.3.10", "spaced reason"}, {"2001:db8::/32", "tor"}},
+		},
+		{
+			name:     "rejected lines are counted with comments and empty lines",
+			content:  "# comment\n\n1.2.3.4\nnot an ip\n  \n10.0.0.0/33\n1.2.3.4-::1\n",
+			entries:  []ipEntry{{"1.2.3.4", ""}},
+			rejected: []int{4, 6, 7},
+		},
+		{
+			name:    "byte order mark",
+			content: utf8BOM + "1.2.3.4\n",
+			entries: []ipEntry{{"1.2.3.4", ""}},
+		},
+		{
+			name:    "windows line endings",
+			content: "1.2.3.4 # a\r\n5.6.7.8\r\n",
+			entries: []ipEntry{{"1.2.3.4", "a"}, {"5.6.7.8", ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, rejected, err := parseTestIPs(t, formatText, tt.content, importOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectEntries(t, entries, rejected, tt.entries, tt.rejected)
+		})
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		opts     importOptions
+		entries  []ipEntry
+		rejected []int
+		wantErr  bool
+	}{
+		{
+			name:    "header",
+			content: "IP Address,Comment\n1.2.3.4,abuse\n10.0.0.0/8, spaced \n",
+			entries: []ipEntry{{"1.2.3.4", "abuse"}, {"10.0.0.0/8", "spaced"}},
+		},
+		{
+			name:    "semicolons and additional columns",
+			content: "id;network;reason\n1;1.2.3.0/24;abuse\n2;2001:db8::/32;tor\n",
+			entries: []ipEntry{{"1.2.3.0/24", "abuse"}, {"2001:db8::/32", "tor"}},
+		},
+		{
+			name:    "tabs",
+			content: "cidr\tnote\n1.2.3.0/24\tabuse\n",
+			entries: []ipEntry{{"1.2.3.0/24", "abuse"}},
+		},
+		{
+			name:    "from and to columns",
+			content: "start_ip,end_ip,description\n1.2.3.4,1.2.3.10,hoster\n",
+			entries: []ipEntry{{"1.2.3.4-1.2.3.10", "hoster"}},
+		},
+		{
+			name:    "custom columns",
+			content: "ip,host,why\n1.2.3.4,a,abuse\n",
+			opts:    importOptions{CSVRange: "host", CSVReason: "why"},
+			// the host column does not contain ip ranges
+			rejected: []int{2},
+		},
+		{
+			name:    "custom range column",
+			content: "name,address\na,1.2.3.4\n",
+			opts:    importOptions{CSVRange: "address"},
+			entries: []ipEntry{{"1.2.3.4", ""}},
+		},
+		{
+			name:    "missing custom column",
+			content: "ip,reason\n1.2.3.4,abuse\n",
+			opts:    importOptions{CSVRange: "network"},
+			wantErr: true,
+		},
+		{
+			name:    "missing custom reason column",
+			content: "ip,reason\n1.2.3.4,abuse\n",
+			opts:    importOptions{CSVReason: "why"},
+			wantErr: true,
+		},
+		{
+			name:    "without header",
+			content: "1.2.3.4,abuse\n5.6.7.8\n",
+			entries: []ipEntry{{"1.2.3.4", "abuse"}, {"5.6.7.8", ""}},
+		},
+		{
+			name:    "unknown header",
+			content: "host,why\n1.2.3.4,abuse\n",
+			wantErr: true,
+		},
+		{
+			name:    "byte order mark",
+			content: utf8BOM + "ip\n1.2.3.4\n",
+			entries: []ipEntry{{"1.2.3.4", ""}},
+		},
+		{
+			name:     "rejected records keep their line numbers",
+			content:  "ip,reason\n# comment\n1.2.3.4,\"multi\nline\"\n,missing\nnot an ip,x\n5.6.7.8,ok\n",
+			entries:  []ipEntry{{"1.2.3.4", "multi\nline"}, {"5.6.7.8", "ok"}},
+			rejected: []int{5, 6},
+		},
+		{
+			name:     "missing columns",
+			content:  "from,to\n1.2.3.4\n1.2.3.4,1.2.3.5\n",
+			entries:  []ipEntry{{"1.2.3.4-1.2.3.5", ""}},
+			rejected: []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, rejected, err := parseTestIPs(t, formatCSV, tt.content, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			expectEntries(t, entries, rejected, tt.entries, tt.rejected)
+		})
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		entries  []ipEntry
+		rejected []int
+		wantErr  bool
+	}{
+		{
+			name:    "strings",
+			content: `["1.2.3.4", "10.0.0.0/8 # abuse"]`,
+			entries: []ipEntry{{"1.2.3.4", ""}, {"10.0.0.0/8", "abuse"}},
+		},
+		{
+			name:    "objects",
+			content: `[{"ip": "1.2.3.4", "reason": "abuse"}, {"Start-IP": "1.2.3.4", "End_IP": "1.2.3.10", "comment": "hoster"}, {"network": "2001:db8::/32", "asn": 1}]`,
+			entries: []ipEntry{{"1.2.3.4", "abuse"}, {"1.2.3.4-1.2.3.10", "hoster"}, {"2001:db8::/32", ""}},
+		},
+		{
+			name:     "rejected values keep their line numbers",
+			content:  "[\n  \"1.2.3.4\",\n  {\n    \"host\": \"a\"\n  },\n  42,\n\n  \"not an ip\", \"5.6.7.8\"\n]\n",
+			entries:  []ipEntry{{"1.2.3.4", ""}, {"5.6.7.8", ""}},
+			rejected: []int{3, 6, 8},
+		},
+		{
+			name:    "byte order mark",
+			content: utf8BOM + `["1.2.3.4"]`,
+			entries: []ipEntry{{"1.2.3.4", ""}},
+		},
+		{
+			name:    "not an array",
+			content: `{"ip": "1.2.3.4"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			content: "[\n\"1.2.3.4\",\n{\"ip\": ]",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			content: "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, rejected, err := parseTestIPs(t, formatJSON, tt.content, importOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			expectEntries(t, entries, rejected, tt.entries, tt.rejected)
+		})
+	}
+}
+
+func TestLineCounter(t *testing.T) {
+	data := []byte("[\n\"a\",\n\n  \"b\", \"c\"\n]")
+	lc := lineCounter{data: data, line: 1}
+
+	tests := []struct {
+		offset int64
+		line   int
+	}{
+		{offset: 1, line: 2},
+		{offset: 6, line: 4},
+		{offset: 13, line: 4},
+		{offset: 18, line: 5},
+	}
+	for _, tt := range tests {
+		if got := lc.lineAt(tt.offset); got != tt.line {
+			t.Errorf("expected line %d at offset %d, got %d", tt.line, tt.offset, got)
+		}
+	}
+}
+
+func TestParseNginx(t *testing.T) {
+	content := strings.Join([]string{
+		"# blocklist",
+		"deny 1.2.3.4;",
+		"deny 10.0.0.0/8; # abuse",
+		"allow 10.1.0.0/16;",
+		"deny all;",
+		"limit_req zone=one;",
+		"deny 2001:db8::/32 ;",
+	}, "\n")
+
+	entries, rejected, err := parseTestIPs(t, formatNginx, content, importOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(t, entries, rejected,
+		[]ipEntry{{"1.2.3.4", ""}, {"10.0.0.0/8", "abuse"}, {"2001:db8::/32", ""}},
+		[]int{5, 6},
+	)
+}
+
+func TestParseIPTables(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		entries  []ipEntry
+		rejected []int
+	}{
+		{
+			name: "iptables-save",
+			content: strings.Join([]string{
+				"# Generated by iptables-save",
+				"*filter",
+				":INPUT ACCEPT [0:0]",
+				":FORWARD DROP [0:0]",
+				":f2b-sshd - [0:0]",
+				"-A INPUT -s 1.2.3.4/32 -j DROP",
+				`-A INPUT -s 10.0.0.0/8 -m comment --comment "abuse report" -j REJECT --reject-with icmp-port-unreachable`,
+				"-A f2b-sshd -s 5.6.7.8/32 -j REJECT",
+				"-A f2b-sshd -j RETURN",
+				"COMMIT",
+			}, "\n"),
+			entries: []ipEntry{{"1.2.3.4/32", ""}, {"10.0.0.0/8", "abuse report"}, {"5.6.7.8/32", ""}},
+		},
+		{
+			name: "commands",
+			content: strings.Join([]string{
+				"iptables -I INPUT --source 1.2.3.4 --jump DROP",
+				"ip6tables --append INPUT --src 2001:db8::/32 -j DROP",
+			}, "\n"),
+			entries: []ipEntry{{"1.2.3.4", ""}, {"2001:db8::/32", ""}},
+		},
+		{
+			name:    "comma separated sources",
+			content: "-A INPUT -s 1.2.3.4/32,5.6.7.8/32 -m comment --comment 'two hosts' -j DROP",
+			entries: []ipEntry{{"1.2.3.4/32", "two hosts"}, {"5.6.7.8/32", "two hosts"}},
+		},
+		{
+			name:    "address ranges",
+			content: "-A INPUT -m iprange --src-range 1.2.3.4-1.2.3.10 -j DROP",
+			entries: []ipEntry{{"1.2.3.4-1.2.3.10", ""}},
+		},
+		{
+			name: "negated sources",
+			content: strings.Join([]string{
+				"-A INPUT ! -s 10.0.0.0/8 -j DROP",
+				"-A INPUT -m iprange ! --src-range 1.2.3.4-1.2.3.10 -j DROP",
+				"-A INPUT ! -i lo -s 1.2.3.4 -j DROP",
+			}, "\n"),
+			entries:  []ipEntry{{"1.2.3.4", ""}},
+			rejected: []int{1, 2},
+		},
+		{
+			name: "other targets",
+			content: strings.Join([]string{
+				"-A INPUT -s 10.0.0.0/8 -j ACCEPT",
+				"-A INPUT -s 10.0.0.0/8 -j LOG",
+				"-A INPUT -s 10.0.0.0/8 -j f2b-sshd",
+				"-A INPUT -s 10.0.0.0/8",
+			}, "\n"),
+		},
+		{
+			name: "invalid rules",
+			content: strings.Join([]string{
+				"-N f2b-sshd",
+				"-A INPUT -p tcp --dport 22 -j DROP",
+				"-A INPUT -s example.com -j DROP",
+				"-A INPUT -s 1.2.3.4 -j DROP",
+			}, "\n"),
+			entries:  []ipEntry{{"1.2.3.4", ""}},
+			rejected: []int{1, 2, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, rejected, err := parseTestIPs(t, formatIPTables, tt.content, importOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectEntries(t, entries, rejected, tt.entries, tt.rejected)
+		})
+	}
+}
+
+func TestParseIPSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		entries  []ipEntry
+		rejected []int
+	}{
+		{
+			name: "ipset save",
+			content: strings.Join([]string{
+				"create blacklist hash:net family inet hashsize 1024 maxelem 65536 comment",
+				"add blacklist 1.2.3.0/24",
+				`add blacklist 10.0.0.0/8 comment "abuse report"`,
+				"add -exist blacklist 5.6.7.8 timeout 300 comment 'temporary'",
+				"add blacklist6 2001:db8::/32",
+			}, "\n"),
+			entries: []ipEntry{{"1.2.3.0/24", ""}, {"10.0.0.0/8", "abuse report"}, {"5.6.7.8", "temporary"}, {"2001:db8::/32", ""}},
+		},
+		{
+			name: "exceptions",
+			content: strings.Join([]string{
+				"add blacklist 10.0.0.0/8",
+				"add blacklist 10.1.0.0/16 nomatch",
+				`add blacklist 10.2.0.0/16 comment "nomatch" nomatch`,
+			}, "\n"),
+			entries: []ipEntry{{"10.0.0.0/8", ""}},
+		},
+		{
+			name: "entries with ports and interfaces",
+			content: strings.Join([]string{
+				"create ports hash:ip,port family inet",
+				"add ports 1.2.3.4,tcp:80",
+				"add nets 10.0.0.0/8,eth0 comment iface",
+			}, "\n"),
+			entries: []ipEntry{{"1.2.3.4", ""}, {"10.0.0.0/8", "iface"}},
+		},
+		{
+			name: "invalid commands",
+			content: strings.Join([]string{
+				"add blacklist",
+				"del blacklist 1.2.3.4",
+				"add blacklist example.com",
+				"add blacklist 1.2.3.4",
+			}, "\n"),
+			entries:  []ipEntry{{"1.2.3.4", ""}},
+			rejected: []int{1, 2, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, rejected, err := parseTestIPs(t, formatIPSet, tt.content, importOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectEntries(t, entries, rejected, tt.entries, tt.rejected)
+		})
+	}
+}
+
+func TestReadIPFile(t *testing.T) {
+	gzipped := func(content string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte(content))
+		_ = gz.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		opts    importOptions
+		stdin   bool
+		entries []ipEntry
+		wantErr bool
+	}{
+		{
+			name:    "detected format",
+			content: []byte("deny 1.2.3.4; # abuse\n"),
+			entries: []ipEntry{{"1.2.3.4", "abuse"}},
+		},
+		{
+			name:    "explicit format",
+			content: []byte("add blacklist 1.2.3.4\n"),
+			opts:    importOptions{Format: "IPSET"},
+			entries: []ipEntry{{"1.2.3.4", ""}},
+		},
+		{
+			name:    "gzip",
+			content: gzipped("ip,reason\n1.2.3.4,abuse\n"),
+			entries: []ipEntry{{"1.2.3.4", "abuse"}},
+		},
+		{
+			name:    "gzip with explicit format",
+			content: gzipped(`["1.2.3.4"]`),
+			opts:    importOptions{Format: "json"},
+			entries: []ipEntry{{"1.2.3.4", ""}},
+		},
+		{
+			name:    "stdin",
+			content: gzipped("1.2.3.4 # abuse\n"),
+			stdin:   true,
+			entries: []ipEntry{{"1.2.3.4", "abuse"}},
+		},
+		{
+			name:    "invalid gzip",
+			content: []byte{0x1f, 0x8b, 0x00},
+			wantErr: true,
+		},
+		{
+			name:    "unknown format",
+			content: []byte("1.2.3.4\n"),
+			opts:    importOptions{Format: "xml"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ips")
+			err := os.WriteFile(path, tt.content, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			filename := path
+			if tt.stdin {
+				file, err := os.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+
+				stdin := os.Stdin
+				os.Stdin = file
+				t.Cleanup(func() { os.Stdin = stdin })
+				filename = "-"
+			}
+
+			var entries []ipEntry
+			err = readIPFile(filename, tt.opts, func(ipRange, reason string) error {
+				entries = append(entries, ipEntry{ipRange, reason})
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if fmt.Sprint(entries) != fmt.Sprint(tt.entries) {
+				t.Fatalf("expected entries %v, got %v", tt.entries, entries)
+			}
+		})
+	}
+
+	err := readIPFile(filepath.Join(t.TempDir(), "missing"), importOptions{}, nil)
+	if err == nil {
+		t.Fatal("expected an error for missing files")
+	}
+}
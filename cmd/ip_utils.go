@@ -1,25 +1,54 @@
 package cmd
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/jxsl13/goripr/v2"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
 )
 
 var (
-	splitRegex = regexp.MustCompile(`^\s*([\s0-9\.\-\/]+)\s*(#\s*(.*[^\s])\s*)?$`)
+	splitRegex = regexp.MustCompile(`^\s*([\s0-9a-fA-F\.\:\-\/]+)\s*(#\s*(.*[^\s])\s*)?$`)
 )
 
+// validateIPRange checks that the range is a single ipv4/ipv6 address,
+// a CIDR range or an address range of the form: from-to
+func validateIPRange(ipRange string) error {
+	if strings.Contains(ipRange, "/") {
+		_, err := netip.ParsePrefix(ipRange)
+		return err
+	}
+
+	ips := strings.Split(ipRange, "-")
+	if len(ips) > 2 {
+		return fmt.Errorf("invalid ip range: %s", ipRange)
+	}
+
+	var prev netip.Addr
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return err
+		}
+		if prev.IsValid() && prev.Is4() != addr.Is4() {
+			return fmt.Errorf("invalid ip range, mixed ipv4 and ipv6 boundaries: %s", ipRange)
+		}
+		prev = addr
+	}
+	return nil
+}
+
 func parseIPLine(line string) (ipRange, reason string, err error) {
 	matches := splitRegex.FindStringSubmatch(line)
 	if len(matches) == 0 {
-		return "", "", errors.New("empty")
+		return "", "", errors.New("not an ip range")
 	}
 
 	ipRange = strings.TrimSpace(matches[1])
@@ -27,65 +56,273 @@ func parseIPLine(line string) (ipRange, reason string, err error) {
 
 	ips := strings.Split(ipRange, "-")
 	for idx, ip := range ips {
-		ips[idx] = strings.TrimSpace(ip)
+		parts := strings.Split(ip, "/")
+		for pidx, part := range parts {
+			parts[pidx] = strings.TrimSpace(part)
+		}
+		ips[idx] = strings.Join(parts, "/")
 	}
 	ipRange = strings.Join(ips, "-")
 
+	// hex characters allow for ipv6 addresses but also match plain words
+	err = validateIPRange(ipRange)
+	if err != nil {
+		return "", "", err
+	}
+
 	return ipRange, reason, nil
 }
 
-func parseFileAndAddIPsToCache(ctx context.Context, r *goripr.Client, filename string) (int, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return 0, err
+// rangeMetadata are the structured trailing fields of exported reasons
+type rangeMetadata struct {
+	Source  string
+	Expires time.Time
+}
+
+// formatMetadata returns the trailing fields of exported reasons, e.g. [source=api expires=2024-01-02T15:04:05Z]
+func formatMetadata(source string, expires time.Time) string {
+	var fields []string
+	if source != "" {
+		if strings.ContainsAny(source, " \t\"[]=") {
+			source = strconv.Quote(source)
+		}
+		fields = append(fields, "source="+source)
 	}
+	if !expires.IsZero() {
+		fields = append(fields, "expires="+expires.UTC().Format(time.RFC3339))
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(fields, " ") + "]"
+}
 
-	foundIpRanges := 0
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		ip, reason, err := parseIPLine(line)
-		if err != nil {
-			continue
+// splitMetadata removes the trailing fields of formatMetadata from the reason.
+// Reasons without valid trailing fields are returned unchanged.
+func splitMetadata(reason string) (string, rangeMetadata) {
+	if !strings.HasSuffix(reason, "]") {
+		return reason, rangeMetadata{}
+	}
+
+	// quoted sources may contain brackets as well
+	for idx := strings.Index(reason, "["); idx >= 0; {
+		meta, err := parseMetadata(reason[idx+1 : len(reason)-1])
+		if err == nil {
+			return strings.TrimSpace(reason[:idx]), meta
+		}
+
+		next := strings.Index(reason[idx+1:], "[")
+		if next < 0 {
+			break
+		}
+		idx += next + 1
+	}
+	return reason, rangeMetadata{}
+}
+
+// parseMetadata parses space separated key=value fields, values may be quoted
+func parseMetadata(fields string) (meta rangeMetadata, err error) {
+	found := false
+	for {
+		fields = strings.TrimLeft(fields, " \t")
+		if fields == "" {
+			break
+		}
+
+		key, rest, ok := strings.Cut(fields, "=")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return rangeMetadata{}, fmt.Errorf("invalid field: %s", fields)
+		}
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return rangeMetadata{}, err
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
 		}
+		fields = rest
+
+		switch key {
+		case "source":
+			meta.Source = value
+		case "expires":
+			meta.Expires, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				return rangeMetadata{}, err
+			}
+		default:
+			return rangeMetadata{}, fmt.Errorf("unknown field: %s", key)
+		}
+		found = true
+	}
+
+	if !found {
+		return rangeMetadata{}, errors.New("no fields")
+	}
+	return meta, nil
+}
+
+// parseFileAndAddIPsToCache adds the ip ranges of the file to the blacklist, a ttl of 0 keeps them forever.
+// Overlapping entries of the optional whitelist are evicted, as they were contradicted by the blacklist.
+// Overlapping entries of the optional allowlist are reported or removed with disallow.
+// The source and the expiry of exported ranges take precedence over the file source and the ttl.
+// Exported ranges without an expiry are added permanently.
+func parseFileAndAddIPsToCache(ctx context.Context, bl *vpn.Blacklist, wl vpn.Whitelister, al *vpn.Allowlist, filename string, opts importOptions, ttl time.Duration, disallow bool) (int, error) {
+	source := vpn.SourceFile(sourceName(filename))
+
+	var ipRanges []string
+	err := readIPFile(filename, opts, func(ip, reason string) error {
+		reason, meta := splitMetadata(reason)
+		rangeSource, rangeTTL := source, ttl
+		if meta.Source != "" {
+			// exported ranges without an expiry are permanent
+			rangeSource, rangeTTL = meta.Source, 0
+		}
+		if !meta.Expires.IsZero() {
+			rangeTTL = time.Until(meta.Expires)
+			if rangeTTL <= 0 {
+				fmt.Printf("skipping %s, which expired at %s\n", ip, meta.Expires.Format(time.DateTime))
+				return nil
+			}
+		}
+
 		if reason == "" {
 			fmt.Printf("adding %s\n", ip)
 		} else {
 			fmt.Printf("adding %s (%s)\n", ip, reason)
 		}
-		err = r.Insert(ctx, ip, reason)
+		err := bl.Insert(ctx, ip, reason, rangeSource, rangeTTL)
 		if err != nil {
-			return 0, fmt.Errorf("%w: %s", err, line)
+			return err
 		}
 
-		// counter
-		foundIpRanges++
+		ipRanges = append(ipRanges, ip)
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	return foundIpRanges, nil
-}
 
-func parseFileAndRemoveIPsFromCache(ctx context.Context, r *goripr.Client, filename string) (int, error) {
-	file, err := os.Open(filename)
+	if wl != nil && len(ipRanges) > 0 {
+		evicted, err := wl.Evict(ipRanges...)
+		if err != nil {
+			return 0, err
+		}
+		if evicted > 0 {
+			fmt.Printf("evicted %d overlapping whitelist entries\n", evicted)
+		}
+	}
+
+	err = checkAllowlist(ctx, al, ipRanges, disallow)
 	if err != nil {
 		return 0, err
 	}
+	return len(ipRanges), nil
+}
 
-	foundRanges := 0
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		ip, _, err := parseIPLine(line)
-		if err != nil {
+// checkLegacy warns about ranges of older versions, which were cached without their metadata
+func checkLegacy(ctx context.Context, bl *vpn.Blacklist) error {
+	legacy, err := bl.Legacy(ctx)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		fmt.Fprintln(os.Stderr, legacyWarning)
+	}
+	return nil
+}
+
+const legacyWarning = "warning: the database may contain ranges of older versions without metadata, which are neither listed nor exported, add their files again to record it"
+
+// checkAllowlist reports the allowed ranges that overlap with the blacklisted ranges, as the allowlist
+// takes precedence and the blacklisted ranges would have no effect. disallow removes them instead.
+func checkAllowlist(ctx context.Context, al *vpn.Allowlist, ipRanges []string, disallow bool) error {
+	if al == nil {
+		return nil
+	}
+
+	overlapping, err := al.Overlapping(ctx, ipRanges...)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range overlapping {
+		if !disallow {
+			fmt.Printf("warning: the allowed range %s (%s) overlaps with the added ranges and takes precedence, use --disallow to remove it from the allowlist\n", info.Range, info.Reason)
 			continue
 		}
+
+		err = al.Remove(ctx, info.Range)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed the overlapping range %s (%s) from the allowlist\n", info.Range, info.Reason)
+	}
+	return nil
+}
+
+// parseFileAndRemoveIPsFromCache removes the ip ranges of the file from the blacklist and adds them to the
+// optional allowlist, which prevents them from being blacklisted again.
+func parseFileAndRemoveIPsFromCache(ctx context.Context, bl *vpn.Blacklist, al *vpn.Allowlist, filename string, opts importOptions) (int, error) {
+	source := vpn.SourceFile(sourceName(filename))
+
+	foundRanges := 0
+	err := readIPFile(filename, opts, func(ip, reason string) error {
 		fmt.Printf("removing %s\n", ip)
-		err = r.Remove(ctx, ip)
+		err := bl.Remove(ctx, ip)
 		if err != nil {
-			return 0, err
+			return err
+		}
+
+		if al != nil {
+			reason, _ = splitMetadata(reason)
+			err = al.Add(ctx, ip, reason, source)
+			if err != nil {
+				return err
+			}
 		}
 
 		foundRanges++
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-
 	return foundRanges, nil
 }
+
+// parseFileAndAddIPsToWhitelist adds the ip ranges of the file to the whitelist, a ttl of 0 keeps them forever.
+func parseFileAndAddIPsToWhitelist(wl vpn.Whitelister, filename string, opts importOptions, ttl time.Duration) (int, error) {
+	foundIpRanges := 0
+	err := readIPFile(filename, opts, func(ip, _ string) error {
+		fmt.Printf("whitelisting %s\n", ip)
+		err := wl.WhitelistRange(ip, ttl)
+		if err != nil {
+			return err
+		}
+
+		foundIpRanges++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return foundIpRanges, nil
+}
+
+// sourceName returns the name of the file in the source of its ranges
+func sourceName(filename string) string {
+	if filename == "-" {
+		return "stdin"
+	}
+	return filename
+}
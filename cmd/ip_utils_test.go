@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+	"github.com/jxsl13/goripr/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestExportLineRoundTrip(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		info vpn.RangeInfo
+		line string
+	}{
+		{
+			name: "api detection",
+			info: vpn.RangeInfo{Range: "1.2.3.4", Reason: "VPN (f/o)", Source: vpn.SourceAPI, Expires: expires},
+			line: "1.2.3.4 # VPN (f/o) [source=api expires=2030-01-02T15:04:05Z]",
+		},
+		{
+			name: "permanent range",
+			info: vpn.RangeInfo{Range: "10.0.0.0/8", Reason: "abuse", Source: vpn.SourceManual},
+			line: "10.0.0.0/8 # abuse [source=manual]",
+		},
+		{
+			name: "without reason",
+			info: vpn.RangeInfo{Range: "2001:db8::/32", Source: vpn.SourceFeed("aws")},
+			line: "2001:db8::/32 # [source=feed:aws]",
+		},
+		{
+			name: "reason with brackets",
+			info: vpn.RangeInfo{Range: "1.2.3.0-1.2.3.9", Reason: "[banned] by admin", Source: vpn.SourceFile("my [old] list.txt")},
+			line: `1.2.3.0-1.2.3.9 # [banned] by admin [source="file:my [old] list.txt"]`,
+		},
+		{
+			name: "multi line reason",
+			info: vpn.RangeInfo{Range: "1.2.3.4", Reason: "first\nsecond", Source: vpn.SourceManual, Expires: expires},
+			line: "1.2.3.4 # first second [source=manual expires=2030-01-02T15:04:05Z]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := formatExportLine(tt.info)
+			if line != tt.line {
+				t.Fatalf("expected line %q, got %q", tt.line, line)
+			}
+
+			ipRange, reason, err := parseIPLine(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reason, meta := splitMetadata(reason)
+			if ipRange != tt.info.Range {
+				t.Errorf("expected range %s, got %s", tt.info.Range, ipRange)
+			}
+			// reasons are exported in a single line
+			if want := strings.Join(strings.Fields(tt.info.Reason), " "); reason != want {
+				t.Errorf("expected reason %q, got %q", want, reason)
+			}
+			if meta.Source != tt.info.Source || !meta.Expires.Equal(tt.info.Expires) {
+				t.Errorf("expected source %s and expiry %s, got %s and %s", tt.info.Source, tt.info.Expires, meta.Source, meta.Expires)
+			}
+		})
+	}
+}
+
+func TestValidateIPRange(t *testing.T) {
+	tests := []struct {
+		ipRange string
+		wantErr bool
+	}{
+		{ipRange: "1.2.3.4"},
+		{ipRange: "1.2.3.0/24"},
+		{ipRange: "1.2.3.4-1.2.3.10"},
+		{ipRange: "2001:db8::1"},
+		{ipRange: "2001:db8::/32"},
+		{ipRange: "2001:db8::1-2001:db8::ff"},
+		{ipRange: "::1"},
+		{ipRange: "::ffff:1.2.3.4"},
+		{ipRange: "::ffff:1.2.3.0/120"},
+		{ipRange: "2001:db8::/129", wantErr: true},
+		{ipRange: "2001:db8::g", wantErr: true},
+		{ipRange: "[2001:db8::1]", wantErr: true},
+		{ipRange: "2001:db8::1-2001:db8::2-2001:db8::3", wantErr: true},
+		{ipRange: "1.2.3.4-2001:db8::1", wantErr: true},
+		{ipRange: "2001:db8::1-1.2.3.4", wantErr: true},
+		{ipRange: "::ffff:1.2.3.4-1.2.3.5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ipRange, func(t *testing.T) {
+			err := validateIPRange(tt.ipRange)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSplitMetadata(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+		source string
+	}{
+		{reason: "", want: ""},
+		{reason: "VPN", want: "VPN"},
+		{reason: "[banned]", want: "[banned]"},
+		{reason: "banned [by=admin]", want: "banned [by=admin]"},
+		{reason: "banned [source=api] again", want: "banned [source=api] again"},
+		{reason: "banned [expires=tomorrow]", want: "banned [expires=tomorrow]"},
+		{reason: `banned [source="unterminated]`, want: `banned [source="unterminated]`},
+		{reason: "banned []", want: "banned []"},
+		{reason: "banned [source=api]", want: "banned", source: vpn.SourceAPI},
+		{reason: "[source=manual]", want: "", source: vpn.SourceManual},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			reason, meta := splitMetadata(tt.reason)
+			if reason != tt.want || meta.Source != tt.source {
+				t.Fatalf("expected %q (%s), got %q (%s)", tt.want, tt.source, reason, meta.Source)
+			}
+		})
+	}
+}
+
+// newTestBlacklist creates a blacklist in an in-memory redis server that is stopped at the end of the test
+func newTestBlacklist(t *testing.T) (*vpn.Blacklist, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	ripr, err := goripr.NewClient(context.Background(), goripr.Options{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := vpn.NewBlacklist(ripr, rdb, "test:blacklist:")
+	t.Cleanup(func() { _ = bl.Close() })
+	return bl, rdb
+}
+
+func TestAddExportedRanges(t *testing.T) {
+	ctx := context.Background()
+	bl, _ := newTestBlacklist(t)
+
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	backup := strings.Join([]string{
+		formatExportLine(vpn.RangeInfo{Range: "1.2.3.4", Reason: "VPN (f/o)", Source: vpn.SourceAPI, Expires: expires}),
+		formatExportLine(vpn.RangeInfo{Range: "1.2.3.5", Reason: "VPN (f/o)", Source: vpn.SourceAPI, Expires: time.Now().Add(-time.Hour)}),
+		formatExportLine(vpn.RangeInfo{Range: "10.0.0.0/8", Reason: "abuse", Source: vpn.SourceManual}),
+		"192.168.0.0/16 # plain line",
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "backup.txt")
+	err := os.WriteFile(path, []byte(backup), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := parseFileAndAddIPsToCache(ctx, bl, nil, nil, path, importOptions{}, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 3 {
+		t.Fatalf("expected 3 added ranges, got %d", added)
+	}
+
+	tests := []struct {
+		ipRange string
+		found   bool
+		reason  string
+		source  string
+		// expires is zero for permanent ranges, ranges without metadata expire after the ttl
+		expires time.Time
+	}{
+		{ipRange: "1.2.3.4", found: true, reason: "VPN (f/o)", source: vpn.SourceAPI, expires: expires},
+		{ipRange: "1.2.3.5"},
+		{ipRange: "10.0.0.0/8", found: true, reason: "abuse", source: vpn.SourceManual},
+		{ipRange: "192.168.0.0/16", found: true, reason: "plain line", source: vpn.SourceFile(path), expires: time.Now().Add(time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ipRange, func(t *testing.T) {
+			info, found, err := bl.Info(ctx, tt.ipRange)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.found || info.Reason != tt.reason || info.Source != tt.source {
+				t.Fatalf("expected %t %q from %s, got %t %q from %s", tt.found, tt.reason, tt.source, found, info.Reason, info.Source)
+			}
+			if tt.expires.IsZero() != info.Expires.IsZero() || info.Expires.Sub(tt.expires).Abs() > 2*time.Second {
+				t.Fatalf("expected expiry %s, got %s", tt.expires, info.Expires)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/config"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+	"github.com/jxsl13/goripr/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cobra"
+)
+
+func NewListCmd(ctx context.Context) *cobra.Command {
+
+	listContext := listContext{
+		Ctx:    ctx,
+		Config: config.NewConnect(),
+	}
+
+	cmd := &cobra.Command{
+		Use:          "list",
+		Short:        "list the ip ranges of the database (blacklist)",
+		SilenceUsage: true,
+		RunE:         listContext.ListRunE,
+		Args:         cobra.ExactArgs(0),
+		PostRunE:     listContext.PostRunE,
+	}
+	listContext.registerFilterFlags(cmd)
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PreRunE = listContext.PreRunE(cmd)
+	return cmd
+}
+
+func NewExportCmd(ctx context.Context) *cobra.Command {
+
+	listContext := listContext{
+		Ctx:    ctx,
+		Config: config.NewConnect(),
+	}
+
+	cmd := &cobra.Command{
+		Use:          "export [blacklist.txt]",
+		Short:        "export the ip ranges of the database (blacklist) in the format of add, to stdout without a file",
+		SilenceUsage: true,
+		RunE:         listContext.ExportRunE,
+		Args:         cobra.MaximumNArgs(1),
+		PostRunE:     listContext.PostRunE,
+	}
+	listContext.registerFilterFlags(cmd)
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PreRunE = listContext.PreRunE(cmd)
+	return cmd
+}
+
+type listContext struct {
+	Ctx       context.Context
+	Config    *config.ConnectConfig
+	Redis     *redis.Client
+	Blacklist *vpn.Blacklist
+
+	// filters
+	IP     string
+	CIDR   string
+	Reason string
+
+	ip     netip.Addr
+	prefix netip.Prefix
+}
+
+func (c *listContext) registerFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&c.IP, "ip", "", "only ranges that contain the ip")
+	cmd.Flags().StringVar(&c.CIDR, "cidr", "", "only ranges that overlap with the CIDR range")
+	cmd.Flags().StringVar(&c.Reason, "reason", "", "only ranges whose reason contains the text (case insensitive)")
+}
+
+func (c *listContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+	runParser := config.RegisterFlags(
+		c.Config,
+		true,
+		cmd,
+		config.WithEnvPrefix("TWVPN_"),
+	)
+	return func(cmd *cobra.Command, args []string) error {
+		err := runParser()
+		if err != nil {
+			return err
+		}
+
+		if c.IP != "" {
+			c.ip, err = netip.ParseAddr(c.IP)
+			if err != nil {
+				return fmt.Errorf("invalid ip: %s: %w", c.IP, err)
+			}
+			c.ip = c.ip.Unmap()
+		}
+		if c.CIDR != "" {
+			c.prefix, err = netip.ParsePrefix(c.CIDR)
+			if err != nil {
+				return fmt.Errorf("invalid CIDR range: %s: %w", c.CIDR, err)
+			}
+		}
+
+		ripr, err := goripr.NewClient(
+			c.Ctx,
+			goripr.Options{
+				Addr:     c.Config.RedisAddress,
+				Password: c.Config.RedisPassword,
+				DB:       c.Config.RedisDB,
+			})
+		if err != nil {
+			return err
+		}
+
+		c.Redis = redis.NewClient(&redis.Options{
+			Addr:     c.Config.RedisAddress,
+			Password: c.Config.RedisPassword,
+			DB:       c.Config.RedisDB,
+		})
+		c.Blacklist = vpn.NewBlacklist(ripr, c.Redis, c.Config.RedisBlacklistKeyPrefix)
+		err = checkLegacy(c.Ctx, c.Blacklist)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+func (c *listContext) PostRunE(cmd *cobra.Command, args []string) error {
+	if c.Redis != nil {
+		defer c.Redis.Close()
+	}
+	if c.Blacklist != nil {
+		return c.Blacklist.Close()
+	}
+	return nil
+}
+
+func (c *listContext) ListRunE(cmd *cobra.Command, args []string) error {
+	infos, err := c.ranges()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RANGE\tREASON\tSOURCE\tINSERTED\tEXPIRES")
+	for _, info := range infos {
+		expires := "never"
+		if !info.Expires.IsZero() {
+			expires = "in " + info.Expires.Sub(now).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			info.Range,
+			info.Reason,
+			info.Source,
+			info.Inserted.Format(time.DateTime),
+			expires,
+		)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d ip ranges\n", len(infos))
+	return nil
+}
+
+func (c *listContext) ExportRunE(cmd *cobra.Command, args []string) error {
+	infos, err := c.ranges()
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if len(args) > 0 && args[0] != "-" {
+		out, err = os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	w := bufio.NewWriter(out)
+	for _, info := range infos {
+		_, err = fmt.Fprintln(w, formatExportLine(info))
+		if err != nil {
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if out != os.Stdout {
+		fmt.Printf("exported %d ip ranges to %s\n", len(infos), args[0])
+	}
+	return nil
+}
+
+// ranges returns the unexpired blacklisted ranges that match the filters.
+// Ranges with removed parts are split into their remaining parts.
+func (c *listContext) ranges() ([]vpn.RangeInfo, error) {
+	infos, err := c.Blacklist.List(c.Ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []vpn.RangeInfo
+	for _, info := range infos {
+		for _, part := range info.Parts() {
+			partInfo := info
+			partInfo.Range, partInfo.Removed = part, nil
+			parts = append(parts, partInfo)
+		}
+	}
+
+	now := time.Now()
+	reason := strings.ToLower(c.Reason)
+	result := parts[:0]
+	for _, info := range parts {
+		switch {
+		case info.Expired(now):
+			// not yet removed by the sweeper
+			continue
+		case c.ip.IsValid() && !info.Contains(c.ip):
+			continue
+		case c.prefix.IsValid() && !overlaps(info.Range, c.prefix):
+			continue
+		case !strings.Contains(strings.ToLower(info.Reason), reason):
+			continue
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+// overlaps returns whether the ip range and the CIDR range share at least one address
+func overlaps(ipRange string, prefix netip.Prefix) bool {
+	first, last, err := vpn.ParseRange(ipRange)
+	if err != nil {
+		return false
+	}
+	pFirst, pLast, err := vpn.ParseRange(prefix.String())
+	if err != nil {
+		return false
+	}
+	return !last.Less(pFirst) && !pLast.Less(first)
+}
+
+// formatIPLine is the inverse of parseIPLine
+func formatIPLine(ipRange, reason string) string {
+	// reasons must not span multiple lines
+	reason = strings.Join(strings.Fields(reason), " ")
+	if reason == "" {
+		return ipRange
+	}
+	return ipRange + " # " + reason
+}
+
+// formatExportLine appends the source and the expiry of the range to the reason, which restores them with add
+func formatExportLine(info vpn.RangeInfo) string {
+	reason := strings.Join(strings.Fields(info.Reason), " ")
+	return formatIPLine(info.Range, strings.TrimSpace(reason+" "+formatMetadata(info.Source, info.Expires)))
+}
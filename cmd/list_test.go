@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+)
+
+func TestListRanges(t *testing.T) {
+	ctx := context.Background()
+	bl, _ := newTestBlacklist(t)
+
+	inserted := []struct {
+		ipRange string
+		reason  string
+		ttl     time.Duration
+	}{
+		{"10.0.0.0/16", "manual", 0},
+		{"1.2.3.4", "VPN", time.Hour},
+		{"1.2.3.5", "VPN", time.Nanosecond},
+		{"2001:db8::/32", "TOR (feed:a)", 0},
+	}
+	for _, r := range inserted {
+		err := bl.Insert(ctx, r.ipRange, r.reason, vpn.SourceManual, r.ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := bl.Remove(ctx, "10.0.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		ip     string
+		cidr   string
+		reason string
+		want   []string
+	}{
+		{name: "all", want: []string{"1.2.3.4", "10.0.0.0/24", "10.0.2.0-10.0.255.255", "2001:db8::/32"}},
+		{name: "ip", ip: "10.0.3.1", want: []string{"10.0.2.0-10.0.255.255"}},
+		{name: "removed ip", ip: "10.0.1.1"},
+		{name: "cidr", cidr: "10.0.0.0/23", want: []string{"10.0.0.0/24"}},
+		{name: "reason", reason: "tor", want: []string{"2001:db8::/32"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := listContext{Ctx: ctx, Blacklist: bl, Reason: tt.reason}
+			if tt.ip != "" {
+				c.ip = netip.MustParseAddr(tt.ip)
+			}
+			if tt.cidr != "" {
+				c.prefix = netip.MustParsePrefix(tt.cidr)
+			}
+
+			infos, err := c.ranges()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(infos))
+			for _, info := range infos {
+				got = append(got, info.Range)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/nutsdb/nutsdb"
+)
+
+// openNutsDB opens the local nutsdb database and creates the buckets in case they do not exist, yet.
+func openNutsDB(dir string, buckets ...string) (*nutsdb.DB, error) {
+	nuts, err := nutsdb.Open(
+		nutsdb.DefaultOptions,
+		nutsdb.WithRWMode(nutsdb.MMap),
+		nutsdb.WithDir(dir),
+		nutsdb.WithSegmentSize(1024*1024), // 1MB
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = nuts.Update(func(tx *nutsdb.Tx) error {
+		for _, bucket := range buckets {
+			if tx.ExistBucket(nutsdb.DataStructureBTree, bucket) {
+				continue
+			}
+			err := tx.NewKVBucket(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = nuts.Close()
+		return nil, fmt.Errorf("failed to create bucket: %w", err)
+	}
+	return nuts, nil
+}
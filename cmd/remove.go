@@ -5,7 +5,9 @@ import (
 	"fmt"
 
 	"github.com/jxsl13/TeeworldsEconVPNDetection/config"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
 	"github.com/jxsl13/goripr/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cobra"
 )
 
@@ -24,13 +26,22 @@ func NewRemoveCmd(ctx context.Context) *cobra.Command {
 		RunE:         removeContext.RunE,
 		Args:         cobra.MinimumNArgs(1),
 		PostRunE: func(cmd *cobra.Command, args []string) error {
-			if removeContext.Ripr != nil {
-				return removeContext.Ripr.Close()
+			if removeContext.Redis != nil {
+				defer removeContext.Redis.Close()
+			}
+			if removeContext.Allowlist != nil {
+				defer removeContext.Allowlist.Close()
+			}
+			if removeContext.Blacklist != nil {
+				return removeContext.Blacklist.Close()
 			}
 			return nil
 		},
 	}
 
+	removeContext.Import.registerFlags(cmd)
+	cmd.Flags().BoolVar(&removeContext.Allow, "allow", false, "add the removed ip ranges to the allowlist, which prevents the apis from blacklisting them again")
+
 	// register flags but defer parsing and validation of the final values
 	cmd.PreRunE = removeContext.PreRunE(cmd)
 	return cmd
@@ -39,7 +50,11 @@ func NewRemoveCmd(ctx context.Context) *cobra.Command {
 type removeContext struct {
 	Ctx       context.Context
 	Config    *config.ConnectConfig
-	Ripr      *goripr.Client
+	Redis     *redis.Client
+	Blacklist *vpn.Blacklist
+	Allowlist *vpn.Allowlist
+	Allow     bool
+	Import    importOptions
 	FilePaths []string
 }
 
@@ -67,7 +82,30 @@ func (c *removeContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, arg
 			return err
 		}
 
-		c.Ripr = ripr
+		c.Redis = redis.NewClient(&redis.Options{
+			Addr:     c.Config.RedisAddress,
+			Password: c.Config.RedisPassword,
+			DB:       c.Config.RedisDB,
+		})
+		c.Blacklist = vpn.NewBlacklist(ripr, c.Redis, c.Config.RedisBlacklistKeyPrefix)
+		err = checkLegacy(c.Ctx, c.Blacklist)
+		if err != nil {
+			return err
+		}
+
+		if c.Allow {
+			c.Allowlist, err = newAllowlist(
+				c.Ctx,
+				c.Config.RedisAddress,
+				c.Config.RedisPassword,
+				c.Config.RedisAllowDB,
+				c.Redis,
+				c.Config.RedisAllowKeyPrefix,
+			)
+			if err != nil {
+				return err
+			}
+		}
 		c.FilePaths = args
 		return nil
 	}
@@ -78,8 +116,10 @@ func (c *removeContext) RunE(cmd *cobra.Command, args []string) error {
 		fmt.Printf("removing ips from %s\n", file)
 		removed, err := parseFileAndRemoveIPsFromCache(
 			c.Ctx,
-			c.Ripr,
+			c.Blacklist,
+			c.Allowlist,
 			file,
+			c.Import,
 		)
 		if err != nil {
 			return err
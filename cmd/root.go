@@ -2,18 +2,24 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/jxsl13/TeeworldsEconVPNDetection/admin"
 	"github.com/jxsl13/TeeworldsEconVPNDetection/config"
 	"github.com/jxsl13/TeeworldsEconVPNDetection/econ"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/feed"
 	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
 	"github.com/jxsl13/goripr/v2"
 	"github.com/nutsdb/nutsdb"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cobra"
 )
 
@@ -34,6 +40,15 @@ func NewRootCmd() *cobra.Command {
 		Args:         cobra.ExactArgs(0),
 		PostRunE: func(cmd *cobra.Command, args []string) error {
 			cancel()
+			if rootContext.Nuts != nil {
+				defer rootContext.Nuts.Close()
+			}
+			if rootContext.Allowlist != nil {
+				defer rootContext.Allowlist.Close()
+			}
+			if rootContext.Redis != nil {
+				return rootContext.Redis.Close()
+			}
 			return nil
 		},
 	}
@@ -45,14 +60,35 @@ func NewRootCmd() *cobra.Command {
 	cmd.AddCommand(NewCompletionCmd(cmd.Name()))
 	cmd.AddCommand(NewAddCmd(ctx))
 	cmd.AddCommand(NewRemoveCmd(ctx))
+	cmd.AddCommand(NewListCmd(ctx))
+	cmd.AddCommand(NewExportCmd(ctx))
+	cmd.AddCommand(NewAllowCmd(ctx))
+	cmd.AddCommand(NewWhitelistCmd(ctx))
+	cmd.AddCommand(NewCheckCmd(ctx))
 	return cmd
 }
 
 type rootContext struct {
-	Ctx     context.Context
-	Config  *config.Config
-	Ripr    *goripr.Client
-	Checker *vpn.VPNChecker
+	Ctx         context.Context
+	Config      *config.Config
+	Ripr        *goripr.Client
+	Redis       *redis.Client
+	Blacklist   *vpn.Blacklist
+	Allowlist   *vpn.Allowlist
+	Whitelister vpn.Whitelister
+	Checker     *vpn.VPNChecker
+	Nuts        *nutsdb.DB
+	// Limiters of the apis by their name and window, e.g. iphub.info:1000/24h0m0s
+	Limiters map[string]vpn.Limiter
+
+	// AllowLockedNutsDB continues without the nutsdb database in case it is used by a running detector.
+	// The apis are skipped in case their rate limits are persisted in it, unless ForceAPIs is set.
+	AllowLockedNutsDB bool
+	ForceAPIs         bool
+	// NutsLocked is set in case the nutsdb database is used by a running detector
+	NutsLocked bool
+	// LimitsUnknown is set in case the persisted rate limits of the apis cannot be read
+	LimitsUnknown bool
 }
 
 func (c *rootContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
@@ -68,58 +104,150 @@ func (c *rootContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args
 		if err != nil {
 			return err
 		}
+		return c.connect()
+	}
+}
 
-		ripr, err := goripr.NewClient(
-			c.Ctx,
-			goripr.Options{
-				Addr:     c.Config.RedisAddress,
-				Password: c.Config.RedisPassword,
-				DB:       c.Config.RedisDB,
-			})
-		if err != nil {
+// connect connects to the databases and creates the checker based on the parsed configuration.
+func (c *rootContext) connect() error {
+	ripr, err := goripr.NewClient(
+		c.Ctx,
+		goripr.Options{
+			Addr:     c.Config.RedisAddress,
+			Password: c.Config.RedisPassword,
+			DB:       c.Config.RedisDB,
+		})
+	if err != nil {
+		return err
+	}
+
+	c.Ripr = ripr
+
+	c.Redis = redis.NewClient(&redis.Options{
+		Addr:     c.Config.RedisAddress,
+		Password: c.Config.RedisPassword,
+		DB:       c.Config.RedisDB,
+	})
+	c.Blacklist = vpn.NewBlacklist(ripr, c.Redis, c.Config.RedisBlacklistKeyPrefix)
+	legacy, err := c.Blacklist.Legacy(c.Ctx)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		log.Println("[WARNING]: the database may contain ranges of older versions without metadata, which are neither restored nor exported, add their files again to record it")
+	}
+	c.Allowlist, err = newAllowlist(
+		c.Ctx,
+		c.Config.RedisAddress,
+		c.Config.RedisPassword,
+		c.Config.RedisAllowDB,
+		c.Redis,
+		c.Config.RedisAllowKeyPrefix,
+	)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wl   vpn.Whitelister
+		nuts *nutsdb.DB
+	)
+	if !c.Config.Offline {
+		// only needed for whitelisting non-vpn users and persisting the api rate limits
+		nuts, err = openNutsDB(
+			c.Config.NutsDBDir,
+			c.Config.NutsDBBucket,
+			c.Config.NutsDBRateLimitBucket,
+		)
+		if errors.Is(err, nutsdb.ErrDirLocked) && c.AllowLockedNutsDB {
+			log.Printf("[WARNING]: continuing without the nutsdb whitelist and rate limits, the database is used by a running detector: %v\n", err)
+			c.NutsLocked = true
+			c.LimitsUnknown = !c.Config.RateLimitShared && c.Config.RateLimitStore == "nutsdb"
+		} else if err != nil {
 			return err
 		}
+		c.Nuts = nuts
 
-		c.Ripr = ripr
-
-		var wl *vpn.Whitelister
-		bucket := c.Config.NutsDBBucket
-		if !c.Config.Offline {
-			// only needed for whitelisting non-vpn users
-			nuts, err := nutsdb.Open(
-				nutsdb.DefaultOptions,
-				nutsdb.WithRWMode(nutsdb.MMap),
-				nutsdb.WithDir(c.Config.NutsDBDir),
-				nutsdb.WithSegmentSize(1024*1024), // 1MB
-			)
-			if err != nil {
-				return err
-			}
+		switch {
+		case c.Config.WhitelistStore == "redis":
+			wl = vpn.NewRedisWhitelister(c.Ctx, c.Redis, c.Config.RedisWhitelistKeyPrefix, c.Config.WhitelistTTL)
+		case nuts != nil:
+			wl = vpn.NewNutsWhitelister(nuts, c.Config.NutsDBBucket, c.Config.WhitelistTTL)
+		}
+	}
 
-			err = nuts.Update(func(tx *nutsdb.Tx) error {
-				if !tx.ExistBucket(nutsdb.DataStructureBTree, bucket) {
-					return tx.NewKVBucket(bucket)
-				}
-				return nil
-			})
-			if err != nil {
-				return fmt.Errorf("failed to create bucket: %w", err)
-			}
+	newLimiter := c.newLimiterFunc(nuts)
+	c.Limiters = make(map[string]vpn.Limiter)
+	apis, weights, err := c.Config.APIs(func(name string, window time.Duration, limit int) (vpn.Limiter, error) {
+		limiter, err := newLimiter(name, window, limit)
+		if err != nil {
+			return nil, err
+		}
+		// single window limiters are named after the api only
+		api, _, _ := strings.Cut(name, ":")
+		c.Limiters[fmt.Sprintf("%s:%s", api, vpn.Window{Limit: limit, Duration: window})] = limiter
+		return limiter, nil
+	})
+	if err != nil {
+		return err
+	}
+	local, err := c.Config.LocalAPIs()
+	if err != nil {
+		return err
+	}
+	offline := c.Config.Offline
+	if c.LimitsUnknown && !c.ForceAPIs {
+		// requests with fresh in-memory rate limits would not count against the limits of the detector
+		log.Println("[WARNING]: skipping the apis, their rate limits are used by a running detector")
+		offline = true
+	}
+
+	checker := vpn.NewVPNChecker(
+		c.Ctx,
+		c.Blacklist,
+		c.Allowlist,
+		c.Config.BlacklistTTL,
+		wl,
+		c.Config.WhitelistRecheck,
+		apis,
+		local,
+		weights,
+		c.Config.APITimeout,
+		c.Config.APIQuorum,
+		offline,
+		c.Config.BanThreshold,
+	)
+	c.Checker = checker
+	c.Whitelister = wl
 
-			wl = vpn.NewWhitelister(nuts, bucket, c.Config.WhitelistTTL)
+	return nil
+}
+
+// newLimiterFunc returns a function that creates the api rate limiters based on the configuration.
+// Shared rate limiters are stored in redis, local rate limiters are persisted in the configured store.
+func (c *rootContext) newLimiterFunc(nuts *nutsdb.DB) vpn.NewLimiterFunc {
+	if c.Config.RateLimitShared {
+		return func(name string, window time.Duration, limit int) (vpn.Limiter, error) {
+			key := c.Config.RedisRateLimitKeyPrefix + "shared:" + name
+			return vpn.NewRedisRateLimiter(c.Redis, key, window, limit), nil
 		}
+	}
 
-		checker := vpn.NewVPNChecker(
-			c.Ctx,
-			ripr,
-			wl,
-			c.Config.APIs(),
-			c.Config.Offline,
-			c.Config.BanThreshold,
-		)
-		c.Checker = checker
+	var store vpn.RateLimiterStore
+	switch c.Config.RateLimitStore {
+	case "nutsdb":
+		if nuts != nil {
+			store = vpn.NewNutsRateLimiterStore(nuts, c.Config.NutsDBRateLimitBucket)
+		}
+	case "redis":
+		store = vpn.NewRedisRateLimiterStore(c.Ctx, c.Redis, c.Config.RedisRateLimitKeyPrefix)
+	}
 
-		return nil
+	return func(name string, window time.Duration, limit int) (vpn.Limiter, error) {
+		if store == nil {
+			return vpn.NewRateLimiter(window, limit), nil
+		}
+		return vpn.NewPersistentRateLimiter(store, name, window, limit)
 	}
 }
 
@@ -132,7 +260,7 @@ func (c *rootContext) RunE(cmd *cobra.Command, args []string) error {
 			continue
 		}
 		log.Println("Adding blacklist file: ", file)
-		added, err := parseFileAndAddIPsToCache(c.Ctx, c.Ripr, file)
+		added, err := parseFileAndAddIPsToCache(c.Ctx, c.Blacklist, c.Whitelister, c.Allowlist, file, importOptions{}, 0, false)
 		if err != nil {
 			return err
 		}
@@ -145,13 +273,40 @@ func (c *rootContext) RunE(cmd *cobra.Command, args []string) error {
 			continue
 		}
 		log.Println("Removing whitelist file: ", file)
-		removed, err := parseFileAndRemoveIPsFromCache(c.Ctx, c.Ripr, file)
+		removed, err := parseFileAndRemoveIPsFromCache(c.Ctx, c.Blacklist, c.Allowlist, file, importOptions{})
 		if err != nil {
 			return err
 		}
 		log.Printf("Removed %d ip ranges from %s\n", removed, file)
 	}
 
+	// the importer also removes the ranges of feeds that were removed from the configuration
+	if len(c.Config.Feeds) > 0 {
+		log.Printf("Importing %d feeds\n", len(c.Config.Feeds))
+	}
+	importer := feed.NewImporter(
+		c.Blacklist,
+		c.Redis,
+		c.Config.RedisFeedKeyPrefix,
+		&http.Client{Timeout: c.Config.FeedTimeout},
+		c.Config.Feeds,
+	)
+	go importer.Run(c.Ctx)
+
+	go c.Blacklist.RunSweeper(c.Ctx, c.Config.SweepInterval)
+
+	if c.Config.AdminAddress != "" {
+		srv := admin.NewServer(
+			c.Config.AdminAddress,
+			c.Config.AdminToken,
+			c.Blacklist,
+			c.Allowlist,
+			c.Whitelister,
+			c.Checker,
+		)
+		go srv.Run(c.Ctx)
+	}
+
 	log.Printf("Connecting to %d econ addresses\n", len(c.Config.EconServers))
 	var (
 		startedWG sync.WaitGroup
@@ -170,8 +325,7 @@ func (c *rootContext) RunE(cmd *cobra.Command, args []string) error {
 			c.Checker,
 			c.Config.ReconnectDelay,
 			c.Config.ReconnectTimeout,
-			c.Config.VPNBanTime,
-			c.Config.VPNBanReason,
+			c.Config.Policies,
 			&startedWG,
 			&stoppedWG,
 		)
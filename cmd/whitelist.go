@@ -0,0 +1,351 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/config"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+	"github.com/nutsdb/nutsdb"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cobra"
+)
+
+func NewWhitelistCmd(ctx context.Context) *cobra.Command {
+
+	whitelistContext := whitelistContext{
+		Ctx:    ctx,
+		Config: config.NewWhitelist(),
+	}
+
+	cmd := &cobra.Command{
+		Use:          "whitelist",
+		Short:        "manage the cache of ips and ip ranges that are not vpns",
+		SilenceUsage: true,
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if whitelistContext.Redis != nil {
+				defer whitelistContext.Redis.Close()
+			}
+			if whitelistContext.Nuts != nil {
+				return whitelistContext.Nuts.Close()
+			}
+			return nil
+		},
+	}
+
+	// register flags but defer parsing and validation of the final values
+	cmd.PersistentPreRunE = whitelistContext.PreRunE(cmd)
+
+	importCmd := &cobra.Command{
+		Use:          "import whitelist.txt [more-whitelists.txt...]",
+		Short:        "import ips, CIDR ranges and from-to ranges into the whitelist",
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE:         whitelistContext.ImportRunE,
+	}
+	importCmd.Flags().DurationVar(&whitelistContext.TTL, "ttl", 0, "time to live of the imported ip ranges, 0 keeps them forever")
+	whitelistContext.Import.registerFlags(importCmd)
+	cmd.AddCommand(importCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:          "list",
+		Short:        "list all whitelisted ips and ranges with their remaining time to live",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE:         whitelistContext.ListRunE,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:          "get <ip> [more ips...]",
+		Short:        "show the whitelisted ip and ranges that contain the ips",
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE:         whitelistContext.GetRunE,
+	})
+
+	addCmd := &cobra.Command{
+		Use:          "add <ip range> [more ip ranges...]",
+		Short:        "whitelist ips, CIDR ranges or from-to ranges",
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE:         whitelistContext.AddRunE,
+	}
+	addCmd.Flags().DurationVar(&whitelistContext.TTL, "ttl", 0, "time to live of the added ip ranges, 0 keeps them forever")
+	cmd.AddCommand(addCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:          "remove <ip range> [more ip ranges...]",
+		Short:        "remove all whitelisted ips and ranges that overlap with the ip ranges",
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE:         whitelistContext.RemoveRunE,
+	})
+
+	purgeCmd := &cobra.Command{
+		Use:          "purge",
+		Short:        "remove all whitelisted ips and ranges",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE:         whitelistContext.PurgeRunE,
+	}
+	purgeCmd.Flags().BoolVar(&whitelistContext.Yes, "yes", false, "confirm the removal of all entries")
+	cmd.AddCommand(purgeCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:          "stats",
+		Short:        "show statistics of the whitelist",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE:         whitelistContext.StatsRunE,
+	})
+	return cmd
+}
+
+type whitelistContext struct {
+	Ctx         context.Context
+	Config      *config.WhitelistConfig
+	Redis       *redis.Client
+	Nuts        *nutsdb.DB
+	Whitelister vpn.Whitelister
+	TTL         time.Duration
+	Import      importOptions
+	Yes         bool
+}
+
+func (c *whitelistContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
+	runParser := config.RegisterFlags(
+		c.Config,
+		true,
+		cmd,
+		config.WithEnvPrefix("TWVPN_"),
+	)
+	return func(cmd *cobra.Command, args []string) error {
+		err := runParser()
+		if err != nil {
+			return err
+		}
+
+		if c.Config.WhitelistStore == "redis" {
+			c.Redis = redis.NewClient(&redis.Options{
+				Addr:     c.Config.RedisAddress,
+				Password: c.Config.RedisPassword,
+				DB:       c.Config.RedisDB,
+			})
+		}
+		c.Whitelister, c.Nuts, err = openWhitelister(
+			c.Ctx,
+			c.Config.WhitelistStore,
+			c.Config.NutsDBDir,
+			c.Config.NutsDBBucket,
+			c.Redis,
+			c.Config.RedisWhitelistKeyPrefix,
+			c.Config.WhitelistTTL,
+		)
+		if errors.Is(err, nutsdb.ErrDirLocked) {
+			return fmt.Errorf("the nutsdb database is used by a running detector, stop it or use whitelist.store=redis: %w", err)
+		}
+		return err
+	}
+}
+
+func (c *whitelistContext) ImportRunE(cmd *cobra.Command, args []string) error {
+	for _, file := range args {
+		fmt.Printf("importing ips from %s\n", file)
+		imported, err := parseFileAndAddIPsToWhitelist(c.Whitelister, file, c.Import, c.TTL)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported %d ip ranges from %s into the whitelist\n", imported, file)
+	}
+	return nil
+}
+
+func (c *whitelistContext) ListRunE(cmd *cobra.Command, args []string) error {
+	entries, err := c.Whitelister.List()
+	if err != nil {
+		return err
+	}
+
+	err = printWhitelistEntries(entries)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d whitelisted ips and ranges\n", len(entries))
+	return nil
+}
+
+func (c *whitelistContext) GetRunE(cmd *cobra.Command, args []string) error {
+	for _, ip := range args {
+		entries, err := c.Whitelister.Get(ip)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Printf("%s is not whitelisted\n", ip)
+			continue
+		}
+
+		fmt.Printf("%s is whitelisted by:\n", ip)
+		err = printWhitelistEntries(entries)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *whitelistContext) AddRunE(cmd *cobra.Command, args []string) error {
+	for _, arg := range args {
+		ipRange, _, err := parseIPLine(arg)
+		if err != nil {
+			return fmt.Errorf("invalid ip range: %s: %w", arg, err)
+		}
+
+		err = c.Whitelister.WhitelistRange(ipRange, c.TTL)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("whitelisted %s\n", ipRange)
+	}
+	return nil
+}
+
+func (c *whitelistContext) RemoveRunE(cmd *cobra.Command, args []string) error {
+	ipRanges := make([]string, 0, len(args))
+	for _, arg := range args {
+		ipRange, _, err := parseIPLine(arg)
+		if err != nil {
+			return fmt.Errorf("invalid ip range: %s: %w", arg, err)
+		}
+		ipRanges = append(ipRanges, ipRange)
+	}
+
+	evicted, err := c.Whitelister.Evict(ipRanges...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed %d whitelisted ips and ranges\n", evicted)
+
+	// single ips are only removed from the ranges that contain them by removing these ranges
+	for _, ipRange := range ipRanges {
+		first, last, err := vpn.ParseRange(ipRange)
+		if err != nil || first != last {
+			continue
+		}
+		entries, err := c.Whitelister.Get(first.String())
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Printf("%s is still whitelisted by %s\n", ipRange, e.Key)
+		}
+	}
+	return nil
+}
+
+func (c *whitelistContext) PurgeRunE(cmd *cobra.Command, args []string) error {
+	if !c.Yes {
+		return errors.New("purge removes all whitelisted ips and ranges, confirm with --yes")
+	}
+
+	purged, err := c.Whitelister.Purge()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed %d whitelisted ips and ranges\n", purged)
+	return nil
+}
+
+func (c *whitelistContext) StatsRunE(cmd *cobra.Command, args []string) error {
+	entries, err := c.Whitelister.List()
+	if err != nil {
+		return err
+	}
+
+	var (
+		ips, ranges, ipv4, ipv6, forever int
+		oldest, newest                   time.Time
+	)
+	for _, e := range entries {
+		if strings.Contains(e.Key, "/") {
+			ranges++
+		} else {
+			ips++
+		}
+		if strings.Contains(e.Key, ":") {
+			ipv6++
+		} else {
+			ipv4++
+		}
+		if e.Expires.IsZero() {
+			forever++
+		}
+		if e.Since.IsZero() {
+			continue
+		}
+		if oldest.IsZero() || e.Since.Before(oldest) {
+			oldest = e.Since
+		}
+		if e.Since.After(newest) {
+			newest = e.Since
+		}
+	}
+
+	fmt.Printf("store:     %s\n", c.Config.WhitelistStore)
+	fmt.Printf("entries:   %d\n", len(entries))
+	fmt.Printf("ips:       %d\n", ips)
+	fmt.Printf("ranges:    %d\n", ranges)
+	fmt.Printf("ipv4:      %d\n", ipv4)
+	fmt.Printf("ipv6:      %d\n", ipv6)
+	fmt.Printf("permanent: %d\n", forever)
+	if !oldest.IsZero() {
+		fmt.Printf("oldest:    %s\n", oldest.Format(time.DateTime))
+		fmt.Printf("newest:    %s\n", newest.Format(time.DateTime))
+	}
+	return nil
+}
+
+// printWhitelistEntries prints the entries with the time of their whitelisting and their remaining time to live
+func printWhitelistEntries(entries []vpn.WhitelistEntry) error {
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSINCE\tTTL")
+	for _, e := range entries {
+		since := "unknown"
+		if !e.Since.IsZero() {
+			since = e.Since.Format(time.DateTime)
+		}
+		ttl := "forever"
+		if !e.Expires.IsZero() {
+			ttl = e.Expires.Sub(now).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Key, since, ttl)
+	}
+	return w.Flush()
+}
+
+// openWhitelister opens the configured whitelist store, the nutsdb database is nil for the redis store.
+func openWhitelister(
+	ctx context.Context,
+	store string,
+	nutsDir string,
+	nutsBucket string,
+	rdb *redis.Client,
+	keyPrefix string,
+	ttl time.Duration,
+) (vpn.Whitelister, *nutsdb.DB, error) {
+	switch store {
+	case "redis":
+		return vpn.NewRedisWhitelister(ctx, rdb, keyPrefix, ttl), nil, nil
+	default:
+		nuts, err := openNutsDB(nutsDir, nutsBucket)
+		if err != nil {
+			return nil, nil, err
+		}
+		return vpn.NewNutsWhitelister(nuts, nutsBucket, ttl), nuts, nil
+	}
+}
@@ -4,17 +4,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/econ"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/feed"
 	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
 	"github.com/redis/go-redis/v9"
 )
 
 var (
 	errRedisDatabaseNotFound   = errors.New("could not connect to the redis database, check your REDIS_ADDRESS, REDIS_PASSWORD and make sure your redis database is running")
+	errEconRequired            = errors.New("ECON_ADDRESSES and ECON_PASSWORDS are required")
 	errAddressPasswordMismatch = errors.New("the number of ECON_PASSWORD doesn't match the number of ECON_ADDRESSES, either provide one password for all addresses or one password per address")
 )
 
@@ -26,36 +32,158 @@ func New() *Config {
 	return &Config{
 		RedisAddress: "localhost:6379",
 		RedisDB:      15,
+		RedisAllowDB: 14,
 		NutsDBDir:    "./nutsdata",
 		NutsDBBucket: "whitelist",
 		WhitelistTTL: 7 * 24 * time.Hour,
+		APITimeout:   10 * time.Second,
+		APIQuorum:    1,
+
+		RateLimitStore:          "nutsdb",
+		NutsDBRateLimitBucket:   "ratelimit",
+		RedisRateLimitKeyPrefix: "twvpn:ratelimit:",
+		FeedTimeout:             time.Minute,
+		RedisFeedKeyPrefix:      "twvpn:feed:",
+		RedisBlacklistKeyPrefix: "twvpn:blacklist:",
+		RedisAllowKeyPrefix:     "twvpn:allow:",
+		WhitelistStore:          "nutsdb",
+		RedisWhitelistKeyPrefix: "twvpn:whitelist:",
+		SweepInterval:           time.Minute,
 
 		ReconnectDelay:   10 * time.Second,
 		ReconnectTimeout: 24 * time.Hour,
 		VPNBanReason:     "VPN",
 		VPNBanTime:       5 * time.Minute,
 		BanThreshold:     0.6,
+
+		IPHubWeight:          1,
+		ProxyCheckWeight:     1,
+		VPNApiWeight:         1,
+		IPAPIWeight:          1,
+		IPQualityScoreWeight: 1,
+		GetIPIntelWeight:     1,
+		AbuseIPDBWeight:      1,
+
+		// free plans
+		IPHubRateLimit:          "1000/24h",
+		ProxyCheckRateLimit:     "1000/24h",
+		VPNApiRateLimit:         "1000/24h",
+		IPAPIRateLimit:          "45/1m",
+		IPQualityScoreRateLimit: "5000/720h",
+		GetIPIntelRateLimit:     "15/1m,500/24h",
+		AbuseIPDBRateLimit:      "1000/24h",
+
+		IPHubURL:          vpn.IPHubURL,
+		ProxyCheckURL:     vpn.ProxyCheckURL,
+		VPNApiURL:         vpn.VPNAPIURL,
+		IPQualityScoreURL: vpn.IPQualityScoreURL,
+		GetIPIntelURL:     vpn.GetIPIntelURL,
+		AbuseIPDBURL:      vpn.AbuseIPDBURL,
 	}
 }
 
+// NewWithoutEcon creates the configuration of commands that use the detection without connecting to econ servers.
+func NewWithoutEcon() *Config {
+	c := New()
+	c.withoutEcon = true
+	return c
+}
+
 // Config represents the application configuration
 type Config struct {
+	// withoutEcon does not require any econ addresses and passwords
+	withoutEcon bool
+
 	IPHubToken      string `koanf:"iphub.token" description:"api key for https://iphub.info"`
 	ProxyCheckToken string `koanf:"proxycheck.token" description:"api key for https://proxycheck.io"`
 	VPNApiToken     string `koanf:"vpnapi.token" description:"api key for https://vpnapi.io"`
 
+	IPAPIEnabled        bool   `koanf:"ipapi.enabled" description:"enables https://ip-api.com, which does not require an api key for its free plan"`
+	IPAPIToken          string `koanf:"ipapi.token" description:"optional api key for https://ip-api.com, uses the pro endpoint (implies ipapi.enabled)"`
+	IPQualityScoreToken string `koanf:"ipqualityscore.token" description:"api key for https://ipqualityscore.com"`
+	GetIPIntelContact   string `koanf:"getipintel.contact" validate:"omitempty,email" description:"contact email address for https://getipintel.net, which does not use api keys"`
+	AbuseIPDBToken      string `koanf:"abuseipdb.token" description:"api key for https://abuseipdb.com"`
+
+	IPHubWeight          float64 `koanf:"iphub.weight" validate:"gte=0" description:"weight of the https://iphub.info answers in the weighted vote"`
+	ProxyCheckWeight     float64 `koanf:"proxycheck.weight" validate:"gte=0" description:"weight of the https://proxycheck.io answers in the weighted vote"`
+	VPNApiWeight         float64 `koanf:"vpnapi.weight" validate:"gte=0" description:"weight of the https://vpnapi.io answers in the weighted vote"`
+	IPAPIWeight          float64 `koanf:"ipapi.weight" validate:"gte=0" description:"weight of the https://ip-api.com answers in the weighted vote"`
+	IPQualityScoreWeight float64 `koanf:"ipqualityscore.weight" validate:"gte=0" description:"weight of the https://ipqualityscore.com answers in the weighted vote"`
+	GetIPIntelWeight     float64 `koanf:"getipintel.weight" validate:"gte=0" description:"weight of the https://getipintel.net answers in the weighted vote"`
+	AbuseIPDBWeight      float64 `koanf:"abuseipdb.weight" validate:"gte=0" description:"weight of the https://abuseipdb.com answers in the weighted vote"`
+
+	IPHubRateLimit          string       `koanf:"iphub.ratelimit" validate:"required" description:"comma separated rate limits of your https://iphub.info plan as limit/duration, e.g. 10/1s,1000/24h"`
+	ProxyCheckRateLimit     string       `koanf:"proxycheck.ratelimit" validate:"required" description:"comma separated rate limits of your https://proxycheck.io plan as limit/duration, e.g. 10000/24h"`
+	VPNApiRateLimit         string       `koanf:"vpnapi.ratelimit" validate:"required" description:"comma separated rate limits of your https://vpnapi.io plan as limit/duration, e.g. 1000/24h"`
+	IPAPIRateLimit          string       `koanf:"ipapi.ratelimit" validate:"required" description:"comma separated rate limits of your https://ip-api.com plan as limit/duration, e.g. 45/1m"`
+	IPQualityScoreRateLimit string       `koanf:"ipqualityscore.ratelimit" validate:"required" description:"comma separated rate limits of your https://ipqualityscore.com plan as limit/duration, e.g. 5000/720h"`
+	GetIPIntelRateLimit     string       `koanf:"getipintel.ratelimit" validate:"required" description:"comma separated rate limits of https://getipintel.net as limit/duration, e.g. 15/1m,500/24h"`
+	AbuseIPDBRateLimit      string       `koanf:"abuseipdb.ratelimit" validate:"required" description:"comma separated rate limits of your https://abuseipdb.com plan as limit/duration, e.g. 1000/24h"`
+	IPHubWindows            []vpn.Window `koanf:"-"`
+	ProxyCheckWindows       []vpn.Window `koanf:"-"`
+	VPNApiWindows           []vpn.Window `koanf:"-"`
+	IPAPIWindows            []vpn.Window `koanf:"-"`
+	IPQualityScoreWindows   []vpn.Window `koanf:"-"`
+	GetIPIntelWindows       []vpn.Window `koanf:"-"`
+	AbuseIPDBWindows        []vpn.Window `koanf:"-"`
+
+	IPHubURL          string `koanf:"iphub.url" validate:"required,url" description:"base url of the https://iphub.info api, e.g. a caching proxy or a test server"`
+	ProxyCheckURL     string `koanf:"proxycheck.url" validate:"required,url" description:"base url of the https://proxycheck.io api"`
+	VPNApiURL         string `koanf:"vpnapi.url" validate:"required,url" description:"base url of the https://vpnapi.io api"`
+	IPAPIURL          string `koanf:"ipapi.url" validate:"omitempty,url" description:"base url of the https://ip-api.com api (default: http://ip-api.com or https://pro.ip-api.com with ipapi.token)"`
+	IPQualityScoreURL string `koanf:"ipqualityscore.url" validate:"required,url" description:"base url of the https://ipqualityscore.com api"`
+	GetIPIntelURL     string `koanf:"getipintel.url" validate:"required,url" description:"base url of the https://getipintel.net api"`
+	AbuseIPDBURL      string `koanf:"abuseipdb.url" validate:"required,url" description:"base url of the https://abuseipdb.com api"`
+
+	APITimeout time.Duration `koanf:"api.timeout" validate:"required" description:"maximum time to wait for all vpn detection apis to answer, late answers are ignored"`
+	APIQuorum  int           `koanf:"api.quorum" validate:"min=1" description:"minimum number of valid api answers that are required in order to whitelist an ip"`
+
+	ASNDatabase string `koanf:"asn.database" description:"optional local MaxMind DB file with autonomous system numbers (e.g. GeoLite2-ASN) that is checked before the online apis, also in offline mode"`
+	ASNVPN      string `koanf:"asn.vpn" description:"comma separated list of autonomous system numbers of vpn providers that are flagged by the asn.database, e.g. 9009,AS60068"`
+	ASNHosting  string `koanf:"asn.hosting" description:"comma separated list of autonomous system numbers of hosting providers that are flagged by the asn.database"`
+	ASNVPNs     []int  `koanf:"-"`
+	ASNHostings []int  `koanf:"-"`
+
+	ProvidersFile string     `koanf:"api.providers" description:"optional json file with additional generic http/json apis, see readme"`
+	Providers     []Provider `koanf:"-"`
+
 	RedisAddress  string `koanf:"redis.address" validate:"required"`
 	RedisPassword string `koanf:"redis.password" description:"optional password for the redis database"`
 	RedisDB       int    `koanf:"redis.db.vpn" validate:"gte=0,lte=15" description:"redis database to use for the vpn ip data (0-15)"`
+	RedisAllowDB  int    `koanf:"redis.db.allow" validate:"gte=0,lte=15,nefield=RedisDB" description:"redis database to use for the allowed ip ranges (0-15), must differ from redis.db.vpn"`
+
+	RedisAllowKeyPrefix string `koanf:"redis.allow.prefix" validate:"required" description:"key prefix for the metadata of allowed ranges in the redis database"`
 
 	NutsDBDir    string        `koanf:"nutsdb.dir" validate:"required" description:"directory to store the nutsdb database"`
 	NutsDBBucket string        `koanf:"nutsdb.bucket" validate:"required" description:"bucket name for the nutsdb key value database"`
 	WhitelistTTL time.Duration `koanf:"whitelist.ttl" validate:"required" description:"time to live for whitelisted ips"`
 
-	EconServersString string `koanf:"econ.addresses" validate:"required" description:"comma separated list of econ addresses"`
+	WhitelistRecheck time.Duration `koanf:"whitelist.recheck" description:"optional age after which whitelisted ips are verified again in the background when they join, must be shorter than whitelist.ttl"`
+
+	WhitelistStore          string `koanf:"whitelist.store" validate:"oneof=nutsdb redis" description:"where to cache ips that are not vpns: nutsdb (local) or redis (shared with all detector instances)"`
+	RedisWhitelistKeyPrefix string `koanf:"redis.whitelist.prefix" validate:"required" description:"key prefix for the whitelisted ips in the redis database"`
+
+	RateLimitStore          string `koanf:"ratelimit.store" validate:"oneof=memory nutsdb redis" description:"where to persist the api rate limits across restarts: memory, nutsdb or redis"`
+	RateLimitShared         bool   `koanf:"ratelimit.shared" description:"share the api rate limits with all detector instances that use the same redis database (ignores ratelimit.store)"`
+	NutsDBRateLimitBucket   string `koanf:"nutsdb.ratelimit.bucket" validate:"required" description:"bucket name for the persisted api rate limits in the nutsdb database"`
+	RedisRateLimitKeyPrefix string `koanf:"redis.ratelimit.prefix" validate:"required" description:"key prefix for the persisted api rate limits in the redis database"`
+
+	BlacklistTTL            time.Duration `koanf:"blacklist.ttl" description:"time to live of ips that were detected by the apis, 0 keeps them forever"`
+	SweepInterval           time.Duration `koanf:"blacklist.sweep.interval" validate:"required" description:"interval in which expired ranges are removed from the blacklist"`
+	RedisBlacklistKeyPrefix string        `koanf:"redis.blacklist.prefix" validate:"required" description:"key prefix for the metadata of blacklisted ranges in the redis database"`
+
+	FeedsFile          string        `koanf:"feed.sources" description:"optional json file with vpn and datacenter range feeds that are imported periodically, see readme"`
+	Feeds              []feed.Source `koanf:"-"`
+	FeedTimeout        time.Duration `koanf:"feed.timeout" validate:"required" description:"maximum time to download a feed"`
+	RedisFeedKeyPrefix string        `koanf:"redis.feed.prefix" validate:"required" description:"key prefix for the previously imported feed ranges in the redis database"`
+
+	AdminAddress string `koanf:"admin.address" validate:"omitempty,hostname_port" description:"optional listen address of the http admin api, e.g. localhost:8080"`
+	AdminToken   string `koanf:"admin.token" validate:"required_with=AdminAddress" description:"bearer token that is required by the http admin api"`
+
+	EconServersString string `koanf:"econ.addresses" description:"comma separated list of econ addresses"`
 	EconServers       []string
 
-	EconPasswordsString string `koanf:"econ.passwords" validate:"required" description:"comma separated list of econ passwords"`
+	EconPasswordsString string `koanf:"econ.passwords" description:"comma separated list of econ passwords"`
 	EconPasswords       []string
 	ReconnectDelay      time.Duration `koanf:"reconnect.delay" validate:"required"`
 	ReconnectTimeout    time.Duration `koanf:"reconnect.timeout" validate:"required"`
@@ -63,9 +191,16 @@ type Config struct {
 	VPNBanReason        string        `koanf:"vpn.ban.reason" validate:"required"`
 	Offline             bool          `koanf:"offline" description:" if set to true no api calls will be made if an ip was not found in the database (= distributed ban server)"`
 
-	BanThreshold float64 `koanf:"permaban.threshold" validate:"required" description:"how many percent of the apis must agree on the vpn status for the IP to be added permanently to the blacklist"`
+	PolicyVPN     string        `koanf:"policy.vpn" description:"action for ips detected as vpn: ban[:duration[:reason]], kick[:reason], log or ignore, reasons support {ip}, {category} and {reason} (default: ban with vpn.ban.duration and vpn.ban.reason)"`
+	PolicyProxy   string        `koanf:"policy.proxy" description:"action for ips detected as proxy, see policy.vpn"`
+	PolicyTor     string        `koanf:"policy.tor" description:"action for ips detected as tor exit node, see policy.vpn"`
+	PolicyRelay   string        `koanf:"policy.relay" description:"action for ips detected as relay (e.g. iCloud Private Relay), see policy.vpn"`
+	PolicyHosting string        `koanf:"policy.hosting" description:"action for ips detected as hosting provider, see policy.vpn"`
+	Policies      econ.Policies `koanf:"-"`
+
+	BanThreshold float64 `koanf:"permaban.threshold" validate:"required" description:"weighted score (0-1) of the api confidences that must be reached for the IP to be added permanently to the blacklist"`
 
-	Whitelist string `koanf:"ip.whitelist" description:"comma separated list of files to whitelist"`
+	Whitelist string `koanf:"ip.whitelist" description:"comma separated list of files to whitelist, their ranges are removed from the blacklist and added to the allowlist"`
 	Blacklist string `koanf:"ip.blacklist" description:"comma separated list of files to blacklist"`
 
 	Whitelists []string
@@ -78,21 +213,14 @@ func (c *Config) Validate() error {
 		return err
 	}
 
-	c.EconServers = strings.Split(c.EconServersString, ",")
-	c.EconPasswords = strings.Split(c.EconPasswordsString, ",")
-	c.Whitelists = strings.Split(c.Whitelist, ",")
-	c.Blacklists = strings.Split(c.Blacklist, ",")
+	if !c.withoutEcon && (c.EconServersString == "" || c.EconPasswordsString == "") {
+		return errEconRequired
+	}
 
-	// add password for every econ server.
-	if len(c.EconServers) != len(c.EconPasswords) {
-		if len(c.EconServers) > 1 && len(c.EconPasswords) > 1 {
-			return errAddressPasswordMismatch
-		}
-		if len(c.EconServers) > 1 && len(c.EconPasswords) == 1 {
-			for len(c.EconPasswords) < len(c.EconServers) {
-				c.EconPasswords = append(c.EconPasswords, c.EconPasswords[0])
-			}
-		}
+	servers := strings.Count(c.EconServersString, ",") + 1
+	passwords := strings.Count(c.EconPasswordsString, ",") + 1
+	if servers != passwords && servers > 1 && passwords > 1 {
+		return errAddressPasswordMismatch
 	}
 
 	options := redis.Options{
@@ -115,14 +243,125 @@ func (c *Config) Validate() error {
 		return errors.New("whitelist ttl must be at least 1 second")
 	}
 
+	if c.WhitelistRecheck < 0 || (c.WhitelistRecheck > 0 && c.WhitelistRecheck >= c.WhitelistTTL) {
+		return errors.New("whitelist recheck must be positive and shorter than the whitelist ttl")
+	}
+
+	if c.BlacklistTTL < 0 {
+		return errors.New("blacklist ttl must not be negative")
+	}
+
+	if c.SweepInterval < time.Second {
+		return errors.New("blacklist sweep interval must be at least 1 second")
+	}
+
+	if c.APITimeout <= 0 {
+		return errors.New("api timeout must be positive")
+	}
+
+	if c.FeedTimeout <= 0 {
+		return errors.New("feed timeout must be positive")
+	}
+
+	if c.VPNBanTime < time.Minute {
+		log.Printf("[WARNING]: vpn ban duration %s is shorter than one minute, players are banned for 0 minutes\n", c.VPNBanTime)
+	}
+
+	return nil
+}
+
+// Build derives the parsed fields of the validated configuration, e.g. the econ servers,
+// rate limit windows, asn lists, feeds, providers and ban policies.
+func (c *Config) Build() error {
+	var err error
+	c.EconServers = strings.Split(c.EconServersString, ",")
+	c.EconPasswords = strings.Split(c.EconPasswordsString, ",")
+	c.Whitelists = strings.Split(c.Whitelist, ",")
+	c.Blacklists = strings.Split(c.Blacklist, ",")
+
+	// add password for every econ server.
+	if len(c.EconServers) > 1 && len(c.EconPasswords) == 1 {
+		for len(c.EconPasswords) < len(c.EconServers) {
+			c.EconPasswords = append(c.EconPasswords, c.EconPasswords[0])
+		}
+	}
+
+	for name, rl := range map[string]struct {
+		limit   string
+		windows *[]vpn.Window
+	}{
+		"iphub":          {c.IPHubRateLimit, &c.IPHubWindows},
+		"proxycheck":     {c.ProxyCheckRateLimit, &c.ProxyCheckWindows},
+		"vpnapi":         {c.VPNApiRateLimit, &c.VPNApiWindows},
+		"ipapi":          {c.IPAPIRateLimit, &c.IPAPIWindows},
+		"ipqualityscore": {c.IPQualityScoreRateLimit, &c.IPQualityScoreWindows},
+		"getipintel":     {c.GetIPIntelRateLimit, &c.GetIPIntelWindows},
+		"abuseipdb":      {c.AbuseIPDBRateLimit, &c.AbuseIPDBWindows},
+	} {
+		*rl.windows, err = vpn.ParseWindows(rl.limit)
+		if err != nil {
+			return fmt.Errorf("invalid %s rate limit: %w", name, err)
+		}
+	}
+
+	c.ASNVPNs, err = parseASNs(c.ASNVPN)
+	if err != nil {
+		return fmt.Errorf("invalid vpn asn list: %w", err)
+	}
+
+	c.ASNHostings, err = parseASNs(c.ASNHosting)
+	if err != nil {
+		return fmt.Errorf("invalid hosting asn list: %w", err)
+	}
+
+	if c.FeedsFile != "" {
+		c.Feeds, err = LoadFeeds(c.FeedsFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.ProvidersFile != "" {
+		c.Providers, err = LoadProviders(c.ProvidersFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Policies = econ.Policies{
+		Default: econ.Policy{
+			Action:   econ.ActionBan,
+			Duration: c.VPNBanTime,
+			Reason:   c.VPNBanReason,
+		},
+		Categories: make(map[vpn.Category]econ.Policy, 5),
+	}
+
+	for category, policy := range map[vpn.Category]string{
+		vpn.CategoryVPN:     c.PolicyVPN,
+		vpn.CategoryProxy:   c.PolicyProxy,
+		vpn.CategoryTor:     c.PolicyTor,
+		vpn.CategoryRelay:   c.PolicyRelay,
+		vpn.CategoryHosting: c.PolicyHosting,
+	} {
+		p, err := econ.ParsePolicy(policy, c.Policies.Default)
+		if err != nil {
+			return fmt.Errorf("invalid %s policy: %w", category, err)
+		}
+		c.Policies.Categories[category] = p
+	}
+
 	return nil
 }
 
 // apis returns a list of available apis that is constructed based on the configuration
-func (c *Config) APIs() []vpn.VPN {
-	apis := []vpn.VPN{}
+// as well as the weights of their answers mapped by the api names.
+// newLimiter creates the rate limiters of the apis.
+func (c *Config) APIs(newLimiter vpn.NewLimiterFunc) (apis []vpn.VPN, weights map[string]float64, err error) {
+	apis = []vpn.VPN{}
+	weights = map[string]float64{}
 	if c.Offline {
-		return apis
+		return apis, weights, nil
 	}
 
 	// share client with all apis
@@ -130,15 +369,176 @@ func (c *Config) APIs() []vpn.VPN {
 	httpClient := &http.Client{}
 
 	if c.IPHubToken != "" {
-		apis = append(apis, vpn.NewIPHub(httpClient, c.IPHubToken))
+		limiter, err := vpn.NewLimiter(newLimiter, "iphub.info", c.IPHubWindows)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseURL, err := parseBaseURL(c.IPHubURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		api := vpn.NewIPHub(httpClient, baseURL, c.IPHubToken, limiter)
+		apis = append(apis, api)
+		weights[api.String()] = c.IPHubWeight
 	}
 
 	if c.VPNApiToken != "" {
-		apis = append(apis, vpn.NewVPNAPI(httpClient, c.VPNApiToken))
+		limiter, err := vpn.NewLimiter(newLimiter, "vpnapi.io", c.VPNApiWindows)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseURL, err := parseBaseURL(c.VPNApiURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		api := vpn.NewVPNAPI(httpClient, baseURL, c.VPNApiToken, limiter)
+		apis = append(apis, api)
+		weights[api.String()] = c.VPNApiWeight
 	}
 
 	if c.ProxyCheckToken != "" {
-		apis = append(apis, vpn.NewProxyCheck(httpClient, c.ProxyCheckToken))
+		limiter, err := vpn.NewLimiter(newLimiter, "proxycheck.io", c.ProxyCheckWindows)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseURL, err := parseBaseURL(c.ProxyCheckURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		api := vpn.NewProxyCheck(httpClient, baseURL, c.ProxyCheckToken, limiter)
+		apis = append(apis, api)
+		weights[api.String()] = c.ProxyCheckWeight
+	}
+
+	if c.IPAPIEnabled || c.IPAPIToken != "" {
+		limiter, err := vpn.NewLimiter(newLimiter, "ip-api.com", c.IPAPIWindows)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseURL, err := parseBaseURL(c.IPAPIURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		api := vpn.NewIPAPI(httpClient, baseURL, c.IPAPIToken, limiter)
+		apis = append(apis, api)
+		weights[api.String()] = c.IPAPIWeight
+	}
+
+	if c.IPQualityScoreToken != "" {
+		limiter, err := vpn.NewLimiter(newLimiter, "ipqualityscore.com", c.IPQualityScoreWindows)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseURL, err := parseBaseURL(c.IPQualityScoreURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		api := vpn.NewIPQualityScore(httpClient, baseURL, c.IPQualityScoreToken, limiter)
+		apis = append(apis, api)
+		weights[api.String()] = c.IPQualityScoreWeight
+	}
+
+	if c.GetIPIntelContact != "" {
+		limiter, err := vpn.NewLimiter(newLimiter, "getipintel.net", c.GetIPIntelWindows)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseURL, err := parseBaseURL(c.GetIPIntelURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		api := vpn.NewGetIPIntel(httpClient, baseURL, c.GetIPIntelContact, limiter)
+		apis = append(apis, api)
+		weights[api.String()] = c.GetIPIntelWeight
+	}
+
+	if c.AbuseIPDBToken != "" {
+		limiter, err := vpn.NewLimiter(newLimiter, "abuseipdb.com", c.AbuseIPDBWindows)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseURL, err := parseBaseURL(c.AbuseIPDBURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		api := vpn.NewAbuseIPDB(httpClient, baseURL, c.AbuseIPDBToken, limiter)
+		apis = append(apis, api)
+		weights[api.String()] = c.AbuseIPDBWeight
+	}
+
+	for _, p := range c.Providers {
+		if _, found := weights[p.Name]; found {
+			return nil, nil, fmt.Errorf("provider name %s is already used by another api", p.Name)
+		}
+		limiter, err := vpn.NewLimiter(newLimiter, p.Name, p.Windows)
+		if err != nil {
+			return nil, nil, err
+		}
+		api, err := vpn.NewGenericAPI(
+			httpClient,
+			p.Name,
+			p.URL,
+			p.Key,
+			p.Headers,
+			p.Expression,
+			vpn.Category(p.Category),
+			limiter,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		apis = append(apis, api)
+		weights[api.String()] = p.Weight
+	}
+	return apis, weights, nil
+}
+
+// LocalAPIs returns the local databases that do not require any network access.
+func (c *Config) LocalAPIs() ([]vpn.VPN, error) {
+	apis := []vpn.VPN{}
+	if c.ASNDatabase == "" {
+		return apis, nil
+	}
+
+	db, err := vpn.NewASNDatabase(c.ASNDatabase, c.ASNVPNs, c.ASNHostings)
+	if err != nil {
+		return nil, err
+	}
+	return append(apis, db), nil
+}
+
+// parseASNs parses a comma separated list of autonomous system numbers of the form AS1234 or 1234
+func parseASNs(s string) ([]int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	fields := strings.Split(s, ",")
+	asns := make([]int, 0, len(fields))
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if len(field) >= 2 && strings.EqualFold(field[:2], "AS") {
+			field = field[2:]
+		}
+		asn, err := strconv.Atoi(field)
+		if err != nil || asn <= 0 {
+			return nil, fmt.Errorf("invalid autonomous system number: %s", field)
+		}
+		asns = append(asns, asn)
+	}
+	return asns, nil
+}
+
+// parseBaseURL parses the configured base url of an api.
+// An empty url results in nil, which selects the default url of the api.
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	if baseURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %s: %w", baseURL, err)
 	}
-	return apis
+	return u, nil
 }
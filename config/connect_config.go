@@ -13,12 +13,30 @@ type ConnectConfig struct {
 	RedisAddress  string `koanf:"redis.address" validate:"required"`
 	RedisPassword string `koanf:"redis.password"`
 	RedisDB       int    `koanf:"redis.db.vpn"`
+	RedisAllowDB  int    `koanf:"redis.db.allow" validate:"nefield=RedisDB" description:"redis database to use for the allowed ip ranges, must differ from redis.db.vpn"`
+
+	RedisBlacklistKeyPrefix string `koanf:"redis.blacklist.prefix" validate:"required" description:"key prefix for the metadata of blacklisted ranges in the redis database"`
+	RedisAllowKeyPrefix     string `koanf:"redis.allow.prefix" validate:"required" description:"key prefix for the metadata of allowed ranges in the redis database"`
+
+	NutsDBDir               string `koanf:"nutsdb.dir" validate:"required" description:"directory to store the nutsdb database"`
+	NutsDBBucket            string `koanf:"nutsdb.bucket" validate:"required" description:"bucket name for the nutsdb key value database"`
+	WhitelistStore          string `koanf:"whitelist.store" validate:"oneof=nutsdb redis" description:"where to cache ips that are not vpns: nutsdb (local) or redis (shared with all detector instances)"`
+	RedisWhitelistKeyPrefix string `koanf:"redis.whitelist.prefix" validate:"required" description:"key prefix for the whitelisted ips in the redis database"`
 }
 
 func NewConnect() *ConnectConfig {
 	return &ConnectConfig{
 		RedisAddress: "localhost:6379",
 		RedisDB:      15,
+		RedisAllowDB: 14,
+
+		RedisBlacklistKeyPrefix: "twvpn:blacklist:",
+		RedisAllowKeyPrefix:     "twvpn:allow:",
+
+		NutsDBDir:               "./nutsdata",
+		NutsDBBucket:            "whitelist",
+		WhitelistStore:          "nutsdb",
+		RedisWhitelistKeyPrefix: "twvpn:whitelist:",
 	}
 }
 
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/feed"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+)
+
+// Feed is a list of ip ranges that is defined in the feeds file and imported periodically, e.g.
+//
+//	[
+//	  {
+//	    "name": "x4bnet-vpn",
+//	    "url": "https://raw.githubusercontent.com/X4BNet/lists_vpn/main/output/vpn/ipv4.txt",
+//	    "format": "plain",
+//	    "category": "vpn",
+//	    "interval": "24h"
+//	  }
+//	]
+type Feed struct {
+	Name     string `json:"name" validate:"required,excludesall=()"`
+	URL      string `json:"url" validate:"required"`
+	Format   string `json:"format" validate:"oneof=plain aws gcp azure"`
+	Category string `json:"category" validate:"oneof=vpn proxy tor relay hosting"`
+	Interval string `json:"interval" validate:"required"`
+}
+
+// LoadFeeds reads the feed definitions from the given json file.
+func LoadFeeds(path string) ([]feed.Source, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feeds file: %w", err)
+	}
+
+	var raw []json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feeds file: %s: %w", path, err)
+	}
+
+	var (
+		validate = validator.New()
+		sources  = make([]feed.Source, 0, len(raw))
+		names    = make(map[string]bool, len(raw))
+	)
+	for idx, r := range raw {
+		f := Feed{
+			Format:   string(feed.FormatPlain),
+			Category: string(vpn.CategoryVPN),
+			Interval: "24h",
+		}
+		err = json.Unmarshal(r, &f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid feed %d in %s: %w", idx, path, err)
+		}
+
+		err = validate.Struct(&f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid feed %d in %s: %w", idx, path, err)
+		}
+
+		if names[f.Name] {
+			return nil, fmt.Errorf("duplicate feed name in %s: %s", path, f.Name)
+		}
+		names[f.Name] = true
+
+		interval, err := time.ParseDuration(f.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s interval: %w", f.Name, err)
+		}
+		if interval < time.Minute {
+			return nil, fmt.Errorf("invalid %s interval: must be at least one minute", f.Name)
+		}
+
+		sources = append(sources, feed.Source{
+			Name:     f.Name,
+			URL:      f.URL,
+			Format:   feed.Format(f.Format),
+			Category: vpn.Category(f.Category),
+			Interval: interval,
+		})
+	}
+	return sources, nil
+}
@@ -72,10 +72,15 @@ type Validatable interface {
 	Validate() error
 }
 
+type Buildable interface {
+	Build() error
+}
+
 // Parse takes every object and is able to fill and validate that object depending on config file, env file and flag values.
 // https://github.com/knadh/koanf
 // Your passed struct must define . delimited koanf struct tags in order to match env/.env and flag values to your struct.
 // Additionally your struct may define a Validate() error method which is called at the end of parsing the config
+// and a Build() error method which is called after a successful validation in order to derive further fields.
 // Registers flags and returns a parser function that can be used as PreRunE.
 func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, options ...ParseOption) func() error {
 
@@ -378,7 +383,13 @@ func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, option
 
 		var a any = config
 		if v, ok := a.(Validatable); ok {
-			return v.Validate()
+			err = v.Validate()
+			if err != nil {
+				return err
+			}
+		}
+		if b, ok := a.(Buildable); ok {
+			return b.Build()
 		}
 		return nil
 	}
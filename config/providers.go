@@ -0,0 +1,84 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+)
+
+// Provider is a generic http/json api that is defined in the providers file, e.g.
+//
+//	[
+//	  {
+//	    "name": "example.com",
+//	    "url": "https://api.example.com/v1/{ip}?key={key}",
+//	    "key": "123456",
+//	    "headers": {"Accept": "application/json"},
+//	    "expression": "security.vpn",
+//	    "category": "vpn",
+//	    "ratelimit": "1000/24h",
+//	    "weight": 1
+//	  }
+//	]
+type Provider struct {
+	Name       string            `json:"name" validate:"required"`
+	URL        string            `json:"url" validate:"required"`
+	Key        string            `json:"key"`
+	Headers    map[string]string `json:"headers"`
+	Expression string            `json:"expression" validate:"required"`
+	Category   string            `json:"category" validate:"oneof=vpn proxy tor relay hosting"`
+	RateLimit  string            `json:"ratelimit" validate:"required"`
+	Weight     float64           `json:"weight" validate:"gte=0"`
+
+	Windows []vpn.Window `json:"-"`
+}
+
+// LoadProviders reads the generic api definitions from the given json file.
+func LoadProviders(path string) ([]Provider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read providers file: %w", err)
+	}
+
+	var raw []json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid providers file: %s: %w", path, err)
+	}
+
+	var (
+		validate  = validator.New()
+		providers = make([]Provider, 0, len(raw))
+		names     = make(map[string]bool, len(raw))
+	)
+	for idx, r := range raw {
+		p := Provider{
+			Category: string(vpn.CategoryVPN),
+			Weight:   1,
+		}
+		err = json.Unmarshal(r, &p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider %d in %s: %w", idx, path, err)
+		}
+
+		err = validate.Struct(&p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider %d in %s: %w", idx, path, err)
+		}
+
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate provider name in %s: %s", path, p.Name)
+		}
+		names[p.Name] = true
+
+		p.Windows, err = vpn.ParseWindows(p.RateLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s rate limit: %w", p.Name, err)
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
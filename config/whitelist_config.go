@@ -0,0 +1,66 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+)
+
+// WhitelistConfig is the configuration of the commands that manage the whitelist of ips that are not vpns.
+type WhitelistConfig struct {
+	RedisAddress  string `koanf:"redis.address" validate:"required"`
+	RedisPassword string `koanf:"redis.password"`
+	RedisDB       int    `koanf:"redis.db.vpn"`
+
+	NutsDBDir    string        `koanf:"nutsdb.dir" validate:"required" description:"directory to store the nutsdb database"`
+	NutsDBBucket string        `koanf:"nutsdb.bucket" validate:"required" description:"bucket name for the nutsdb key value database"`
+	WhitelistTTL time.Duration `koanf:"whitelist.ttl" validate:"required" description:"time to live for whitelisted ips"`
+
+	WhitelistStore          string `koanf:"whitelist.store" validate:"oneof=nutsdb redis" description:"where to cache ips that are not vpns: nutsdb (local) or redis (shared with all detector instances)"`
+	RedisWhitelistKeyPrefix string `koanf:"redis.whitelist.prefix" validate:"required" description:"key prefix for the whitelisted ips in the redis database"`
+}
+
+func NewWhitelist() *WhitelistConfig {
+	return &WhitelistConfig{
+		RedisAddress: "localhost:6379",
+		RedisDB:      15,
+		NutsDBDir:    "./nutsdata",
+		NutsDBBucket: "whitelist",
+		WhitelistTTL: 7 * 24 * time.Hour,
+
+		WhitelistStore:          "nutsdb",
+		RedisWhitelistKeyPrefix: "twvpn:whitelist:",
+	}
+}
+
+func (c *WhitelistConfig) Validate() error {
+	err := validator.New().Struct(c)
+	if err != nil {
+		return err
+	}
+
+	if c.WhitelistStore != "redis" {
+		return nil
+	}
+
+	options := redis.Options{
+		Addr:     c.RedisAddress,
+		Password: c.RedisPassword,
+		DB:       c.RedisDB,
+	}
+
+	redisClient := redis.NewClient(&options)
+	defer redisClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pong, err := redisClient.Ping(ctx).Result()
+	if err != nil || pong != "PONG" {
+		return fmt.Errorf("%w: %v", errRedisDatabaseNotFound, err)
+	}
+
+	return nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/netip"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,22 +15,54 @@ import (
 )
 
 var (
-	// 0: full 1: ID 2: IP
-	ddnetJoinRegex = regexp.MustCompile(`(?i)player has entered the game\. ClientID=([\d]+) addr=[^\d]{0,2}([\d]{1,3}\.[\d]{1,3}\.[\d]{1,3}\.[\d]{1,3})[^\d]{0,2}`)
+	// 0: full 1: ID 2: IP (with optional port, ipv6 optionally in [addr]:port form)
+	ddnetJoinRegex = regexp.MustCompile(`(?i)player has entered the game\. ClientID=([\d]+) addr=<?\{?(\[[a-f0-9\.\:]+\](?::[\d]+)?|[a-f0-9\.\:]+)`)
 
 	// 0: full 1: ID 2: IP 3: port 4: version 5: name 6: clan 7: country
 	playerzCatchJoinRegex = regexp.MustCompile(`(?i)id=([\d]+) addr=([a-fA-F0-9\.\:\[\]]+):([\d]+) version=(\d+) name='(.{0,20})' clan='(.{0,16})' country=([-\d]+)$`)
 
-	// 0: full 1: ID 2: IP
-	playerVanillaJoinRegex = regexp.MustCompile(`(?i)player is ready\. ClientID=([\d]+) addr=[^\d]{0,2}([\d]{1,3}\.[\d]{1,3}\.[\d]{1,3}\.[\d]{1,3})[^\d]{0,2}`)
+	// 0: full 1: ID 2: IP (with optional port, ipv6 optionally in [addr]:port form)
+	playerVanillaJoinRegex = regexp.MustCompile(`(?i)player is ready\. ClientID=([\d]+) addr=<?\{?(\[[a-f0-9\.\:]+\](?::[\d]+)?|[a-f0-9\.\:]+)`)
 )
 
+// parseJoinAddr extracts the IP from an address that was logged by the server.
+// Supported are ipv4 and ipv6 addresses with or without port, e.g.
+// 1.2.3.4, 1.2.3.4:8303, 2001:db8::1, [2001:db8::1] and [2001:db8::1]:8303
+func parseJoinAddr(addr string) (string, error) {
+	if addrPort, err := netip.ParseAddrPort(addr); err == nil {
+		return addrPort.Addr().Unmap().String(), nil
+	}
+
+	ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+	if err != nil {
+		return "", fmt.Errorf("invalid join address: %s: %w", addr, err)
+	}
+	return ip.Unmap().String(), nil
+}
+
+// parseJoinLine returns the client id and the ip of a join message, ok is false for other lines.
+func parseJoinLine(line string) (clientID, ip string, ok bool, err error) {
+	for _, re := range []*regexp.Regexp{ddnetJoinRegex, playerzCatchJoinRegex, playerVanillaJoinRegex} {
+		matches := re.FindStringSubmatch(line)
+		if len(matches) == 0 {
+			continue
+		}
+
+		ip, err = parseJoinAddr(matches[2])
+		if err != nil {
+			return "", "", true, err
+		}
+		return matches[1], ip, true, nil
+	}
+	return "", "", false, nil
+}
+
 func vpnCheck(
 	econ *econ.Conn,
+	clientID string,
 	ip string,
 	checker *vpn.VPNChecker,
-	vpnBantime time.Duration,
-	vpnBanReason string,
+	policies Policies,
 ) {
 
 	isVPN, reason, err := checker.IsVPN(ip)
@@ -37,20 +71,41 @@ func vpnCheck(
 		return
 	}
 
-	// vpn is saved as 1, banserver bans as text
-	if isVPN {
-		tag := "[banserver] :" // manually added with custom reason
-		minutes := int(vpnBantime.Minutes())
+	if !isVPN {
+		log.Println("[clean ip]: ", ip)
+		return
+	}
 
+	// vpn is saved as 1, banserver bans as text
+	category := vpn.CategoryOf(reason)
+	if category == vpn.CategoryNone {
+		// manually added with or without custom reason
+		tag := "[banserver] :"
+		policy := policies.Default
 		if reason == "" {
-			tag = "[is a vpn]  :" //
-			reason = vpnBanReason
+			tag = "[is a vpn]  :"
+			reason = policy.FormatReason(ip, vpn.CategoryVPN, reason)
 		}
 
-		_ = econ.WriteLine(fmt.Sprintf("ban %s %d %s", ip, minutes, reason))
+		_ = econ.WriteLine(fmt.Sprintf("ban %s %d %s", ip, int(policy.Duration.Minutes()), reason))
 		log.Println(tag, ip, "(", reason, ")")
-	} else {
-		log.Println("[clean ip]: ", ip)
+		return
+	}
+
+	policy := policies.For(category)
+	switch policy.Action {
+	case ActionBan:
+		banReason := policy.FormatReason(ip, category, reason)
+		_ = econ.WriteLine(fmt.Sprintf("ban %s %d %s", ip, int(policy.Duration.Minutes()), banReason))
+		log.Printf("[is a %s] : %s ( %s )\n", category, ip, banReason)
+	case ActionKick:
+		kickReason := policy.FormatReason(ip, category, reason)
+		_ = econ.WriteLine(fmt.Sprintf("kick %s %s", clientID, kickReason))
+		log.Printf("[kicked %s] : %s ( %s )\n", category, ip, kickReason)
+	case ActionLog:
+		log.Printf("[is a %s] : %s ( %s ) not banned\n", category, ip, reason)
+	case ActionIgnore:
+		// nothing to do
 	}
 }
 
@@ -61,8 +116,7 @@ func NewEvaluationRoutine(
 	checker *vpn.VPNChecker,
 	reconnDelay time.Duration,
 	reconnTimeout time.Duration,
-	vpnBantime time.Duration,
-	vpnBanReason string,
+	policies Policies,
 	startedWG *sync.WaitGroup,
 	stoppedWG *sync.WaitGroup,
 ) {
@@ -88,9 +142,6 @@ func NewEvaluationRoutine(
 
 	accumulatedRetryTime := time.Duration(0)
 	retries := 0
-	var matches []string
-	var ip string
-
 	for {
 		if retries == 0 {
 			log.Println("Connected to server:", addr)
@@ -123,22 +174,20 @@ func NewEvaluationRoutine(
 			}
 
 			// TODO: check if it's a join message synchronously
-			if matches = ddnetJoinRegex.FindStringSubmatch(line); len(matches) > 0 {
-				ip = matches[2]
-			} else if matches = playerzCatchJoinRegex.FindStringSubmatch(line); len(matches) > 0 {
-				ip = matches[2]
-			} else if matches = playerVanillaJoinRegex.FindStringSubmatch(line); len(matches) > 0 {
-				ip = matches[2]
-			} else {
+			clientID, ip, ok, err := parseJoinLine(line)
+			if !ok {
+				continue
+			} else if err != nil {
+				log.Printf("Failed to parse join line of server %s: %v\n", addr, err)
 				continue
 			}
 			log.Printf("%s joined server %s\n", ip, addr)
 			go vpnCheck(
 				econ,
+				clientID,
 				ip,
 				checker,
-				vpnBantime,
-				vpnBanReason,
+				policies,
 			)
 
 		}
@@ -0,0 +1,159 @@
+package econ
+
+import "testing"
+
+func TestParseJoinAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		ip      string
+		wantErr bool
+	}{
+		{addr: "1.2.3.4", ip: "1.2.3.4"},
+		{addr: "1.2.3.4:8303", ip: "1.2.3.4"},
+		{addr: "2001:db8::1", ip: "2001:db8::1"},
+		{addr: "[2001:db8::1]", ip: "2001:db8::1"},
+		{addr: "[2001:db8::1]:8303", ip: "2001:db8::1"},
+		{addr: "::1", ip: "::1"},
+		{addr: "[::1]:8303", ip: "::1"},
+		{addr: "::ffff:1.2.3.4", ip: "1.2.3.4"},
+		{addr: "[::ffff:1.2.3.4]:8303", ip: "1.2.3.4"},
+		{addr: "[::ffff:1.2.3.4]", ip: "1.2.3.4"},
+		{addr: "", wantErr: true},
+		{addr: "1.2.3", wantErr: true},
+		{addr: "[2001:db8::1]:port", wantErr: true},
+		{addr: "2001:db8::1:8303:1:2:3:4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			ip, err := parseJoinAddr(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if ip != tt.ip {
+				t.Fatalf("expected ip %q, got %q", tt.ip, ip)
+			}
+		})
+	}
+}
+
+func TestParseJoinLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		ok       bool
+		clientID string
+		ip       string
+		wantErr  bool
+	}{
+		{
+			name:     "ddnet ipv4",
+			line:     "[server]: player has entered the game. ClientID=3 addr=<{1.2.3.4:8303}> sixup=0",
+			ok:       true,
+			clientID: "3",
+			ip:       "1.2.3.4",
+		},
+		{
+			name:     "ddnet bracketed ipv6",
+			line:     "[server]: player has entered the game. ClientID=12 addr=<{[2001:db8::1]:8303}> sixup=0",
+			ok:       true,
+			clientID: "12",
+			ip:       "2001:db8::1",
+		},
+		{
+			name:     "ddnet bracketed loopback",
+			line:     "[server]: player has entered the game. ClientID=0 addr=<{[::1]:50000}> sixup=1",
+			ok:       true,
+			clientID: "0",
+			ip:       "::1",
+		},
+		{
+			name:     "ddnet bare ipv6",
+			line:     "[server]: player has entered the game. ClientID=1 addr=2001:db8::1",
+			ok:       true,
+			clientID: "1",
+			ip:       "2001:db8::1",
+		},
+		{
+			name:     "ddnet ipv4-mapped ipv6",
+			line:     "[server]: player has entered the game. ClientID=4 addr=<{[::ffff:1.2.3.4]:8303}> sixup=0",
+			ok:       true,
+			clientID: "4",
+			ip:       "1.2.3.4",
+		},
+		{
+			name:     "zcatch ipv4",
+			line:     "[server]: id=5 addr=1.2.3.4:8303 version=1284 name='nameless' clan='' country=-1",
+			ok:       true,
+			clientID: "5",
+			ip:       "1.2.3.4",
+		},
+		{
+			name:     "zcatch bracketed ipv6",
+			line:     "[server]: id=6 addr=[2001:db8::1]:8303 version=1284 name='nameless' clan='tee' country=276",
+			ok:       true,
+			clientID: "6",
+			ip:       "2001:db8::1",
+		},
+		{
+			name:     "zcatch bare ipv6 with port",
+			line:     "[server]: id=7 addr=2001:db8::1:8303 version=1284 name='nameless' clan='' country=-1",
+			ok:       true,
+			clientID: "7",
+			ip:       "2001:db8::1",
+		},
+		{
+			name:     "zcatch ipv4-mapped ipv6",
+			line:     "[server]: id=8 addr=[::ffff:1.2.3.4]:8303 version=1284 name='nameless' clan='' country=-1",
+			ok:       true,
+			clientID: "8",
+			ip:       "1.2.3.4",
+		},
+		{
+			name:     "vanilla ipv4",
+			line:     "[game]: player is ready. ClientID=9 addr=1.2.3.4:8303",
+			ok:       true,
+			clientID: "9",
+			ip:       "1.2.3.4",
+		},
+		{
+			name:     "vanilla bracketed ipv6",
+			line:     "[game]: player is ready. ClientID=10 addr=[2001:db8::1]:8303",
+			ok:       true,
+			clientID: "10",
+			ip:       "2001:db8::1",
+		},
+		{
+			name:     "vanilla bare ipv6",
+			line:     "[game]: player is ready. ClientID=11 addr=::1",
+			ok:       true,
+			clientID: "11",
+			ip:       "::1",
+		},
+		{
+			name:    "invalid address",
+			line:    "[server]: player has entered the game. ClientID=2 addr=<{1.2.3:8303}> sixup=0",
+			ok:      true,
+			wantErr: true,
+		},
+		{
+			name: "other line",
+			line: "[server]: player has left the game. ClientID=3 addr=<{1.2.3.4:8303}>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientID, ip, ok, err := parseJoinLine(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("expected join line %t, got %t", tt.ok, ok)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if clientID != tt.clientID || ip != tt.ip {
+				t.Fatalf("expected client %q with ip %q, got %q with ip %q", tt.clientID, tt.ip, clientID, ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package econ
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+)
+
+var (
+	// an empty {reason} placeholder is removed together with its brackets and the separator in front of it,
+	// or the separator after it in case it is at the beginning of the template
+	leadingReasonRegex = regexp.MustCompile(`^\s*[(\[]?\{reason\}[)\]]?\s*[-:|,;/]?\s*`)
+	reasonRegex        = regexp.MustCompile(`\s*[-:|,;/]?\s*[(\[]?\{reason\}[)\]]?`)
+)
+
+// Action is executed for players whose ip was detected
+type Action string
+
+const (
+	ActionBan    Action = "ban"
+	ActionKick   Action = "kick"
+	ActionLog    Action = "log"
+	ActionIgnore Action = "ignore"
+)
+
+// Policy defines what happens to a player whose ip was detected
+type Policy struct {
+	Action   Action
+	Duration time.Duration
+	// Reason template, supports the placeholders {ip}, {category} and {reason}
+	Reason string
+}
+
+// ParsePolicy parses policies of the form
+// ban[:duration[:reason]], kick[:reason], log or ignore.
+// Missing ban durations and reasons are taken from the default policy.
+func ParsePolicy(s string, defaultPolicy Policy) (Policy, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return defaultPolicy, nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	action := Action(strings.ToLower(strings.TrimSpace(parts[0])))
+	args := ""
+	if len(parts) == 2 {
+		args = strings.TrimSpace(parts[1])
+	}
+
+	policy := Policy{
+		Action:   action,
+		Duration: defaultPolicy.Duration,
+		Reason:   defaultPolicy.Reason,
+	}
+
+	switch action {
+	case ActionBan:
+		if args == "" {
+			return policy, nil
+		}
+		banArgs := strings.SplitN(args, ":", 2)
+		if d := strings.TrimSpace(banArgs[0]); d != "" {
+			duration, err := time.ParseDuration(d)
+			if err != nil {
+				return Policy{}, fmt.Errorf("invalid ban duration in policy %q: %w", s, err)
+			}
+			if duration < time.Minute {
+				return Policy{}, fmt.Errorf("invalid ban duration in policy %q: must be at least one minute", s)
+			}
+			policy.Duration = duration
+		}
+		if len(banArgs) == 2 && strings.TrimSpace(banArgs[1]) != "" {
+			policy.Reason = strings.TrimSpace(banArgs[1])
+		}
+	case ActionKick:
+		if args != "" {
+			policy.Reason = args
+		}
+	case ActionLog, ActionIgnore:
+		if args != "" {
+			return Policy{}, fmt.Errorf("invalid policy %q: %s does not accept any arguments", s, action)
+		}
+	default:
+		return Policy{}, fmt.Errorf("invalid policy %q: unknown action, expected one of ban, kick, log or ignore", s)
+	}
+	return policy, nil
+}
+
+// FormatReason replaces the placeholders of the reason template.
+// An empty reason removes the {reason} placeholder without leaving a dangling separator.
+func (p Policy) FormatReason(ip string, category vpn.Category, reason string) string {
+	template := p.Reason
+	if reason == "" {
+		template = leadingReasonRegex.ReplaceAllString(template, "")
+		template = reasonRegex.ReplaceAllString(template, "")
+	}
+	return strings.NewReplacer(
+		"{ip}", ip,
+		"{category}", string(category),
+		"{reason}", reason,
+	).Replace(template)
+}
+
+// Policies maps the detected categories to their policies.
+// Categories without an explicit policy use the default policy.
+type Policies struct {
+	Default    Policy
+	Categories map[vpn.Category]Policy
+}
+
+// For returns the policy of the given category
+func (p Policies) For(category vpn.Category) Policy {
+	if policy, found := p.Categories[category]; found {
+		return policy
+	}
+	return p.Default
+}
@@ -0,0 +1,43 @@
+package econ
+
+import (
+	"testing"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+)
+
+func TestFormatReason(t *testing.T) {
+	tests := []struct {
+		template string
+		category vpn.Category
+		reason   string
+		want     string
+	}{
+		// default template of vpn.ban.reason
+		{template: "VPN", category: vpn.CategoryVPN, want: "VPN"},
+		{template: "VPN", category: vpn.CategoryTor, reason: "TOR (f/o)", want: "VPN"},
+		{template: "{category} {ip}", category: vpn.CategoryProxy, want: "proxy 1.2.3.4"},
+		{template: "{reason}", category: vpn.CategoryVPN, reason: "VPN (f/o)", want: "VPN (f/o)"},
+		{template: "{reason}", category: vpn.CategoryVPN, want: ""},
+		{template: "VPN ({reason})", category: vpn.CategoryVPN, reason: "VPN (f/o)", want: "VPN (VPN (f/o))"},
+		{template: "VPN ({reason})", category: vpn.CategoryVPN, want: "VPN"},
+		{template: "VPN [{reason}]", category: vpn.CategoryVPN, want: "VPN"},
+		{template: "VPN - {reason}", category: vpn.CategoryVPN, want: "VPN"},
+		{template: "VPN: {reason}", category: vpn.CategoryVPN, want: "VPN"},
+		{template: "VPN, {reason}, {ip}", category: vpn.CategoryVPN, want: "VPN, 1.2.3.4"},
+		{template: "{reason} - {category}", category: vpn.CategoryTor, want: "tor"},
+		{template: "({reason}) {category}", category: vpn.CategoryTor, want: "tor"},
+		{template: "{category}: {reason} ({ip})", category: vpn.CategoryHosting, want: "hosting (1.2.3.4)"},
+		{template: "{category}: {reason} ({ip})", category: vpn.CategoryHosting, reason: "HOSTING (f/o)", want: "hosting: HOSTING (f/o) (1.2.3.4)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template+"/"+tt.reason, func(t *testing.T) {
+			p := Policy{Action: ActionBan, Reason: tt.template}
+			got := p.FormatReason("1.2.3.4", tt.category, tt.reason)
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
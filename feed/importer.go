@@ -0,0 +1,278 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrEmptyFeed is returned in case a feed that was imported before does not contain any ranges anymore.
+// Such feeds are most likely broken, which is why their previous import is kept.
+var ErrEmptyFeed = errors.New("feed does not contain any ip ranges")
+
+// Source is a periodically imported list of ip ranges
+type Source struct {
+	// Name identifies the feed and is part of the cache reason of its ranges
+	Name string
+	// URL of the feed, file:// urls and plain paths are read from the local file system
+	URL string
+	// Format of the feed
+	Format Format
+	// Category of the ranges of the feed
+	Category vpn.Category
+	// Interval between two imports
+	Interval time.Duration
+}
+
+// Reason returns the cache reason of the ranges of the feed
+func (s Source) Reason() string {
+	return vpn.FeedReason(s.Category, s.Name)
+}
+
+// NewImporter creates a new importer that inserts the ranges of the sources into the goripr cache.
+// The ranges of the previous import of every feed are stored in a redis set with the given key prefix
+// in order to remove ranges that are not part of the feed anymore.
+func NewImporter(bl *vpn.Blacklist, rdb *redis.Client, keyPrefix string, c *http.Client, sources []Source) *Importer {
+	return &Importer{
+		bl:        bl,
+		rdb:       rdb,
+		keyPrefix: keyPrefix,
+		client:    c,
+		sources:   sources,
+	}
+}
+
+// Importer imports feeds into the goripr cache
+type Importer struct {
+	bl        *vpn.Blacklist
+	rdb       *redis.Client
+	keyPrefix string
+	client    *http.Client
+	sources   []Source
+
+	// serializes the changes of the imports, as removals restore overlapping ranges of other feeds
+	mu sync.Mutex
+}
+
+// Run removes the ranges of feeds that are not configured anymore, imports every feed immediately
+// and then periodically until the context is canceled.
+func (i *Importer) Run(ctx context.Context) {
+	removed, err := i.Cleanup(ctx)
+	if err != nil {
+		log.Printf("[ERROR]: feed cleanup: %v\n", err)
+	} else if removed > 0 {
+		log.Printf("[feed]: removed %d ip ranges of feeds that are not configured anymore\n", removed)
+	}
+
+	var wg sync.WaitGroup
+	for _, src := range i.sources {
+		wg.Add(1)
+		go func(src Source) {
+			defer wg.Done()
+			i.run(ctx, src)
+		}(src)
+	}
+	wg.Wait()
+}
+
+func (i *Importer) run(ctx context.Context, src Source) {
+	ticker := time.NewTicker(src.Interval)
+	defer ticker.Stop()
+
+	for {
+		added, removed, err := i.Import(ctx, src)
+		if err != nil {
+			log.Printf("[ERROR]: feed %s: %v\n", src.Name, err)
+		} else {
+			log.Printf("[feed]: %s: added %d, removed %d ip ranges\n", src.Name, added, removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Import fetches the feed and applies the difference to its previous import.
+func (i *Importer) Import(ctx context.Context, src Source) (added, removed int, err error) {
+	data, err := i.fetch(ctx, src.URL)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ranges, err := Parse(src.Format, data)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse feed: %w", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var (
+		key       = i.keyPrefix + src.Name
+		reasonKey = key + ":reason"
+		reason    = src.Reason()
+	)
+	previous, err := i.rdb.SMembers(ctx, key).Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch previous import: %w", err)
+	}
+
+	previousReason, err := i.rdb.Get(ctx, reasonKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, fmt.Errorf("failed to fetch previous import: %w", err)
+	}
+
+	if len(ranges) == 0 && len(previous) > 0 {
+		return 0, 0, ErrEmptyFeed
+	}
+
+	current := make(map[string]bool, len(ranges))
+	for _, r := range ranges {
+		current[r] = true
+	}
+
+	old := make(map[string]bool, len(previous))
+	var stale []string
+	for _, r := range previous {
+		old[r] = true
+		if current[r] {
+			continue
+		}
+
+		// ranges that were added again by other sources in the meantime are kept
+		info, found, err := i.bl.Info(ctx, r)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to fetch metadata of %s: %w", r, err)
+		}
+		if found && info.Source != vpn.SourceFeed(src.Name) {
+			continue
+		}
+		stale = append(stale, r)
+	}
+
+	// the overlapping parts of other ranges, including the remaining ranges of this feed, are restored
+	err = i.bl.Withdraw(ctx, stale...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to remove ranges: %w", err)
+	}
+	removed = len(stale)
+
+	// a changed category or name requires all ranges to be inserted with the new reason.
+	reinsert := previousReason != reason
+	for _, r := range ranges {
+		if old[r] && !reinsert {
+			continue
+		}
+		// removed ranges are tracked by the feed itself, which is why they do not expire
+		err = i.bl.Insert(ctx, r, reason, vpn.SourceFeed(src.Name), 0)
+		if err != nil {
+			return added, removed, fmt.Errorf("failed to insert %s: %w", r, err)
+		}
+		if !old[r] {
+			added++
+		}
+	}
+
+	// replace the previous import
+	_, err = i.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, key)
+		if len(ranges) > 0 {
+			members := make([]any, 0, len(ranges))
+			for _, r := range ranges {
+				members = append(members, r)
+			}
+			p.SAdd(ctx, key, members...)
+		}
+		p.Set(ctx, reasonKey, reason, 0)
+		return nil
+	})
+	if err != nil {
+		return added, removed, fmt.Errorf("failed to store import: %w", err)
+	}
+	return added, removed, nil
+}
+
+// Cleanup removes the ranges and the previous imports of feeds that are not configured anymore
+// and returns the number of removed ranges.
+func (i *Importer) Cleanup(ctx context.Context) (int, error) {
+	configured := make(map[string]bool, len(i.sources))
+	for _, src := range i.sources {
+		configured[src.Name] = true
+	}
+
+	infos, err := i.bl.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	stale := make(map[string][]string)
+	for _, info := range infos {
+		name, found := vpn.FeedName(info.Source)
+		if found && !configured[name] {
+			stale[name] = append(stale[name], info.Range)
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	removed := 0
+	for name, ranges := range stale {
+		err = i.bl.Withdraw(ctx, ranges...)
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove ranges of feed %s: %w", name, err)
+		}
+		removed += len(ranges)
+
+		key := i.keyPrefix + name
+		err = i.rdb.Del(ctx, key, key+":reason").Err()
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove previous import of feed %s: %w", name, err)
+		}
+	}
+	return removed, nil
+}
+
+// fetch reads the feed from the local file system or via http
+func (i *Importer) fetch(ctx context.Context, feedURL string) ([]byte, error) {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "":
+		return os.ReadFile(feedURL)
+	case "file":
+		return os.ReadFile(u.Path)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	response, err := i.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("response code is not 200: %d", response.StatusCode)
+	}
+	return io.ReadAll(response.Body)
+}
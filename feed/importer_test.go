@@ -0,0 +1,301 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jxsl13/TeeworldsEconVPNDetection/vpn"
+	"github.com/jxsl13/goripr/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const testPrefix = "test:feed:"
+
+// newTestBlacklist creates a blacklist in an in-memory redis server that is stopped at the end of the test
+func newTestBlacklist(t *testing.T) (*vpn.Blacklist, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	ripr, err := goripr.NewClient(context.Background(), goripr.Options{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := vpn.NewBlacklist(ripr, rdb, "test:blacklist:")
+	t.Cleanup(func() { _ = bl.Close() })
+	return bl, rdb
+}
+
+// feedServer serves the current content of the feed
+type feedServer struct {
+	mu      sync.Mutex
+	content string
+}
+
+func (f *feedServer) Set(content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.content = content
+}
+
+func (f *feedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, _ = w.Write([]byte(f.content))
+}
+
+// newFeedSource serves a plain feed with the given name
+func newFeedSource(t *testing.T, name string) (Source, *feedServer) {
+	t.Helper()
+	f := &feedServer{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return Source{
+		Name:     name,
+		URL:      srv.URL,
+		Format:   FormatPlain,
+		Category: vpn.CategoryVPN,
+		Interval: time.Hour,
+	}, f
+}
+
+// expectReasons checks the cached reason of every ip, an empty reason is not blacklisted
+func expectReasons(t *testing.T, bl *vpn.Blacklist, reasons map[string]string) {
+	t.Helper()
+	for ip, want := range reasons {
+		reason, err := bl.Find(context.Background(), ip)
+		if errors.Is(err, goripr.ErrIPNotFound) {
+			reason, err = "", nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason != want {
+			t.Errorf("expected %s to be blacklisted with %q, got %q", ip, want, reason)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	feedReason := vpn.FeedReason(vpn.CategoryVPN, "a")
+
+	tests := []struct {
+		name string
+		// manual ranges that are added before the first import
+		manual []string
+		// manual ranges that are added after the first import
+		manualBetween []string
+		// contents of the feed of the successive imports
+		imports []string
+
+		wantErr error
+		added   int
+		removed int
+		reasons map[string]string
+	}{
+		{
+			name:    "new ranges are added",
+			imports: []string{"10.0.0.0/24\n10.0.1.0/24\n"},
+			added:   2,
+			reasons: map[string]string{
+				"10.0.0.1": feedReason,
+				"10.0.1.1": feedReason,
+				"10.0.2.1": "",
+			},
+		},
+		{
+			name:    "stale ranges are removed",
+			imports: []string{"10.0.0.0/24\n10.0.1.0/24\n", "10.0.1.0/24\n10.0.2.0/24\n"},
+			added:   1,
+			removed: 1,
+			reasons: map[string]string{
+				"10.0.0.1": "",
+				"10.0.1.1": feedReason,
+				"10.0.2.1": feedReason,
+			},
+		},
+		{
+			name:    "overlapping manual ranges are kept",
+			manual:  []string{"10.0.0.0/16"},
+			imports: []string{"10.0.0.0/24\n10.0.1.0/24\n", "10.0.1.0/24\n"},
+			removed: 1,
+			reasons: map[string]string{
+				"10.0.0.1": "manual",
+				"10.0.1.1": feedReason,
+				"10.0.2.1": "manual",
+			},
+		},
+		{
+			name:    "overlapping ranges of the feed are kept",
+			imports: []string{"10.0.0.0/16\n10.0.1.0/24\n", "10.0.0.0/16\n"},
+			removed: 1,
+			reasons: map[string]string{
+				"10.0.1.1": feedReason,
+			},
+		},
+		{
+			name:          "ranges added by other sources are kept",
+			manualBetween: []string{"10.0.0.0/24"},
+			imports:       []string{"10.0.0.0/24\n10.0.1.0/24\n", "10.0.1.0/24\n"},
+			reasons: map[string]string{
+				"10.0.0.1": "manual",
+				"10.0.1.1": feedReason,
+			},
+		},
+		{
+			name:    "empty feeds keep the previous import",
+			imports: []string{"10.0.0.0/24\n", "# no ranges\n"},
+			wantErr: ErrEmptyFeed,
+			reasons: map[string]string{
+				"10.0.0.1": feedReason,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			bl, rdb := newTestBlacklist(t)
+			src, f := newFeedSource(t, "a")
+			importer := NewImporter(bl, rdb, testPrefix, http.DefaultClient, []Source{src})
+
+			insertManual := func(ranges []string) {
+				for _, r := range ranges {
+					err := bl.Insert(ctx, r, "manual", vpn.SourceManual, 0)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			insertManual(tt.manual)
+			var (
+				added, removed int
+				err            error
+			)
+			for idx, content := range tt.imports {
+				if idx == 1 {
+					insertManual(tt.manualBetween)
+				}
+				f.Set(content)
+				added, removed, err = importer.Import(ctx, src)
+				if idx < len(tt.imports)-1 && err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if added != tt.added || removed != tt.removed {
+				t.Errorf("expected added %d, removed %d, got added %d, removed %d", tt.added, tt.removed, added, removed)
+			}
+			expectReasons(t, bl, tt.reasons)
+		})
+	}
+}
+
+func TestImportFetchErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		handler http.HandlerFunc
+	}{
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(2 * time.Second):
+				case <-r.Context().Done():
+				}
+			},
+		},
+		{
+			name:   "invalid document",
+			format: FormatAWS,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"prefixes":`))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bl, rdb := newTestBlacklist(t)
+			srv := httptest.NewServer(tt.handler)
+			t.Cleanup(srv.Close)
+
+			src := Source{Name: "a", URL: srv.URL, Format: tt.format, Category: vpn.CategoryVPN}
+			importer := NewImporter(bl, rdb, testPrefix, &http.Client{Timeout: 100 * time.Millisecond}, []Source{src})
+
+			_, _, err := importer.Import(context.Background(), src)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+	bl, rdb := newTestBlacklist(t)
+	a, fa := newFeedSource(t, "a")
+	b, fb := newFeedSource(t, "b")
+	fa.Set("10.0.0.0/16\n")
+	fb.Set("10.0.1.0/24\n10.1.0.0/24\n")
+
+	err := bl.Insert(ctx, "10.1.0.0/16", "manual", vpn.SourceManual, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importer := NewImporter(bl, rdb, testPrefix, http.DefaultClient, []Source{a, b})
+	for _, src := range []Source{a, b} {
+		_, _, err = importer.Import(ctx, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// feed b was removed from the configuration
+	importer = NewImporter(bl, rdb, testPrefix, http.DefaultClient, []Source{a})
+	removed, err := importer.Cleanup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected 2 removed ranges, got %d", removed)
+	}
+
+	expectReasons(t, bl, map[string]string{
+		"10.0.1.1": a.Reason(),
+		"10.1.0.1": "manual",
+	})
+
+	keys, err := rdb.Keys(ctx, testPrefix+"b*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) > 0 {
+		t.Fatalf("expected the previous import of feed b to be removed, got %s", strings.Join(keys, ", "))
+	}
+
+	removed, err = importer.Cleanup(ctx)
+	if err != nil || removed != 0 {
+		t.Fatalf("expected nothing to clean up, got %d: %v", removed, err)
+	}
+}
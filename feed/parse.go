@@ -0,0 +1,225 @@
+package feed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Format of a feed
+type Format string
+
+const (
+	// FormatPlain are ip addresses, CIDR ranges or from-to ranges, one per line.
+	// Comments starting with # or ; are ignored, as well as everything behind the first
+	// whitespace. Tor exit-addresses lines (ExitAddress <ip> <date>) are supported as well.
+	FormatPlain Format = "plain"
+	// FormatAWS is the ip-ranges.json of Amazon Web Services
+	FormatAWS Format = "aws"
+	// FormatGCP is the cloud.json of Google Cloud
+	FormatGCP Format = "gcp"
+	// FormatAzure is the service tags json of Microsoft Azure
+	FormatAzure Format = "azure"
+)
+
+// Formats contains all supported feed formats
+var Formats = []Format{FormatPlain, FormatAWS, FormatGCP, FormatAzure}
+
+// Parse returns the normalized ip ranges of the feed data
+func Parse(format Format, data []byte) ([]string, error) {
+	var (
+		ranges []string
+		err    error
+	)
+	switch format {
+	case FormatPlain, "":
+		ranges, err = parsePlain(data)
+	case FormatAWS:
+		ranges, err = parseAWS(data)
+	case FormatGCP:
+		ranges, err = parseGCP(data)
+	case FormatAzure:
+		ranges, err = parseAzure(data)
+	default:
+		return nil, fmt.Errorf("unknown feed format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dedup(ranges), nil
+}
+
+func parsePlain(data []byte) ([]string, error) {
+	ranges := make([]string, 0, bytes.Count(data, []byte("\n"))+1)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexAny(line, "#;"); idx >= 0 {
+			line = line[:idx]
+		}
+
+		// from - to ranges may contain whitespaces
+		if ipRange, err := Normalize(line); err == nil {
+			ranges = append(ranges, ipRange)
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		field := fields[0]
+		if field == "ExitAddress" {
+			if len(fields) < 2 {
+				continue
+			}
+			field = fields[1]
+		}
+
+		ipRange, err := Normalize(field)
+		if err != nil {
+			// headers or other metadata, e.g. ExitNode or Published lines
+			continue
+		}
+		ranges = append(ranges, ipRange)
+	}
+	return ranges, scanner.Err()
+}
+
+func parseAWS(data []byte) ([]string, error) {
+	var doc struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+		} `json:"ipv6_prefixes"`
+	}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([]string, 0, len(doc.Prefixes)+len(doc.IPv6Prefixes))
+	for _, p := range doc.Prefixes {
+		prefixes = append(prefixes, p.IPPrefix)
+	}
+	for _, p := range doc.IPv6Prefixes {
+		prefixes = append(prefixes, p.IPv6Prefix)
+	}
+	return normalizeAll(prefixes)
+}
+
+func parseGCP(data []byte) ([]string, error) {
+	var doc struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+		} `json:"prefixes"`
+	}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([]string, 0, len(doc.Prefixes))
+	for _, p := range doc.Prefixes {
+		if p.IPv4Prefix != "" {
+			prefixes = append(prefixes, p.IPv4Prefix)
+		}
+		if p.IPv6Prefix != "" {
+			prefixes = append(prefixes, p.IPv6Prefix)
+		}
+	}
+	return normalizeAll(prefixes)
+}
+
+func parseAzure(data []byte) ([]string, error) {
+	var doc struct {
+		Values []struct {
+			Properties struct {
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([]string, 0, len(doc.Values))
+	for _, v := range doc.Values {
+		prefixes = append(prefixes, v.Properties.AddressPrefixes...)
+	}
+	return normalizeAll(prefixes)
+}
+
+func normalizeAll(ipRanges []string) ([]string, error) {
+	result := make([]string, 0, len(ipRanges))
+	for _, ipRange := range ipRanges {
+		normalized, err := Normalize(ipRange)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, normalized)
+	}
+	return result, nil
+}
+
+// Normalize returns the canonical notation of a single ip address, a CIDR range or a from-to range.
+// CIDR ranges are masked and ipv4 mapped ipv6 addresses are converted to ipv4 addresses.
+func Normalize(ipRange string) (string, error) {
+	ipRange = strings.TrimSpace(ipRange)
+
+	if strings.Contains(ipRange, "/") {
+		prefix, err := netip.ParsePrefix(ipRange)
+		if err != nil {
+			return "", err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked().String(), nil
+	}
+
+	from, to, isRange := strings.Cut(ipRange, "-")
+	first, err := netip.ParseAddr(strings.TrimSpace(from))
+	if err != nil {
+		return "", err
+	}
+	first = first.Unmap()
+	if !isRange {
+		return first.String(), nil
+	}
+
+	last, err := netip.ParseAddr(strings.TrimSpace(to))
+	if err != nil {
+		return "", err
+	}
+	last = last.Unmap()
+	if first.Is4() != last.Is4() {
+		return "", fmt.Errorf("invalid ip range, mixed ipv4 and ipv6 boundaries: %s", ipRange)
+	}
+	if last.Less(first) {
+		return "", fmt.Errorf("invalid ip range, lower boundary is bigger than the upper boundary: %s", ipRange)
+	}
+	return first.String() + "-" + last.String(), nil
+}
+
+// dedup removes duplicate ranges while keeping their order
+func dedup(ranges []string) []string {
+	seen := make(map[string]bool, len(ranges))
+	result := ranges[:0]
+	for _, r := range ranges {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		result = append(result, r)
+	}
+	return result
+}
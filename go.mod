@@ -3,6 +3,7 @@ module github.com/jxsl13/TeeworldsEconVPNDetection
 go 1.21.6
 
 require (
+	github.com/alicebob/miniredis/v2 v2.37.0
 	github.com/go-playground/validator/v10 v10.17.0
 	github.com/jxsl13/goripr/v2 v2.0.3
 	github.com/jxsl13/twapi v1.4.0
@@ -15,6 +16,7 @@ require (
 	github.com/knadh/koanf/providers/structs v0.1.0
 	github.com/knadh/koanf/v2 v2.0.1
 	github.com/nutsdb/nutsdb v1.0.3
+	github.com/oschwald/maxminddb-golang v1.12.0
 	github.com/redis/go-redis/v9 v9.4.0
 	github.com/spf13/cobra v1.8.0
 	github.com/spf13/pflag v1.0.5
@@ -45,6 +47,7 @@ require (
 	github.com/xgfone/go-netaddr v0.6.0 // indirect
 	github.com/xujiajun/mmap-go v1.0.1 // indirect
 	github.com/xujiajun/utils v0.0.0-20220904132955-5f7c5b914235 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
@@ -0,0 +1,150 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var _ VPN = (*AbuseIPDB)(nil)
+
+// AbuseIPDBURL is the default base url of the abuseipdb api
+const AbuseIPDBURL = "https://api.abuseipdb.com"
+
+// NewAbuseIPDB creates a new api that can be checked for VPN IPs.
+// baseURL may be nil in order to use the default AbuseIPDBURL.
+func NewAbuseIPDB(c *http.Client, baseURL *url.URL, apiKey string, limiter Limiter) *AbuseIPDB {
+	return &AbuseIPDB{
+		client:  c,
+		baseURL: orDefaultURL(baseURL, AbuseIPDBURL),
+		limiter: limiter,
+		headers: http.Header{
+			"Key":    []string{apiKey},
+			"Accept": []string{"application/json"},
+		},
+	}
+}
+
+// AbuseIPDB implements the VPN interface and checks whether a given IP is a TOR exit node
+// or belongs to a data center.
+type AbuseIPDB struct {
+	client  *http.Client
+	baseURL *url.URL
+	limiter Limiter
+	quota   suspender
+	headers http.Header
+}
+
+// String implements the stringer interface
+func (*AbuseIPDB) String() string {
+	return "abuseipdb.com"
+}
+
+type abuseIPDBResponse struct {
+	Data   abuseIPDBData    `json:"data"`
+	Errors []abuseIPDBError `json:"errors"`
+}
+
+type abuseIPDBData struct {
+	IPAddress            string `json:"ipAddress"`
+	AbuseConfidenceScore int    `json:"abuseConfidenceScore"`
+	CountryCode          string `json:"countryCode"`
+	UsageType            string `json:"usageType"`
+	ISP                  string `json:"isp"`
+	IsTor                bool   `json:"isTor"`
+}
+
+type abuseIPDBError struct {
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+// Fetch requests the ip information from the api endpoint.
+// AbuseIPDB does not detect VPNs directly, but TOR exit nodes and data center ips.
+func (ai *AbuseIPDB) Fetch(ctx context.Context, IP string) (Verdict, error) {
+	u := ai.baseURL.JoinPath("api/v2/check")
+	u.RawQuery = url.Values{
+		"ipAddress":    []string{IP},
+		"maxAgeInDays": []string{"90"},
+	}.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to create request: %w", redactURL(err))
+	}
+	request.Header = ai.headers
+
+	response, err := ai.client.Do(request)
+	if err != nil {
+		return Verdict{}, redactURL(err)
+	}
+	defer response.Body.Close()
+
+	err = ai.quota.CheckResponse(ai.String(), response)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	// body
+	bytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("error while reading response body: %w", err)
+	}
+
+	data := abuseIPDBResponse{}
+	err = json.Unmarshal(bytes, &data)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	if len(data.Errors) > 0 {
+		details := make([]string, 0, len(data.Errors))
+		for _, e := range data.Errors {
+			details = append(details, e.Detail)
+		}
+		return Verdict{}, errors.New(strings.Join(details, ", "))
+	}
+
+	// status
+	status := response.StatusCode
+	if status/100 != 2 {
+		return Verdict{}, fmt.Errorf("response code is not 200: %d, check your AbuseIPDB token", status)
+	}
+
+	verdict := Verdict{
+		ISP:     data.Data.ISP,
+		Country: data.Data.CountryCode,
+		Raw:     bytes,
+	}
+
+	switch {
+	case data.Data.IsTor:
+		verdict.Confidence = 1
+		verdict.Category = CategoryTor
+	case strings.Contains(data.Data.UsageType, "Data Center"):
+		// Data Center/Web Hosting/Transit
+		verdict.Confidence = 0.5
+		verdict.Category = CategoryHosting
+	}
+	return verdict, nil
+}
+
+// IsVPN tests if a given IP is a VPN IP
+func (ai *AbuseIPDB) IsVPN(ctx context.Context, IP string) (Verdict, error) {
+	err := ai.quota.Check(ai.String())
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	err = allow(ctx, ai.limiter)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	return ai.Fetch(ctx, IP)
+}
@@ -0,0 +1,69 @@
+package vpn
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAbuseIPDB(t *testing.T) {
+	newProvider := func(c *http.Client, baseURL *url.URL) VPN {
+		return NewAbuseIPDB(c, baseURL, "secret", testLimiter())
+	}
+	checkRequest := func(t *testing.T, r *http.Request) {
+		if r.URL.Path != "/api/v2/check" || r.URL.Query().Get("ipAddress") != "1.2.3.4" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		if key := r.Header.Get("Key"); key != "secret" {
+			t.Errorf("unexpected key: %s", key)
+		}
+	}
+
+	testProvider(t, newProvider, checkRequest, []providerTest{
+		{
+			name:       "tor",
+			status:     http.StatusOK,
+			body:       `{"data":{"ipAddress":"1.2.3.4","abuseConfidenceScore":100,"countryCode":"DE","usageType":"Reserved","isTor":true}}`,
+			confidence: 1,
+			category:   CategoryTor,
+		},
+		{
+			name:       "data center",
+			status:     http.StatusOK,
+			body:       `{"data":{"ipAddress":"1.2.3.4","usageType":"Data Center/Web Hosting/Transit","isp":"Hetzner Online GmbH"}}`,
+			confidence: 0.5,
+			category:   CategoryHosting,
+		},
+		{
+			name:   "clean",
+			status: http.StatusOK,
+			body:   `{"data":{"ipAddress":"1.2.3.4","usageType":"Fixed Line ISP","isp":"Deutsche Telekom AG"}}`,
+		},
+		{
+			name:    "invalid key",
+			status:  http.StatusUnauthorized,
+			body:    `{"errors":[{"detail":"Authentication failed. Your API key is either missing, incorrect, or revoked.","status":401}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed json",
+			status:  http.StatusOK,
+			body:    `{"data":`,
+			wantErr: true,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusServiceUnavailable,
+			body:    `{}`,
+			wantErr: true,
+		},
+		{
+			name:    "too many requests",
+			status:  http.StatusTooManyRequests,
+			header:  map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "3600"},
+			body:    `{"errors":[{"detail":"Daily rate limit of 1000 requests exceeded for this endpoint.","status":429}]}`,
+			wantErr: true,
+			quota:   true,
+		},
+	})
+}
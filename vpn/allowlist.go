@@ -0,0 +1,147 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jxsl13/goripr/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultAllowReason is used for allowed ranges without a reason
+const DefaultAllowReason = "allowed"
+
+// NewAllowlist creates a new allowlist that stores the ranges in its own goripr cache, which must use a
+// different redis database than the blacklist, and their metadata in redis with the given key prefix.
+func NewAllowlist(ripr *goripr.Client, rdb *redis.Client, keyPrefix string) *Allowlist {
+	return &Allowlist{
+		r:         ripr,
+		rdb:       rdb,
+		rangesKey: keyPrefix + "ranges",
+	}
+}
+
+// Allowlist is the persistent goripr cache of ip ranges that are never considered to be vpns,
+// e.g. known good isps or staff members. It takes precedence over the blacklist and the apis.
+type Allowlist struct {
+	r   *goripr.Client
+	rdb *redis.Client
+
+	// hash of range -> RangeInfo json
+	rangesKey string
+}
+
+// Close closes the goripr client, the redis client is owned by the caller.
+func (a *Allowlist) Close() error {
+	return a.r.Close()
+}
+
+// Find returns the reason of the allowed range that contains the ip or goripr.ErrIPNotFound.
+func (a *Allowlist) Find(ctx context.Context, ip string) (string, error) {
+	return a.r.Find(ctx, ip)
+}
+
+// Add allows the ip range permanently.
+func (a *Allowlist) Add(ctx context.Context, ipRange, reason, source string) error {
+	if reason == "" {
+		reason = DefaultAllowReason
+	}
+
+	err := a.r.Insert(ctx, ipRange, reason)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(RangeInfo{
+		Range:    ipRange,
+		Reason:   reason,
+		Source:   source,
+		Inserted: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = a.rdb.HSet(ctx, a.rangesKey, ipRange, data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store metadata of %s: %w", ipRange, err)
+	}
+	return nil
+}
+
+// Remove removes the ip range from the allowlist. The removed parts of overlapping ranges are recorded in their metadata.
+func (a *Allowlist) Remove(ctx context.Context, ipRange string) error {
+	err := a.r.Remove(ctx, ipRange)
+	if err != nil {
+		return err
+	}
+
+	removed, err := newIntervals([]string{ipRange})
+	if err != nil {
+		return err
+	}
+	infos, err := a.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	deleted, updated := cutRanges(infos, removed)
+	_, err = a.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if len(deleted) > 0 {
+			p.HDel(ctx, a.rangesKey, deleted...)
+		}
+		for _, info := range updated {
+			data, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			p.HSet(ctx, a.rangesKey, info.Range, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove metadata of %s: %w", ipRange, err)
+	}
+	return nil
+}
+
+// List returns the metadata of all allowed ranges sorted by their first address.
+func (a *Allowlist) List(ctx context.Context) ([]RangeInfo, error) {
+	entries, err := a.rdb.HGetAll(ctx, a.rangesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeRanges(entries)
+}
+
+// Overlapping returns the metadata of the allowed ranges that overlap with at least one of the ip ranges.
+// The allowlist takes precedence over the blacklist, which is why blacklisting these parts has no effect.
+func (a *Allowlist) Overlapping(ctx context.Context, ipRanges ...string) ([]RangeInfo, error) {
+	if len(ipRanges) == 0 {
+		return nil, nil
+	}
+
+	ranges, err := newIntervals(ipRanges)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := a.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []RangeInfo
+	for _, info := range infos {
+		// removed parts are not allowed anymore
+		for _, iv := range info.intervals() {
+			if ranges.overlapsInterval(iv) {
+				result = append(result, info)
+				break
+			}
+		}
+	}
+	return result, nil
+}
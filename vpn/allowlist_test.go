@@ -0,0 +1,184 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jxsl13/goripr/v2"
+)
+
+// newTestAllowlist creates an allowlist in its own database of the in-memory redis server
+func newTestAllowlist(t *testing.T) *Allowlist {
+	t.Helper()
+	rdb, options := newTestRedis(t)
+	options.DB = 1
+	ripr, err := goripr.NewClient(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al := NewAllowlist(ripr, rdb, "test:allow:")
+	t.Cleanup(func() { _ = al.Close() })
+	return al
+}
+
+func TestAllowlistOverlapping(t *testing.T) {
+	ctx := context.Background()
+	al := newTestAllowlist(t)
+
+	for _, r := range []string{"10.0.0.0/16", "10.1.0.1-10.1.0.20", "2001:db8::/32"} {
+		err := al.Add(ctx, r, "staff", SourceManual)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		ipRanges []string
+		want     []string
+	}{
+		{name: "nothing", want: nil},
+		{name: "disjoint", ipRanges: []string{"10.2.0.0/16", "1.2.3.4"}, want: nil},
+		{name: "contained", ipRanges: []string{"10.0.1.2"}, want: []string{"10.0.0.0/16"}},
+		{name: "containing", ipRanges: []string{"10.0.0.0/8"}, want: []string{"10.0.0.0/16", "10.1.0.1-10.1.0.20"}},
+		{name: "boundary", ipRanges: []string{"10.1.0.20-10.1.0.30"}, want: []string{"10.1.0.1-10.1.0.20"}},
+		{name: "adjacent", ipRanges: []string{"10.1.0.21-10.1.0.30"}, want: nil},
+		{name: "ipv6", ipRanges: []string{"2001:db8:1::/48", "1.2.3.4"}, want: []string{"2001:db8::/32"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infos, err := al.Overlapping(ctx, tt.ipRanges...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(infos) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, infos)
+			}
+			for idx, info := range infos {
+				if info.Range != tt.want[idx] {
+					t.Errorf("expected %s, got %s", tt.want[idx], info.Range)
+				}
+			}
+		})
+	}
+
+	_, err := al.Overlapping(ctx, "invalid")
+	if err == nil {
+		t.Fatal("expected an error for invalid ranges")
+	}
+}
+
+func TestAllowlistRemove(t *testing.T) {
+	tests := []struct {
+		name    string
+		added   []string
+		remove  []string
+		allowed map[string]bool
+		// expected parts of the listed ranges, range -> parts
+		parts map[string][]string
+		// ranges that are expected to overlap with the allowed ranges, range -> overlapping ranges
+		overlapping map[string][]string
+	}{
+		{
+			name:   "sub range",
+			added:  []string{"10.0.0.0/16"},
+			remove: []string{"10.0.1.0/24"},
+			allowed: map[string]bool{
+				"10.0.0.1": true,
+				"10.0.1.1": false,
+				"10.0.2.1": true,
+			},
+			parts: map[string][]string{
+				"10.0.0.0/16": {"10.0.0.0/24", "10.0.2.0-10.0.255.255"},
+			},
+			overlapping: map[string][]string{
+				"10.0.1.0/24":       nil,
+				"10.0.1.128/25":     nil,
+				"10.0.1.0-10.0.2.0": {"10.0.0.0/16"},
+			},
+		},
+		{
+			name:    "exact range",
+			added:   []string{"10.0.0.0/16", "1.2.3.4"},
+			remove:  []string{"10.0.0.0/16"},
+			allowed: map[string]bool{"10.0.0.1": false, "1.2.3.4": true},
+			parts:   map[string][]string{"1.2.3.4": {"1.2.3.4"}},
+			overlapping: map[string][]string{
+				"10.0.0.0/8": nil,
+			},
+		},
+		{
+			name:    "several removals",
+			added:   []string{"10.0.0.1-10.0.0.20", "2001:db8::/32"},
+			remove:  []string{"10.0.0.5-10.0.0.10", "10.0.0.15-10.0.0.30", "2001:db8::/33"},
+			allowed: map[string]bool{"10.0.0.4": true, "10.0.0.11": true, "10.0.0.15": false, "2001:db8::1": false, "2001:db8:8000::1": true},
+			parts: map[string][]string{
+				"10.0.0.1-10.0.0.20": {"10.0.0.1-10.0.0.4", "10.0.0.11-10.0.0.14"},
+				"2001:db8::/32":      {"2001:db8:8000::/33"},
+			},
+			overlapping: map[string][]string{
+				"10.0.0.16":     nil,
+				"2001:db8::/48": nil,
+				"10.0.0.0/24":   {"10.0.0.1-10.0.0.20"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			al := newTestAllowlist(t)
+
+			for _, r := range tt.added {
+				err := al.Add(ctx, r, "staff", SourceManual)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, r := range tt.remove {
+				err := al.Remove(ctx, r)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for ip, want := range tt.allowed {
+				_, err := al.Find(ctx, ip)
+				if errors.Is(err, goripr.ErrIPNotFound) == want {
+					t.Errorf("expected %s to be allowed: %t, got %v", ip, want, err)
+				}
+			}
+
+			infos, err := al.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(infos) != len(tt.parts) {
+				t.Fatalf("expected metadata of %v, got %v", tt.parts, infos)
+			}
+			for _, info := range infos {
+				parts := info.Parts()
+				if strings.Join(parts, ",") != strings.Join(tt.parts[info.Range], ",") {
+					t.Errorf("expected %s to consist of %v, got %v", info.Range, tt.parts[info.Range], parts)
+				}
+			}
+
+			for ipRange, want := range tt.overlapping {
+				infos, err := al.Overlapping(ctx, ipRange)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := make([]string, 0, len(infos))
+				for _, info := range infos {
+					got = append(got, info.Range)
+				}
+				if strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Errorf("expected %s to overlap with %v, got %v", ipRange, want, got)
+				}
+			}
+		})
+	}
+}
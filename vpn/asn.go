@@ -0,0 +1,116 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var _ VPN = (*ASNDatabase)(nil)
+
+// NewASNDatabase opens a local MaxMind DB file with autonomous system numbers, e.g. GeoLite2-ASN
+// or the ASN database of ipinfo.io. IPs are flagged in case their autonomous system number is part
+// of the vpnASNs or hostingASNs. The whole database is kept in memory.
+func NewASNDatabase(path string, vpnASNs, hostingASNs []int) (*ASNDatabase, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open asn database: %w", err)
+	}
+	db, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open asn database: %s: %w", path, err)
+	}
+
+	asns := make(map[int]Category, len(vpnASNs)+len(hostingASNs))
+	for _, asn := range hostingASNs {
+		asns[asn] = CategoryHosting
+	}
+	for _, asn := range vpnASNs {
+		asns[asn] = CategoryVPN
+	}
+
+	return &ASNDatabase{
+		name: filepath.Base(path),
+		db:   db,
+		asns: asns,
+	}, nil
+}
+
+// ASNDatabase implements the VPN interface based on a local database that does not require
+// any network access or api quota.
+type ASNDatabase struct {
+	name string
+	db   *maxminddb.Reader
+	asns map[int]Category
+}
+
+// String implements the stringer interface
+func (a *ASNDatabase) String() string {
+	return a.name
+}
+
+// IsVPN looks up the IP in the local database
+func (a *ASNDatabase) IsVPN(ctx context.Context, IP string) (Verdict, error) {
+	ip, err := netip.ParseAddr(IP)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	var data map[string]any
+	_, found, err := a.db.LookupNetwork(ip.Unmap().AsSlice(), &data)
+	if err != nil {
+		return Verdict{}, err
+	}
+	if !found {
+		return Verdict{}, nil
+	}
+
+	verdict := Verdict{
+		ASN: recordASN(data),
+	}
+	verdict.ISP, _ = data["autonomous_system_organization"].(string)
+	if verdict.ISP == "" {
+		verdict.ISP, _ = data["as_name"].(string)
+	}
+	verdict.Country, _ = data["country_code"].(string)
+	verdict.Raw, _ = json.Marshal(data)
+
+	if category, found := a.asns[verdict.ASN]; found && verdict.ASN > 0 {
+		verdict.Confidence = 1
+		verdict.Category = category
+	}
+	return verdict, nil
+}
+
+// recordASN returns the autonomous system number of GeoLite2-ASN like or ipinfo like records
+func recordASN(data map[string]any) int {
+	if asn := asUint64(data["autonomous_system_number"]); asn > 0 {
+		return int(asn)
+	}
+	switch asn := data["asn"].(type) {
+	case string:
+		return parseASN(asn)
+	default:
+		return int(asUint64(asn))
+	}
+}
+
+// asUint64 converts decoded unsigned and signed integers
+func asUint64(value any) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case int:
+		if v < 0 {
+			return 0
+		}
+		return uint64(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,233 @@
+package vpn
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// mmdb data section types that are used by the test databases
+const (
+	mmdbTypeString = 2
+	mmdbTypeUint16 = 5
+	mmdbTypeUint32 = 6
+	mmdbTypeMap    = 7
+)
+
+// encodeMMDB encodes strings, unsigned integers and maps in the MaxMind DB data format
+func encodeMMDB(buf []byte, value any) []byte {
+	control := func(typ, size int) []byte {
+		if size < 29 {
+			return append(buf, byte(typ<<5|size))
+		}
+		if size >= 29+256 {
+			panic("size of test values must be smaller than 285")
+		}
+		return append(buf, byte(typ<<5|29), byte(size-29))
+	}
+
+	switch v := value.(type) {
+	case string:
+		buf = control(mmdbTypeString, len(v))
+		return append(buf, v...)
+	case uint16:
+		buf = control(mmdbTypeUint16, 2)
+		return binary.BigEndian.AppendUint16(buf, v)
+	case int:
+		buf = control(mmdbTypeUint32, 4)
+		return binary.BigEndian.AppendUint32(buf, uint32(v))
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf = control(mmdbTypeMap, len(v))
+		for _, k := range keys {
+			buf = encodeMMDB(buf, k)
+			buf = encodeMMDB(buf, v[k])
+		}
+		return buf
+	default:
+		panic("unsupported test value")
+	}
+}
+
+// writeTestMMDB writes an ipv4 MaxMind DB with 24 bit records that contains the records of the prefixes
+func writeTestMMDB(t *testing.T, records map[string]map[string]any) string {
+	t.Helper()
+
+	const empty = -1
+	// children of the nodes, either a node index, empty or the data index encoded as -(idx+2)
+	nodes := [][2]int{{empty, empty}}
+	var data [][]byte
+
+	for prefixStr, record := range records {
+		prefix := netip.MustParsePrefix(prefixStr)
+		addr := prefix.Addr().As4()
+		node := 0
+		for bit := 0; bit < prefix.Bits(); bit++ {
+			side := int(addr[bit/8]>>(7-bit%8)) & 1
+			if bit == prefix.Bits()-1 {
+				nodes[node][side] = -(len(data) + 2)
+				data = append(data, encodeMMDB(nil, record))
+				break
+			}
+			if nodes[node][side] < 0 {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][side] = len(nodes) - 1
+			}
+			node = nodes[node][side]
+		}
+	}
+
+	nodeCount := len(nodes)
+	offsets := make([]int, len(data))
+	var dataSection []byte
+	for idx, d := range data {
+		offsets[idx] = len(dataSection)
+		dataSection = append(dataSection, d...)
+	}
+
+	var db []byte
+	for _, node := range nodes {
+		for _, child := range node {
+			var record int
+			switch {
+			case child == empty:
+				record = nodeCount
+			case child < 0:
+				record = nodeCount + 16 + offsets[-child-2]
+			default:
+				record = child
+			}
+			db = append(db, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	db = append(db, make([]byte, 16)...)
+	db = append(db, dataSection...)
+	db = append(db, "\xAB\xCD\xEFMaxMind.com"...)
+	db = encodeMMDB(db, map[string]any{
+		"node_count":                  nodeCount,
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(4),
+		"database_type":               "Test-ASN",
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+	})
+
+	path := filepath.Join(t.TempDir(), "Test-ASN.mmdb")
+	err := os.WriteFile(path, db, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestASNDatabase(t *testing.T) {
+	path := writeTestMMDB(t, map[string]map[string]any{
+		"10.0.0.0/8": {
+			"autonomous_system_number":       9009,
+			"autonomous_system_organization": "M247 Europe SRL",
+		},
+		"20.0.0.0/16": {
+			"autonomous_system_number":       24940,
+			"autonomous_system_organization": "Hetzner Online GmbH",
+		},
+		"30.0.0.0/24": {
+			"autonomous_system_number":       3320,
+			"autonomous_system_organization": "Deutsche Telekom AG",
+		},
+		"40.0.0.0/24": {
+			"asn":          "AS60068",
+			"as_name":      "Datacamp Limited",
+			"country_code": "GB",
+		},
+	})
+
+	db, err := NewASNDatabase(path, []int{9009, 60068}, []int{24940})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip         string
+		confidence float64
+		category   Category
+		asn        int
+		isp        string
+		country    string
+	}{
+		{ip: "10.1.2.3", confidence: 1, category: CategoryVPN, asn: 9009, isp: "M247 Europe SRL"},
+		{ip: "::ffff:10.1.2.3", confidence: 1, category: CategoryVPN, asn: 9009, isp: "M247 Europe SRL"},
+		{ip: "20.0.255.255", confidence: 1, category: CategoryHosting, asn: 24940, isp: "Hetzner Online GmbH"},
+		{ip: "30.0.0.1", asn: 3320, isp: "Deutsche Telekom AG"},
+		{ip: "40.0.0.1", confidence: 1, category: CategoryVPN, asn: 60068, isp: "Datacamp Limited", country: "GB"},
+		{ip: "20.1.0.1"},
+		{ip: "1.2.3.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			verdict, err := db.IsVPN(context.Background(), tt.ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Confidence != tt.confidence || verdict.Category != tt.category {
+				t.Errorf("expected %s (%.2f), got %s (%.2f)", tt.category, tt.confidence, verdict.Category, verdict.Confidence)
+			}
+			if verdict.ASN != tt.asn || verdict.ISP != tt.isp || verdict.Country != tt.country {
+				t.Errorf("expected AS%d %q %q, got AS%d %q %q", tt.asn, tt.isp, tt.country, verdict.ASN, verdict.ISP, verdict.Country)
+			}
+		})
+	}
+}
+
+func TestASNDatabaseInvalid(t *testing.T) {
+	valid, err := os.ReadFile(writeTestMMDB(t, map[string]map[string]any{
+		"10.0.0.0/8": {"autonomous_system_number": 9009},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadataOnly := func(metadata map[string]any) []byte {
+		return encodeMMDB([]byte("\xAB\xCD\xEFMaxMind.com"), metadata)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "without metadata", data: valid[:len(valid)/2]},
+		{name: "truncated metadata", data: valid[:len(valid)-5]},
+		{
+			name: "search tree exceeds file",
+			data: metadataOnly(map[string]any{"node_count": 1000, "record_size": uint16(24), "ip_version": uint16(4)}),
+		},
+		{
+			name: "unknown record size",
+			data: metadataOnly(map[string]any{"node_count": 0, "record_size": uint16(20), "ip_version": uint16(4)}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "invalid.mmdb")
+			err := os.WriteFile(path, tt.data, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = NewASNDatabase(path, nil, nil)
+			if err == nil {
+				t.Fatal("expected an error for invalid databases")
+			}
+		})
+	}
+}
@@ -0,0 +1,646 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/goripr/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// sources of blacklisted ranges
+const (
+	SourceAPI    = "api"
+	SourceManual = "manual"
+)
+
+// SourceFile returns the source of ranges that were imported from the given file
+func SourceFile(path string) string {
+	return "file:" + filepath.Base(path)
+}
+
+// SourceFeed returns the source of ranges that were imported from the named feed
+func SourceFeed(name string) string {
+	return sourceFeedPrefix + name
+}
+
+// FeedName returns the name of the feed of ranges that were imported from a feed
+func FeedName(source string) (name string, found bool) {
+	return strings.CutPrefix(source, sourceFeedPrefix)
+}
+
+const sourceFeedPrefix = "feed:"
+
+// RangeInfo is the metadata of a blacklisted ip range
+type RangeInfo struct {
+	Range    string    `json:"range"`
+	Reason   string    `json:"reason"`
+	Source   string    `json:"source"`
+	Inserted time.Time `json:"inserted"`
+	// Expires is zero for ranges that never expire
+	Expires time.Time `json:"expires,omitempty"`
+	// Removed are the parts of the range that were removed afterwards
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Expired returns whether the range expired at the given point in time
+func (ri RangeInfo) Expired(now time.Time) bool {
+	return !ri.Expires.IsZero() && !now.Before(ri.Expires)
+}
+
+// Contains returns whether the ip is part of the range and was not removed
+func (ri RangeInfo) Contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, iv := range ri.intervals() {
+		if !ip.Less(iv.first) && !iv.last.Less(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parts returns the parts of the range that were not removed
+func (ri RangeInfo) Parts() []string {
+	if len(ri.Removed) == 0 {
+		return []string{ri.Range}
+	}
+	ivs := ri.intervals()
+	parts := make([]string, 0, len(ivs))
+	for _, iv := range ivs {
+		parts = append(parts, formatInterval(iv))
+	}
+	return parts
+}
+
+// intervals returns the parts of the range that were not removed
+func (ri RangeInfo) intervals() intervals {
+	first, last, err := ParseRange(ri.Range)
+	if err != nil {
+		return nil
+	}
+	if len(ri.Removed) == 0 {
+		return intervals{{first, last}}
+	}
+	removed, err := newIntervals(ri.Removed)
+	if err != nil {
+		return intervals{{first, last}}
+	}
+	return removed.subtract(interval{first, last})
+}
+
+// sortRanges sorts the ranges by their first address, invalid ranges are sorted last.
+func sortRanges(infos []RangeInfo) {
+	firsts := make(map[string]netip.Addr, len(infos))
+	for _, info := range infos {
+		first, _, err := ParseRange(info.Range)
+		if err == nil {
+			firsts[info.Range] = first
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := firsts[infos[i].Range], firsts[infos[j].Range]
+		switch {
+		case a.IsValid() && b.IsValid() && a != b:
+			return a.Less(b)
+		case a.IsValid() != b.IsValid():
+			return a.IsValid()
+		default:
+			return infos[i].Range < infos[j].Range
+		}
+	})
+}
+
+// decodeRanges decodes the range -> RangeInfo json hash
+func decodeRanges(entries map[string]string) ([]RangeInfo, error) {
+	result := make([]RangeInfo, 0, len(entries))
+	for ipRange, data := range entries {
+		var info RangeInfo
+		err := json.Unmarshal([]byte(data), &info)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata of %s: %w", ipRange, err)
+		}
+		result = append(result, info)
+	}
+	sortRanges(result)
+	return result, nil
+}
+
+// cutRanges removes the removed intervals from the overlapping ranges and returns the ranges that were
+// removed completely and the remaining ranges with their updated removed parts.
+func cutRanges(infos []RangeInfo, removed intervals) (deleted []string, updated []RangeInfo) {
+	for _, info := range infos {
+		first, last, err := ParseRange(info.Range)
+		if err != nil || !removed.overlapsInterval(interval{first, last}) {
+			continue
+		}
+		if len(removed.subtract(interval{first, last})) == 0 {
+			deleted = append(deleted, info.Range)
+			continue
+		}
+
+		holes, err := newIntervals(info.Removed)
+		if err != nil {
+			holes = nil
+		}
+		for _, iv := range removed {
+			if iv.first.Less(first) {
+				iv.first = first
+			}
+			if last.Less(iv.last) {
+				iv.last = last
+			}
+			if !iv.last.Less(iv.first) {
+				holes = append(holes, iv)
+			}
+		}
+
+		info.Removed = info.Removed[:0:0]
+		for _, hole := range mergeIntervals(holes) {
+			info.Removed = append(info.Removed, formatInterval(hole))
+		}
+		updated = append(updated, info)
+	}
+	return deleted, updated
+}
+
+// NewBlacklist creates a new blacklist that stores the ranges in the goripr cache and their
+// metadata in redis with the given key prefix.
+func NewBlacklist(ripr *goripr.Client, rdb *redis.Client, keyPrefix string) *Blacklist {
+	return &Blacklist{
+		r:          ripr,
+		rdb:        rdb,
+		rangesKey:  keyPrefix + "ranges",
+		expiresKey: keyPrefix + "expires",
+		indexKey:   keyPrefix + "index",
+		legacyKey:  keyPrefix + "legacy",
+	}
+}
+
+// Blacklist is the goripr cache of blacklisted ip ranges with their source, insertion time and expiry.
+type Blacklist struct {
+	r   *goripr.Client
+	rdb *redis.Client
+
+	// hash of range -> RangeInfo json
+	rangesKey string
+	// sorted set of range -> unix expiry
+	expiresKey string
+	// hash of CIDR prefix -> range, the prefixes of every range cover it exactly
+	indexKey string
+	// whether the database contained ranges before their metadata was recorded, 1 or 0
+	legacyKey string
+}
+
+// Close closes the goripr client, the redis client is owned by the caller.
+func (b *Blacklist) Close() error {
+	return b.r.Close()
+}
+
+// Find returns the reason of the blacklisted range that contains the ip or goripr.ErrIPNotFound.
+func (b *Blacklist) Find(ctx context.Context, ip string) (string, error) {
+	return b.r.Find(ctx, ip)
+}
+
+// Insert blacklists the ip range. A ttl of 0 keeps the range forever.
+func (b *Blacklist) Insert(ctx context.Context, ipRange, reason, source string, ttl time.Duration) error {
+	err := b.r.Insert(ctx, ipRange, reason)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	info := RangeInfo{
+		Range:    ipRange,
+		Reason:   reason,
+		Source:   source,
+		Inserted: now,
+	}
+	if ttl > 0 {
+		info.Expires = now.Add(ttl)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, b.rangesKey, ipRange, data)
+		b.index(ctx, p, ipRange)
+		if info.Expires.IsZero() {
+			p.ZRem(ctx, b.expiresKey, ipRange)
+		} else {
+			p.ZAdd(ctx, b.expiresKey, redis.Z{
+				Score:  float64(info.Expires.Unix()),
+				Member: ipRange,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store metadata of %s: %w", ipRange, err)
+	}
+	return nil
+}
+
+// Remove removes the ip range from the cache. The removed parts of overlapping ranges are recorded in their
+// metadata, which is why withdrawing or sweeping other ranges does not restore them.
+func (b *Blacklist) Remove(ctx context.Context, ipRange string) error {
+	err := b.r.Remove(ctx, ipRange)
+	if err != nil {
+		return err
+	}
+
+	removed, err := newIntervals([]string{ipRange})
+	if err != nil {
+		return err
+	}
+	infos, err := b.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	deleted, updated := cutRanges(infos, removed)
+	var indexed []string
+	if len(deleted) > 0 {
+		indexed, err = b.indexedBy(ctx, deleted)
+		if err != nil {
+			return fmt.Errorf("failed to remove metadata of %s: %w", ipRange, err)
+		}
+	}
+
+	_, err = b.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if len(deleted) > 0 {
+			b.unstore(ctx, p, deleted, indexed)
+		}
+		for _, info := range updated {
+			data, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			p.HSet(ctx, b.rangesKey, info.Range, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove metadata of %s: %w", ipRange, err)
+	}
+	return nil
+}
+
+// indexedBy returns the prefixes of the index that still point to the ranges,
+// prefixes that were overwritten by other ranges are kept
+func (b *Blacklist) indexedBy(ctx context.Context, ipRanges []string) ([]string, error) {
+	var indexed []string
+	for _, ipRange := range ipRanges {
+		prefixes := rangeKeys(ipRange)
+		if len(prefixes) == 0 {
+			continue
+		}
+		ranges, err := b.rdb.HMGet(ctx, b.indexKey, prefixes...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for idx, r := range ranges {
+			if r == ipRange {
+				indexed = append(indexed, prefixes[idx])
+			}
+		}
+	}
+	return indexed, nil
+}
+
+// unstore adds the removal of the metadata of the ranges and their index prefixes to the pipeline
+func (b *Blacklist) unstore(ctx context.Context, p redis.Pipeliner, ipRanges, indexed []string) {
+	members := make([]any, 0, len(ipRanges))
+	for _, ipRange := range ipRanges {
+		members = append(members, ipRange)
+	}
+	p.HDel(ctx, b.rangesKey, ipRanges...)
+	p.ZRem(ctx, b.expiresKey, members...)
+	if len(indexed) > 0 {
+		p.HDel(ctx, b.indexKey, indexed...)
+	}
+}
+
+// Withdraw removes the ip ranges from the cache and restores the parts of the remaining ranges
+// that were cut out by the removal, e.g. manual ranges that overlap with the removed feed ranges.
+// Only the metadata of exactly matching ranges is removed.
+// Ranges that were inserted without metadata by older versions cannot be restored.
+func (b *Blacklist) Withdraw(ctx context.Context, ipRanges ...string) error {
+	if len(ipRanges) == 0 {
+		return nil
+	}
+
+	for _, ipRange := range ipRanges {
+		err := b.r.Remove(ctx, ipRange)
+		if err != nil {
+			return err
+		}
+	}
+
+	indexed, err := b.indexedBy(ctx, ipRanges)
+	if err != nil {
+		return fmt.Errorf("failed to remove metadata: %w", err)
+	}
+	_, err = b.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		b.unstore(ctx, p, ipRanges, indexed)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove metadata: %w", err)
+	}
+	return b.restore(ctx, ipRanges)
+}
+
+// restore inserts the unexpired ranges that overlap with the removed ranges again.
+// Restoring a range overwrites the reason of the ranges that overlap with it, which is why
+// those are restored as well in the order of their insertion.
+func (b *Blacklist) restore(ctx context.Context, removed []string) error {
+	infos, err := b.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	cut := make([]interval, 0, len(removed))
+	for _, ipRange := range removed {
+		first, last, err := ParseRange(ipRange)
+		if err == nil {
+			cut = append(cut, interval{first, last})
+		}
+	}
+
+	var (
+		now        = time.Now()
+		candidates = make([]RangeInfo, 0, len(infos))
+		bounds     = make([]interval, 0, len(infos))
+	)
+	for _, info := range infos {
+		first, last, err := ParseRange(info.Range)
+		if err != nil || info.Expired(now) {
+			continue
+		}
+		candidates = append(candidates, info)
+		bounds = append(bounds, interval{first, last})
+	}
+
+	restored := make([]bool, len(candidates))
+	var restoreOrder []RangeInfo
+	for len(cut) > 0 {
+		merged := mergeIntervals(cut)
+		cut = nil
+		for idx, info := range candidates {
+			if restored[idx] || !merged.overlapsInterval(bounds[idx]) {
+				continue
+			}
+			restored[idx] = true
+			restoreOrder = append(restoreOrder, info)
+			cut = append(cut, bounds[idx])
+		}
+	}
+
+	sort.SliceStable(restoreOrder, func(i, j int) bool {
+		return restoreOrder[i].Inserted.Before(restoreOrder[j].Inserted)
+	})
+	for _, info := range restoreOrder {
+		err = b.r.Insert(ctx, info.Range, info.Reason)
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", info.Range, err)
+		}
+		for _, hole := range info.Removed {
+			err = b.r.Remove(ctx, hole)
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %w", info.Range, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Legacy returns whether the database contained ranges of older versions before any metadata was recorded.
+// Those ranges are neither listed nor restored. The result is determined on the first call and kept in redis,
+// which is why it must be called before ranges are inserted. Deleting the key determines it again.
+func (b *Blacklist) Legacy(ctx context.Context) (bool, error) {
+	legacy, err := b.rdb.Get(ctx, b.legacyKey).Result()
+	if err == nil {
+		return legacy == "1", nil
+	} else if !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+
+	keys, err := b.rdb.DBSize(ctx).Result()
+	if err != nil {
+		return false, err
+	}
+	recorded, err := b.rdb.Exists(ctx, b.rangesKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	legacy = "0"
+	if keys > 0 && recorded == 0 {
+		legacy = "1"
+	}
+	err = b.rdb.SetNX(ctx, b.legacyKey, legacy, 0).Err()
+	if err != nil {
+		return false, err
+	}
+	return legacy == "1", nil
+}
+
+// Info returns the metadata of the exactly matching ip range, found is false for unknown ranges.
+func (b *Blacklist) Info(ctx context.Context, ipRange string) (info RangeInfo, found bool, err error) {
+	data, err := b.rdb.HGet(ctx, b.rangesKey, ipRange).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return RangeInfo{}, false, nil
+	} else if err != nil {
+		return RangeInfo{}, false, err
+	}
+
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return RangeInfo{}, false, fmt.Errorf("invalid metadata of %s: %w", ipRange, err)
+	}
+	return info, true, nil
+}
+
+// List returns the metadata of all blacklisted ranges sorted by their first address.
+// Ranges that were inserted without metadata by older versions are not listed.
+func (b *Blacklist) List(ctx context.Context) ([]RangeInfo, error) {
+	entries, err := b.rdb.HGetAll(ctx, b.rangesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeRanges(entries)
+}
+
+// Lookup returns the metadata of the blacklisted range that contains the ip.
+// The range is resolved through the goripr cache, which is why the most specific range with
+// the cached reason is returned in case of overlapping ranges.
+func (b *Blacklist) Lookup(ctx context.Context, ip string) (info RangeInfo, found bool, err error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return RangeInfo{}, false, err
+	}
+	addr = addr.Unmap().WithZone("")
+
+	reason, err := b.r.Find(ctx, addr.String())
+	if errors.Is(err, goripr.ErrIPNotFound) {
+		return RangeInfo{}, false, nil
+	} else if err != nil {
+		return RangeInfo{}, false, err
+	}
+
+	// ranges of the prefixes that contain the ip, from the most specific to the least specific
+	values, err := b.rdb.HMGet(ctx, b.indexKey, containingKeys(addr)...).Result()
+	if err != nil {
+		return RangeInfo{}, false, err
+	}
+
+	seen := make(map[string]bool, len(values))
+	ranges := make([]string, 0, len(values))
+	for _, v := range values {
+		r, ok := v.(string)
+		if ok && !seen[r] {
+			seen[r] = true
+			ranges = append(ranges, r)
+		}
+	}
+
+	var infos []RangeInfo
+	if len(ranges) > 0 {
+		entries, err := b.rdb.HMGet(ctx, b.rangesKey, ranges...).Result()
+		if err != nil {
+			return RangeInfo{}, false, err
+		}
+		for idx, entry := range entries {
+			data, ok := entry.(string)
+			if !ok {
+				continue
+			}
+			var ri RangeInfo
+			err = json.Unmarshal([]byte(data), &ri)
+			if err != nil {
+				return RangeInfo{}, false, fmt.Errorf("invalid metadata of %s: %w", ranges[idx], err)
+			}
+			infos = append(infos, ri)
+		}
+	}
+
+	info, found = mostSpecific(infos, addr, reason)
+	if found {
+		return info, true, nil
+	}
+
+	// ranges that were inserted before the index existed or whose prefixes were overwritten
+	infos, err = b.List(ctx)
+	if err != nil {
+		return RangeInfo{}, false, err
+	}
+	info, found = mostSpecific(infos, addr, reason)
+	if !found {
+		return RangeInfo{}, false, nil
+	}
+
+	_, err = b.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		b.index(ctx, p, info.Range)
+		return nil
+	})
+	if err != nil {
+		return RangeInfo{}, false, fmt.Errorf("failed to index %s: %w", info.Range, err)
+	}
+	return info, true, nil
+}
+
+// mostSpecific returns the range with the reason that contains the ip.
+// The smallest range has the biggest first address among the ranges that contain the ip.
+func mostSpecific(infos []RangeInfo, addr netip.Addr, reason string) (info RangeInfo, found bool) {
+	var bestFirst netip.Addr
+	for _, ri := range infos {
+		if ri.Reason != reason || !ri.Contains(addr) {
+			continue
+		}
+		first, _, _ := ParseRange(ri.Range)
+		if !found || bestFirst.Less(first) {
+			info, found, bestFirst = ri, true, first
+		}
+	}
+	return info, found
+}
+
+// index adds the prefixes of the range to the index
+func (b *Blacklist) index(ctx context.Context, p redis.Pipeliner, ipRange string) {
+	prefixes := rangeKeys(ipRange)
+	if len(prefixes) == 0 {
+		return
+	}
+	values := make([]any, 0, 2*len(prefixes))
+	for _, prefix := range prefixes {
+		values = append(values, prefix, ipRange)
+	}
+	p.HSet(ctx, b.indexKey, values...)
+}
+
+// rangeKeys returns the keys of the CIDR prefixes that cover the range exactly
+func rangeKeys(ipRange string) []string {
+	prefixes, err := RangePrefixes(ipRange)
+	if err != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		keys = append(keys, prefixKey(p))
+	}
+	return keys
+}
+
+// Sweep removes all expired ranges and returns the number of removed ranges.
+// Unexpired ranges that overlap with the expired ranges are kept.
+func (b *Blacklist) Sweep(ctx context.Context) (int, error) {
+	expired, err := b.rdb.ZRangeByScore(ctx, b.expiresKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// the overlapping parts of unexpired ranges are restored
+	err = b.Withdraw(ctx, expired...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove expired ranges: %w", err)
+	}
+	return len(expired), nil
+}
+
+// RunSweeper removes expired ranges periodically until the context is canceled.
+func (b *Blacklist) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := b.Sweep(ctx)
+		if err != nil {
+			log.Printf("[ERROR]: sweeper: %v\n", err)
+		}
+		if removed > 0 {
+			log.Printf("[sweeper]: removed %d expired ip ranges\n", removed)
+		}
+	}
+}
@@ -0,0 +1,568 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jxsl13/goripr/v2"
+)
+
+// testRange is a range that is inserted into the test blacklist
+type testRange struct {
+	ipRange string
+	reason  string
+	source  string
+	ttl     time.Duration
+}
+
+// expectReasons checks the cached reason of every ip, an empty reason is not blacklisted
+func expectReasons(t *testing.T, bl *Blacklist, reasons map[string]string) {
+	t.Helper()
+	for ip, want := range reasons {
+		reason, err := bl.Find(context.Background(), ip)
+		if errors.Is(err, goripr.ErrIPNotFound) {
+			reason, err = "", nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason != want {
+			t.Errorf("expected %s to be blacklisted with %q, got %q", ip, want, reason)
+		}
+	}
+}
+
+func TestBlacklistWithdraw(t *testing.T) {
+	tests := []struct {
+		name     string
+		inserted []testRange
+		withdraw []string
+		reasons  map[string]string
+	}{
+		{
+			name: "overlapping manual range is restored",
+			inserted: []testRange{
+				{"10.0.0.0/8", "manual", SourceManual, 0},
+				{"10.1.0.0/16", "VPN (feed:a)", SourceFeed("a"), 0},
+			},
+			withdraw: []string{"10.1.0.0/16"},
+			reasons: map[string]string{
+				"10.1.2.3": "manual",
+				"10.2.0.1": "manual",
+			},
+		},
+		{
+			name: "overlapping ranges of other feeds are restored",
+			inserted: []testRange{
+				{"10.0.0.0-10.0.0.255", "VPN (feed:a)", SourceFeed("a"), 0},
+				{"10.0.0.128/25", "TOR (feed:b)", SourceFeed("b"), 0},
+				{"10.0.0.200", "HOSTING (feed:c)", SourceFeed("c"), 0},
+			},
+			withdraw: []string{"10.0.0.128/25"},
+			reasons: map[string]string{
+				"10.0.0.1":   "VPN (feed:a)",
+				"10.0.0.130": "VPN (feed:a)",
+				"10.0.0.200": "HOSTING (feed:c)",
+			},
+		},
+		{
+			name: "newer ranges keep their reason",
+			inserted: []testRange{
+				{"10.0.0.0/8", "VPN (feed:a)", SourceFeed("a"), 0},
+				{"10.1.0.0/16", "manual", SourceManual, 0},
+				{"10.0.0.0/24", "TOR (feed:b)", SourceFeed("b"), 0},
+			},
+			withdraw: []string{"10.0.0.0/24"},
+			reasons: map[string]string{
+				"10.0.0.1": "VPN (feed:a)",
+				"10.1.0.1": "manual",
+				"10.2.0.1": "VPN (feed:a)",
+			},
+		},
+		{
+			name: "detected ips are restored",
+			inserted: []testRange{
+				{"1.2.3.4", "VPN", SourceAPI, time.Hour},
+				{"1.2.3.0/24", "HOSTING (feed:a)", SourceFeed("a"), 0},
+			},
+			withdraw: []string{"1.2.3.0/24"},
+			reasons: map[string]string{
+				"1.2.3.4": "VPN",
+				"1.2.3.5": "",
+			},
+		},
+		{
+			name: "expired ranges are not restored",
+			inserted: []testRange{
+				{"10.0.0.0/8", "manual", SourceManual, time.Nanosecond},
+				{"10.1.0.0/16", "VPN (feed:a)", SourceFeed("a"), 0},
+			},
+			withdraw: []string{"10.1.0.0/16"},
+			reasons: map[string]string{
+				"10.1.0.1": "",
+			},
+		},
+		{
+			name: "several ranges",
+			inserted: []testRange{
+				{"10.0.0.0/8", "manual", SourceManual, 0},
+				{"10.1.0.0/16", "VPN (feed:a)", SourceFeed("a"), 0},
+				{"10.2.0.0/16", "VPN (feed:a)", SourceFeed("a"), 0},
+				{"2001:db8::/32", "VPN (feed:a)", SourceFeed("a"), 0},
+			},
+			withdraw: []string{"10.1.0.0/16", "10.2.0.0/16", "2001:db8::/32"},
+			reasons: map[string]string{
+				"10.1.0.1":    "manual",
+				"10.2.0.1":    "manual",
+				"2001:db8::1": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			rdb, options := newTestRedis(t)
+			bl := newTestBlacklist(t, rdb, options)
+
+			for _, r := range tt.inserted {
+				err := bl.Insert(ctx, r.ipRange, r.reason, r.source, r.ttl)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := bl.Withdraw(ctx, tt.withdraw...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectReasons(t, bl, tt.reasons)
+
+			for _, ipRange := range tt.withdraw {
+				_, found, err := bl.Info(ctx, ipRange)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found {
+					t.Errorf("expected metadata of %s to be removed", ipRange)
+				}
+			}
+		})
+	}
+}
+
+func TestBlacklistRemove(t *testing.T) {
+	tests := []struct {
+		name     string
+		inserted []testRange
+		remove   []string
+		// ranges that are inserted after the removal
+		insertedAfter []testRange
+		// ranges that are withdrawn at the end
+		withdraw []string
+		sweep    bool
+		reasons  map[string]string
+		// expected parts of the listed ranges, range -> parts
+		parts map[string][]string
+	}{
+		{
+			name: "removed parts are not restored by withdrawals",
+			inserted: []testRange{
+				{"10.0.0.0/16", "manual", SourceManual, 0},
+				{"10.0.0.0/22", "VPN (feed:a)", SourceFeed("a"), 0},
+			},
+			remove:   []string{"10.0.1.0/24"},
+			withdraw: []string{"10.0.0.0/22"},
+			reasons: map[string]string{
+				"10.0.0.5": "manual",
+				"10.0.1.5": "",
+				"10.0.2.5": "manual",
+				"10.0.4.1": "manual",
+			},
+			parts: map[string][]string{
+				"10.0.0.0/16": {"10.0.0.0/24", "10.0.2.0-10.0.255.255"},
+			},
+		},
+		{
+			name: "removed parts are not restored by the sweeper",
+			inserted: []testRange{
+				{"10.0.0.0/16", "manual", SourceManual, 0},
+				{"10.0.3.3", "VPN", SourceAPI, time.Nanosecond},
+			},
+			remove: []string{"10.0.1.0/24"},
+			sweep:  true,
+			reasons: map[string]string{
+				"10.0.1.5": "",
+				"10.0.3.3": "manual",
+			},
+			parts: map[string][]string{
+				"10.0.0.0/16": {"10.0.0.0/24", "10.0.2.0-10.0.255.255"},
+			},
+		},
+		{
+			name: "removed parts of the withdrawn ranges are kept",
+			inserted: []testRange{
+				{"10.0.0.0/16", "manual", SourceManual, 0},
+				{"10.0.0.0/24", "VPN (feed:a)", SourceFeed("a"), 0},
+			},
+			remove:   []string{"10.0.0.128/25"},
+			withdraw: []string{"10.0.0.0/24"},
+			reasons: map[string]string{
+				"10.0.0.1":   "manual",
+				"10.0.0.200": "",
+				"10.0.1.1":   "manual",
+			},
+			parts: map[string][]string{
+				"10.0.0.0/16": {"10.0.0.0/25", "10.0.1.0-10.0.255.255"},
+			},
+		},
+		{
+			name: "ranges inserted after the removal are restored",
+			inserted: []testRange{
+				{"10.0.0.0/16", "manual", SourceManual, 0},
+			},
+			remove: []string{"10.0.1.0/24"},
+			insertedAfter: []testRange{
+				{"10.0.1.0/25", "later", SourceManual, 0},
+				{"10.0.0.0/23", "VPN (feed:a)", SourceFeed("a"), 0},
+			},
+			withdraw: []string{"10.0.0.0/23"},
+			reasons: map[string]string{
+				"10.0.0.5":   "manual",
+				"10.0.1.5":   "later",
+				"10.0.1.200": "",
+			},
+			parts: map[string][]string{
+				"10.0.0.0/16": {"10.0.0.0/24", "10.0.2.0-10.0.255.255"},
+				"10.0.1.0/25": {"10.0.1.0/25"},
+			},
+		},
+		{
+			name: "exactly matching ranges are removed",
+			inserted: []testRange{
+				{"10.0.0.0/16", "manual", SourceManual, 0},
+				{"1.2.3.4", "VPN", SourceAPI, time.Hour},
+			},
+			remove:  []string{"10.0.0.0/16"},
+			reasons: map[string]string{"10.0.0.1": "", "1.2.3.4": "VPN"},
+			parts:   map[string][]string{"1.2.3.4": {"1.2.3.4"}},
+		},
+		{
+			name: "containing removals remove the ranges",
+			inserted: []testRange{
+				{"10.0.0.0/24", "a", SourceManual, 0},
+				{"10.0.1.0-10.0.1.20", "b", SourceManual, 0},
+			},
+			remove:  []string{"10.0.0.0/16"},
+			reasons: map[string]string{"10.0.0.1": "", "10.0.1.1": ""},
+			parts:   map[string][]string{},
+		},
+		{
+			name: "adjacent removals are merged",
+			inserted: []testRange{
+				{"2001:db8::/126", "manual", SourceManual, 0},
+			},
+			remove: []string{"2001:db8::1", "2001:db8::2"},
+			reasons: map[string]string{
+				"2001:db8::":  "manual",
+				"2001:db8::1": "",
+				"2001:db8::3": "manual",
+			},
+			parts: map[string][]string{
+				"2001:db8::/126": {"2001:db8::", "2001:db8::3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			rdb, options := newTestRedis(t)
+			bl := newTestBlacklist(t, rdb, options)
+
+			insert := func(ranges []testRange) {
+				for _, r := range ranges {
+					err := bl.Insert(ctx, r.ipRange, r.reason, r.source, r.ttl)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			insert(tt.inserted)
+			for _, ipRange := range tt.remove {
+				err := bl.Remove(ctx, ipRange)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			insert(tt.insertedAfter)
+			err := bl.Withdraw(ctx, tt.withdraw...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.sweep {
+				_, err = bl.Sweep(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			expectReasons(t, bl, tt.reasons)
+
+			infos, err := bl.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(infos) != len(tt.parts) {
+				t.Fatalf("expected metadata of %v, got %v", tt.parts, infos)
+			}
+			for _, info := range infos {
+				parts := info.Parts()
+				if strings.Join(parts, ",") != strings.Join(tt.parts[info.Range], ",") {
+					t.Errorf("expected %s to consist of %v, got %v", info.Range, tt.parts[info.Range], parts)
+				}
+			}
+		})
+	}
+}
+
+func TestBlacklistSweep(t *testing.T) {
+	tests := []struct {
+		name     string
+		inserted []testRange
+		removed  int
+		reasons  map[string]string
+		// ranges whose metadata is expected to be kept
+		kept []string
+	}{
+		{
+			name: "expired ranges are removed",
+			inserted: []testRange{
+				{"1.2.3.4", "VPN", SourceAPI, time.Nanosecond},
+				{"1.2.3.5", "VPN", SourceAPI, time.Hour},
+			},
+			removed: 1,
+			reasons: map[string]string{
+				"1.2.3.4": "",
+				"1.2.3.5": "VPN",
+			},
+			kept: []string{"1.2.3.5"},
+		},
+		{
+			name: "overlapping permanent ranges are kept",
+			inserted: []testRange{
+				{"10.0.0.0/8", "manual", SourceManual, 0},
+				{"10.1.0.0/16", "added", SourceManual, time.Nanosecond},
+				{"1.2.3.0/24", "HOSTING (feed:a)", SourceFeed("a"), 0},
+				{"1.2.3.4", "VPN", SourceAPI, time.Nanosecond},
+			},
+			removed: 2,
+			reasons: map[string]string{
+				"10.1.0.1": "manual",
+				"1.2.3.4":  "HOSTING (feed:a)",
+			},
+			kept: []string{"10.0.0.0/8", "1.2.3.0/24"},
+		},
+		{
+			name: "nothing expired",
+			inserted: []testRange{
+				{"10.0.0.0/8", "manual", SourceManual, 0},
+			},
+			reasons: map[string]string{
+				"10.0.0.1": "manual",
+			},
+			kept: []string{"10.0.0.0/8"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			rdb, options := newTestRedis(t)
+			bl := newTestBlacklist(t, rdb, options)
+
+			for _, r := range tt.inserted {
+				err := bl.Insert(ctx, r.ipRange, r.reason, r.source, r.ttl)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			removed, err := bl.Sweep(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed != tt.removed {
+				t.Fatalf("expected %d removed ranges, got %d", tt.removed, removed)
+			}
+			expectReasons(t, bl, tt.reasons)
+
+			infos, err := bl.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed := make([]string, 0, len(infos))
+			for _, info := range infos {
+				listed = append(listed, info.Range)
+			}
+			if len(listed) != len(tt.kept) {
+				t.Fatalf("expected metadata of %v, got %v", tt.kept, listed)
+			}
+			for _, ipRange := range tt.kept {
+				_, found, err := bl.Info(ctx, ipRange)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !found {
+					t.Errorf("expected metadata of %s to be kept", ipRange)
+				}
+			}
+		})
+	}
+}
+
+func TestBlacklistLookup(t *testing.T) {
+	inserted := []testRange{
+		{"10.0.0.0/8", "manual", SourceManual, 0},
+		{"10.1.0.0-10.1.0.99", "VPN (feed:a)", SourceFeed("a"), 0},
+		{"10.1.0.5", "VPN", SourceAPI, time.Hour},
+		{"192.168.0.0/24", "TOR (feed:b)", SourceFeed("b"), 0},
+		// inserted after the smaller range, which is why it owns the overlapping part
+		{"192.168.0.0/16", "HOSTING (feed:c)", SourceFeed("c"), 0},
+		{"2001:db8::/32", "VPN (feed:a)", SourceFeed("a"), 0},
+	}
+
+	tests := []struct {
+		ip    string
+		found bool
+		// expected range of the ip
+		ipRange string
+	}{
+		{ip: "10.1.0.5", found: true, ipRange: "10.1.0.5"},
+		{ip: "::ffff:10.1.0.5", found: true, ipRange: "10.1.0.5"},
+		{ip: "10.1.0.6", found: true, ipRange: "10.1.0.0-10.1.0.99"},
+		{ip: "10.1.0.100", found: true, ipRange: "10.0.0.0/8"},
+		{ip: "10.255.255.255", found: true, ipRange: "10.0.0.0/8"},
+		{ip: "192.168.0.1", found: true, ipRange: "192.168.0.0/16"},
+		{ip: "2001:db8::1", found: true, ipRange: "2001:db8::/32"},
+		{ip: "1.2.3.4"},
+		{ip: "2001:db9::1"},
+	}
+
+	ctx := context.Background()
+	rdb, options := newTestRedis(t)
+	bl := newTestBlacklist(t, rdb, options)
+	for _, r := range inserted {
+		err := bl.Insert(ctx, r.ipRange, r.reason, r.source, r.ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.ip, func(t *testing.T) {
+				info, found, err := bl.Lookup(ctx, tt.ip)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found != tt.found || info.Range != tt.ipRange {
+					t.Fatalf("expected range %q (%t), got %q (%t)", tt.ipRange, tt.found, info.Range, found)
+				}
+			})
+		}
+	}
+
+	t.Run("indexed", check)
+
+	// ranges that were inserted before the index existed are found and indexed
+	err := rdb.Del(ctx, bl.indexKey).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("without index", check)
+
+	indexed, err := rdb.HLen(ctx, bl.indexKey).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexed == 0 {
+		t.Fatal("expected the found ranges to be indexed")
+	}
+	t.Run("reindexed", check)
+
+	_, _, err = bl.Lookup(ctx, "not an ip")
+	if err == nil {
+		t.Fatal("expected an error for invalid ips")
+	}
+}
+
+func TestBlacklistLegacy(t *testing.T) {
+	tests := []struct {
+		name string
+		// keys that exist before the first call
+		keys     []string
+		inserted []testRange
+		legacy   bool
+	}{
+		{name: "empty database"},
+		{name: "ranges of older versions", keys: []string{"goripr:boundaries"}, legacy: true},
+		{
+			name:     "ranges with metadata",
+			keys:     []string{"goripr:boundaries"},
+			inserted: []testRange{{"10.0.0.0/8", "manual", SourceManual, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			rdb, options := newTestRedis(t)
+			bl := newTestBlacklist(t, rdb, options)
+
+			for _, key := range tt.keys {
+				err := rdb.Set(ctx, key, "1", 0).Err()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, r := range tt.inserted {
+				err := bl.Insert(ctx, r.ipRange, r.reason, r.source, r.ttl)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			legacy, err := bl.Legacy(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if legacy != tt.legacy {
+				t.Fatalf("expected legacy %t, got %t", tt.legacy, legacy)
+			}
+
+			// ranges that are inserted afterwards do not change the result
+			err = bl.Insert(ctx, "1.2.3.4", "VPN", SourceAPI, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			legacy, err = bl.Legacy(ctx)
+			if err != nil || legacy != tt.legacy {
+				t.Fatalf("expected legacy %t to be kept, got %t: %v", tt.legacy, legacy, err)
+			}
+
+			// deleting the key determines it again
+			err = rdb.Del(ctx, bl.legacyKey).Err()
+			if err != nil {
+				t.Fatal(err)
+			}
+			legacy, err = bl.Legacy(ctx)
+			if err != nil || legacy {
+				t.Fatalf("expected no legacy ranges after recording metadata, got %t: %v", legacy, err)
+			}
+		})
+	}
+}
@@ -6,14 +6,20 @@ import (
 	"fmt"
 	"log"
 	"net/netip"
+	"time"
 
 	"github.com/jxsl13/goripr/v2"
 )
 
+// ErrNoQuorum is returned by rechecks in case less than the required number of apis answered validly
+var ErrNoQuorum = errors.New("not enough valid api answers")
+
 // Valid is used to represent the answer of an api endpoint
 type Valid struct {
 	IsValid bool
-	IsVPN   bool
+	Verdict Verdict
+	// Err is the reason of invalid answers
+	Err error
 }
 
 // VPNChecker encapsulates the redis database as cache and the
@@ -22,43 +28,88 @@ type Valid struct {
 // an ip is a vpn.
 type VPNChecker struct {
 	ctx context.Context
-	r   *goripr.Client
+	bl  *Blacklist
+	al  *Allowlist
+	ttl time.Duration
+
+	apis       []VPN
+	local      []VPN
+	weights    map[string]float64
+	apiTimeout time.Duration
+	quorum     int
+	offline    bool
+	threshold  float64
 
-	apis      []VPN
-	offline   bool
-	threshold float64
+	wl      Whitelister
+	recheck time.Duration
 
-	wl *Whitelister
+	lookups lookupGroup
 }
 
 func (rdb *VPNChecker) Close() error {
-	return rdb.r.Close()
+	return rdb.bl.Close()
 }
 
 // newVPNChecker creates a new checker that can be asked for VPN IPs.
 // it connects to the redis database for caching and requests information from all existing
 // API endpoints that provode free VPN detections.
+// local databases do not require network access and are checked before the online apis, even in offline mode.
+// weights maps the api names to the weight of their answers, missing apis have a weight of 1.
+// apiTimeout is the maximum time that is waited for all of the API endpoints to answer.
+// ips are only whitelisted in case at least quorum apis answered validly.
+// blacklistTTL is the time to live of ips that were detected by the apis, 0 keeps them forever.
+// allowed ranges of the optional allowlist are never considered to be vpns.
+// whitelisted ips that are older than recheckAfter are verified again in the background, 0 disables the recheck.
 func NewVPNChecker(
 	ctx context.Context,
-	ripr *goripr.Client,
-	wl *Whitelister,
+	bl *Blacklist,
+	al *Allowlist,
+	blacklistTTL time.Duration,
+	wl Whitelister,
+	recheckAfter time.Duration,
 	vpns []VPN,
+	local []VPN,
+	weights map[string]float64,
+	apiTimeout time.Duration,
+	quorum int,
 	offline bool,
 	permabanThreshold float64,
 ) *VPNChecker {
 	return &VPNChecker{
-		ctx:       ctx,
-		r:         ripr,
-		apis:      vpns,
-		offline:   offline,
-		threshold: permabanThreshold,
-		wl:        wl,
+		ctx:        ctx,
+		bl:         bl,
+		al:         al,
+		ttl:        blacklistTTL,
+		apis:       vpns,
+		local:      local,
+		weights:    weights,
+		apiTimeout: apiTimeout,
+		quorum:     quorum,
+		offline:    offline,
+		threshold:  permabanThreshold,
+		wl:         wl,
+		recheck:    recheckAfter,
 	}
 }
 
+// allowed returns whether the ip is part of an allowed range and the reason of that range
+func (rdb *VPNChecker) allowed(sIP string) (found bool, reason string, err error) {
+	if rdb.al == nil {
+		return false, "", nil
+	}
+
+	reason, err = rdb.al.Find(rdb.ctx, sIP)
+	if errors.Is(err, goripr.ErrIPNotFound) {
+		return false, "", nil
+	} else if err != nil {
+		return false, "", err
+	}
+	return true, reason, nil
+}
+
 func (rdb *VPNChecker) foundInCache(sIP string) (found bool, isVPN bool, reason string, err error) {
 
-	reason, err = rdb.r.Find(rdb.ctx, sIP)
+	reason, err = rdb.bl.Find(rdb.ctx, sIP)
 	if errors.Is(err, goripr.ErrIPNotFound) {
 		return false, false, reason, nil
 	} else if err != nil {
@@ -68,112 +119,405 @@ func (rdb *VPNChecker) foundInCache(sIP string) (found bool, isVPN bool, reason
 	return true, true, reason, nil
 }
 
-func (rdb *VPNChecker) foundOnline(sIP string) (IsVPN bool) {
+// foundLocally asks the local databases until one of them flags the ip with a confidence that
+// reaches the threshold and adds their answers to the report.
+func (rdb *VPNChecker) foundLocally(sIP string, r *Report) (IsVPN bool) {
+	for _, db := range rdb.local {
+		verdict, err := db.IsVPN(rdb.ctx, sIP)
+		r.Local = append(r.Local, newAPIAnswer(db, 0, Valid{IsValid: err == nil, Verdict: verdict, Err: err}))
+		if err != nil {
+			log.Println("[ERROR]:", db.String(), ":", err)
+			continue
+		}
+		if verdict.Confidence >= rdb.threshold {
+			log.Printf("[local]: %s: %s: %s\n", db, sIP, verdict)
+			r.VPN, r.Reason, r.Source = true, LocalReason(verdict.Category), "local"
+			return true
+		}
+	}
+	return false
+}
 
-	results := make([]Valid, len(rdb.apis))
+// answer is the result of a single api endpoint
+type answer struct {
+	idx   int
+	valid Valid
+}
+
+// foundOnline asks all apis and returns whether the weighted score of their answers
+// reaches the threshold. reason describes why the ip was flagged, e.g. VPN (f/o) or TOR (f/o).
+// valid is the number of apis that answered validly.
+func (rdb *VPNChecker) foundOnline(sIP string) (IsVPN bool, reason string, valid int) {
+	IsVPN, reason, _, valid = rdb.decide(sIP, rdb.askOnline(sIP))
+	return IsVPN, reason, valid
+}
+
+// askOnline asks all apis concurrently and returns their answers in the order of the apis.
+// answers that did not arrive in time are invalid.
+func (rdb *VPNChecker) askOnline(sIP string) []Valid {
+
+	ctx, cancel := context.WithTimeout(rdb.ctx, rdb.apiTimeout)
+	defer cancel()
+
+	// buffered in order for late answers not to block their goroutines
+	answers := make(chan answer, len(rdb.apis))
 
 	for idx, api := range rdb.apis {
+		go func(idx int, api VPN) {
+			verdict, err := api.IsVPN(ctx, sIP)
+			if err != nil {
+				log.Println("[ERROR]:", api.String(), ":", err)
+				answers <- answer{
+					idx: idx,
+					valid: Valid{
+						IsValid: false,
+						Err:     err,
+					},
+				}
+				return
+			}
 
-		isVPNTmp, err := api.IsVPN(sIP)
-		if err != nil {
-			log.Println("[ERROR]:", api.String(), ":", err)
-			results[idx] = Valid{
-				IsValid: false,
-				IsVPN:   false,
+			answers <- answer{
+				idx: idx,
+				valid: Valid{
+					IsValid: true,
+					Verdict: verdict,
+				},
 			}
-			continue
-		}
+		}(idx, api)
+	}
 
-		results[idx] = Valid{
-			IsValid: true,
-			IsVPN:   isVPNTmp,
+	// answers that did not arrive in time are counted as invalid
+	results := make([]Valid, len(rdb.apis))
+	for idx := range results {
+		results[idx].Err = fmt.Errorf("no answer within %s", rdb.apiTimeout)
+	}
+collect:
+	for range rdb.apis {
+		select {
+		case a := <-answers:
+			results[a.idx] = a.valid
+		case <-ctx.Done():
+			log.Printf("[ERROR]: not all APIs answered within %s: %v", rdb.apiTimeout, ctx.Err())
+			break collect
 		}
 	}
+	return results
+}
 
+// decide returns whether the weighted score of the answers of the apis reaches the threshold
+// and the number of valid answers the decision is based on.
+func (rdb *VPNChecker) decide(sIP string, results []Valid) (IsVPN bool, reason string, percentage float64, valid int) {
 	total := 0.0
-	trueValue := 0.0
-	for _, valid := range results {
-		if valid.IsValid {
-			total += 1.0
-			if valid.IsVPN {
-				trueValue += 1.0
-			}
+	score := 0.0
+	// weighted confidence per flagged category
+	categories := make(map[Category]float64, 4)
+	for idx, result := range results {
+		if !result.IsValid {
+			continue
+		}
+		valid++
+		weight := rdb.weight(rdb.apis[idx])
+		verdict := result.Verdict
+		log.Printf("[online]: %s: %s: %s (weight %.2f)\n", rdb.apis[idx], sIP, verdict, weight)
+
+		total += weight
+		score += weight * verdict.Confidence
+		if verdict.Category != CategoryNone {
+			categories[verdict.Category] += weight * verdict.Confidence
 		}
 	}
 
 	if total == 0.0 {
 		log.Println("[ERROR]: All APIs seem to have exceeded their rate limitations.")
-		IsVPN = false
-		return
+		return false, "", 0, valid
 	}
-	percentage := trueValue / total
+	percentage = score / total
 
-	return percentage >= float64(rdb.threshold)
+	if percentage < float64(rdb.threshold) {
+		return false, "", percentage, valid
+	}
+	return true, OnlineReason(dominantCategory(categories)), percentage, valid
 }
 
-// IsVPN checks firstly in cache and then online.
-func (rdb *VPNChecker) IsVPN(sIP string) (bool, string, error) {
+// dominantCategory returns the category with the highest weighted confidence.
+// Defaults to CategoryVPN in case the apis did not provide any category.
+func dominantCategory(categories map[Category]float64) Category {
+	var (
+		dominant = CategoryVPN
+		maxScore = 0.0
+	)
+	for category, score := range categories {
+		// sort by name in case of equal scores for deterministic results
+		if score > maxScore || (score > 0 && score == maxScore && category < dominant) {
+			dominant = category
+			maxScore = score
+		}
+	}
+	return dominant
+}
+
+// whitelisted returns whether the ip was recently found not to be a vpn
+func (rdb *VPNChecker) whitelisted(sIP string) (bool, error) {
+	if rdb.wl == nil {
+		return false, nil
+	}
+	return rdb.wl.Exists(sIP)
+}
 
+// weight returns the weight of the answers of the given api
+func (rdb *VPNChecker) weight(api VPN) float64 {
+	weight, found := rdb.weights[api.String()]
+	if !found {
+		return 1
+	}
+	return weight
+}
+
+// normalizeIP validates the ip and returns its canonical string representation
+func normalizeIP(sIP string) (string, error) {
 	ip, err := netip.ParseAddr(sIP)
 	if err != nil {
-		return false, "", fmt.Errorf("invalid IP passed: %s: %w", sIP, err)
+		return "", fmt.Errorf("invalid IP passed: %s: %w", sIP, err)
 	}
-	if !ip.Is4() {
-		return false, "", fmt.Errorf("invalid IP passed, expected IPv4, got: %s", sIP)
+	// ipv4 mapped ipv6 addresses are looked up as plain ipv4 addresses
+	ip = ip.Unmap().WithZone("")
+	if ip.IsUnspecified() {
+		return "", fmt.Errorf("invalid IP passed, unspecified address: %s", sIP)
 	}
+	return ip.String(), nil
+}
 
-	IPStr := ip.String()
+// IsVPN checks firstly in the allowlist, then in cache and then online.
+func (rdb *VPNChecker) IsVPN(sIP string) (bool, string, error) {
+
+	IPStr, err := normalizeIP(sIP)
+	if err != nil {
+		return false, "", err
+	}
+
+	// concurrent lookups of the same ip share a single resolution
+	isVPN, reason, shared, err := rdb.lookups.Do(IPStr, func() (bool, string, error) {
+		return rdb.isVPN(IPStr)
+	})
+	if shared {
+		log.Println("[shared lookup]: ", IPStr)
+	}
+	return isVPN, reason, err
+}
 
-	found, isVPN, reason, err := rdb.foundInCache(IPStr)
+// isVPN checks the normalized ip firstly in the allowlist, then in cache and then online.
+func (rdb *VPNChecker) isVPN(IPStr string) (bool, string, error) {
+	r, err := rdb.check(IPStr, false, false)
 	if err != nil {
 		return false, "", err
 	}
+	return r.VPN, r.Reason, nil
+}
+
+// check is the detection pipeline of the normalized ip, which is used by IsVPN and Explain.
+// It checks the allowlist, the cache, the local databases, the whitelist and finally asks the apis.
+// noCache skips the allowlist, the cache and the whitelist, noWrite does not cache the online result.
+func (rdb *VPNChecker) check(IPStr string, noCache, noWrite bool) (r Report, err error) {
+	r = Report{
+		IP:        IPStr,
+		Threshold: rdb.threshold,
+		Quorum:    rdb.quorum,
+	}
+
+	if !noCache {
+		r.Allowed, r.AllowReason, err = rdb.allowed(IPStr)
+		if err != nil {
+			return r, err
+		}
+
+		if r.Allowed {
+			log.Printf("[allowed]: %s (%s)\n", IPStr, r.AllowReason)
+			r.Source = "allowlist"
+			return r, nil
+		}
 
-	if found {
-		log.Println("[in cache]: ", IPStr)
-		return isVPN, reason, nil
+		r.Cached, r.VPN, r.CacheReason, err = rdb.foundInCache(IPStr)
+		if err != nil {
+			return r, err
+		}
+
+		if r.Cached {
+			log.Println("[in cache]: ", IPStr)
+			r.Reason, r.Source = r.CacheReason, "cache"
+			return r, nil
+		}
+
+		log.Println("[not in cache]: ", IPStr)
 	}
 
-	log.Println("[not in cache]: ", IPStr)
+	// local databases are cheap and may be updated, which is why their results are not cached
+	if rdb.foundLocally(IPStr, &r) {
+		return r, nil
+	}
 
 	// not found, lookup online
 	if rdb.offline {
 		log.Println("[skipping online check]:", IPStr)
 		// if the detection is offline, cache only,
 		// caching of default no values makes no sense, so no caching here.
-		return false, "", nil
+		r.Source = "offline"
+		return r, nil
 	}
 
-	// found nuts?
-	found, err = rdb.wl.Exists(IPStr)
-	if err != nil {
-		log.Printf("[error]: %v", err)
-		return false, "", err
+	if !noCache {
+		r.Whitelisted, err = rdb.whitelisted(IPStr)
+		if err != nil {
+			log.Printf("[error]: %v", err)
+			return r, err
+		}
+
+		if r.Whitelisted {
+			log.Println("[whitelisted]: ", IPStr)
+			rdb.recheckIfStale(IPStr)
+			r.Source = "whitelist"
+			return r, nil
+		}
+		log.Println("[not whitelisted]: ", IPStr)
 	}
 
-	if found {
-		log.Println("[whitelisted]: ", IPStr)
-		return false, "", nil
+	results := rdb.askOnline(IPStr)
+	for idx, valid := range results {
+		r.Online = append(r.Online, newAPIAnswer(rdb.apis[idx], rdb.weight(rdb.apis[idx]), valid))
 	}
-	log.Println("[not whitelisted]: ", IPStr)
+	r.VPN, r.Reason, r.Score, r.Answers = rdb.decide(IPStr, results)
+	r.Source = "online"
+	log.Printf("[online]:  %s\n", IPStr)
 
-	isOnlineVPN := rdb.foundOnline(IPStr)
+	if noWrite {
+		return r, nil
+	}
+
+	// ignored allowlists must not be contradicted by the cached result
+	if noCache {
+		allowed, _, err := rdb.allowed(IPStr)
+		if err != nil {
+			return r, err
+		}
+		if allowed {
+			log.Printf("[not cached]: %s is allowed\n", IPStr)
+			return r, nil
+		}
+	}
+	rdb.cache(IPStr, r.VPN, r.Reason, r.Answers)
+	return r, nil
+}
+
+// lookupOnline asks the apis and caches their result either in the blacklist or in the whitelist.
+// valid is the number of apis that answered validly.
+func (rdb *VPNChecker) lookupOnline(IPStr string) (isVPN bool, reason string, valid int) {
+	isOnlineVPN, reason, valid := rdb.foundOnline(IPStr)
 	log.Printf("[online]:  %s\n", IPStr)
-	// update cache values
+	rdb.cache(IPStr, isOnlineVPN, reason, valid)
+	return isOnlineVPN, reason, valid
+}
+
+// cache stores the online result either in the blacklist or in the whitelist.
+// ips are not whitelisted in case less than quorum apis answered, as they were not actually checked.
+func (rdb *VPNChecker) cache(IPStr string, isOnlineVPN bool, reason string, valid int) {
 	if isOnlineVPN {
-		// forever vpn
-		e := rdb.r.Insert(rdb.ctx, IPStr, "VPN (f/o)")
+		e := rdb.bl.Insert(rdb.ctx, IPStr, reason, SourceAPI, rdb.ttl)
 		if e != nil {
 			log.Printf("[error]: failed to insert VPN IP found online: %s: %v", IPStr, e)
 		}
+		rdb.evict(IPStr)
+	} else if valid < rdb.quorum {
+		log.Printf("[not whitelisted]: %s, only %d of %d required apis answered\n", IPStr, valid, rdb.quorum)
 	} else {
 		// not vpn, cache in whitelist
-		e := rdb.wl.Whitelist(IPStr)
-		if e != nil {
-			log.Printf("[error]: %v", e)
+		if rdb.wl != nil {
+			e := rdb.wl.Whitelist(IPStr)
+			if e != nil {
+				log.Printf("[error]: %v", e)
+			}
+		}
+	}
+}
+
+// evict removes whitelisted ips and ranges that contradict the detection of the ip
+func (rdb *VPNChecker) evict(IPStr string) {
+	if rdb.wl == nil {
+		return
+	}
+	evicted, err := rdb.wl.Evict(IPStr)
+	if err != nil {
+		log.Printf("[error]: %v", err)
+	} else if evicted > 0 {
+		log.Printf("[evicted]: %d whitelist entries of %s\n", evicted, IPStr)
+	}
+}
+
+// recheckIfStale verifies the whitelisted ip again in the background in case it was whitelisted
+// longer than the recheck interval ago. The current answer is not delayed by the recheck.
+func (rdb *VPNChecker) recheckIfStale(IPStr string) {
+	if rdb.recheck <= 0 || rdb.offline {
+		return
+	}
+
+	since, found, err := rdb.wl.Since(IPStr)
+	if err != nil {
+		log.Printf("[error]: %v", err)
+		return
+	}
+	if !found || time.Since(since) < rdb.recheck {
+		return
+	}
+
+	go func() {
+		_, _, err := rdb.verify(IPStr)
+		if err != nil {
+			log.Printf("[error]: recheck: %s: %v", IPStr, err)
 		}
+	}()
+}
+
+// Recheck asks the apis again, ignoring the allowlist and both caches, and updates the caches with the result.
+// Previous api detections of the ip are removed from the blacklist in case the apis do not flag it anymore.
+// ErrNoQuorum is returned in case less than quorum apis answered, previous detections are kept in that case.
+func (rdb *VPNChecker) Recheck(sIP string) (bool, string, error) {
+	IPStr, err := normalizeIP(sIP)
+	if err != nil {
+		return false, "", err
 	}
+	if rdb.offline {
+		return false, "", errors.New("online checks are disabled in offline mode")
+	}
+	return rdb.verify(IPStr)
+}
+
+// verify looks up the normalized ip online, concurrent verifications of the same ip share a single lookup.
+func (rdb *VPNChecker) verify(IPStr string) (bool, string, error) {
+	isVPN, reason, _, err := rdb.lookups.Do("recheck:"+IPStr, func() (bool, string, error) {
+		log.Println("[recheck]: ", IPStr)
+		isVPN, reason, valid := rdb.lookupOnline(IPStr)
+		if isVPN {
+			log.Printf("[recheck]: %s was detected as %s\n", IPStr, reason)
+			return true, reason, nil
+		}
+
+		// failed apis must not unban known vpns
+		if valid < rdb.quorum {
+			return false, "", fmt.Errorf("%w: only %d of %d required apis answered", ErrNoQuorum, valid, rdb.quorum)
+		}
 
-	// else case, not found online
-	return isOnlineVPN, "", nil // reason 1 -> VPN
+		info, found, err := rdb.bl.Info(rdb.ctx, IPStr)
+		if err != nil {
+			return false, "", err
+		}
+		if found && info.Source == SourceAPI {
+			log.Printf("[recheck]: %s is not detected anymore, removing it from the cache\n", IPStr)
+			// overlapping ranges of other sources stay blacklisted
+			err = rdb.bl.Withdraw(rdb.ctx, IPStr)
+			if err != nil {
+				return false, "", err
+			}
+		}
+		return false, "", nil
+	})
+	return isVPN, reason, err
 }
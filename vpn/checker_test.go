@@ -0,0 +1,547 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jxsl13/goripr/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis starts an in-memory redis server that is stopped at the end of the test
+func newTestRedis(t *testing.T) (*redis.Client, goripr.Options) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return rdb, goripr.Options{Addr: mr.Addr()}
+}
+
+// newTestBlacklist creates a blacklist in the test redis database
+func newTestBlacklist(t *testing.T, rdb *redis.Client, options goripr.Options) *Blacklist {
+	t.Helper()
+	ripr, err := goripr.NewClient(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := NewBlacklist(ripr, rdb, "test:blacklist:")
+	t.Cleanup(func() { _ = bl.Close() })
+	return bl
+}
+
+// fakeAnswer is the answer of a fake api endpoint
+type fakeAnswer struct {
+	status int
+	body   string
+	delay  time.Duration
+}
+
+// newFakeServer serves the answer to every request
+func newFakeServer(t *testing.T, answer fakeAnswer) *url.URL {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(answer.delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(answer.status)
+		_, _ = w.Write([]byte(answer.body))
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+var (
+	ipapiVPN   = fakeAnswer{status: http.StatusOK, body: `{"status":"success","proxy":true}`}
+	ipapiClean = fakeAnswer{status: http.StatusOK, body: `{"status":"success"}`}
+	iqsVPN     = fakeAnswer{status: http.StatusOK, body: `{"success":true,"proxy":true,"vpn":true,"active_vpn":true}`}
+	iqsClean   = fakeAnswer{status: http.StatusOK, body: `{"success":true}`}
+	intelVPN   = fakeAnswer{status: http.StatusOK, body: `{"status":"success","result":"0.9"}`}
+	intelClean = fakeAnswer{status: http.StatusOK, body: `{"status":"success","result":"0"}`}
+	serverDown = fakeAnswer{status: http.StatusInternalServerError}
+	rateLimit  = fakeAnswer{status: http.StatusTooManyRequests}
+	tooSlow    = fakeAnswer{status: http.StatusOK, body: `{"status":"success","proxy":true}`, delay: 2 * time.Second}
+)
+
+func TestVPNCheckerIsVPN(t *testing.T) {
+	// getipintel outweighs each of the other apis
+	weights := map[string]float64{
+		"ip-api.com":         1,
+		"ipqualityscore.com": 1,
+		"getipintel.net":     2,
+	}
+
+	tests := []struct {
+		name   string
+		ipapi  fakeAnswer
+		iqs    fakeAnswer
+		intel  fakeAnswer
+		quorum int
+
+		vpn         bool
+		reason      string
+		whitelisted bool
+	}{
+		{
+			name:        "all clean",
+			ipapi:       ipapiClean,
+			iqs:         iqsClean,
+			intel:       intelClean,
+			quorum:      1,
+			whitelisted: true,
+		},
+		{
+			name:   "all flagged",
+			ipapi:  ipapiVPN,
+			iqs:    iqsVPN,
+			intel:  intelVPN,
+			quorum: 1,
+			vpn:    true,
+			reason: OnlineReason(CategoryVPN),
+		},
+		{
+			name:   "weighted score reaches threshold",
+			ipapi:  ipapiVPN,
+			iqs:    iqsClean,
+			intel:  intelVPN,
+			quorum: 1,
+			// (1 + 2*0.9) / 4 = 0.7
+			vpn:    true,
+			reason: OnlineReason(CategoryVPN),
+		},
+		{
+			name:   "weighted score below threshold",
+			ipapi:  ipapiClean,
+			iqs:    iqsClean,
+			intel:  intelVPN,
+			quorum: 1,
+			// 2*0.9 / 4 = 0.45
+			whitelisted: true,
+		},
+		{
+			name:   "heavy api outvotes the others",
+			ipapi:  ipapiVPN,
+			iqs:    iqsVPN,
+			intel:  intelClean,
+			quorum: 1,
+			// 2 / 4 = 0.5
+			whitelisted: true,
+		},
+		{
+			name:   "failed apis are not counted",
+			ipapi:  ipapiVPN,
+			iqs:    serverDown,
+			intel:  rateLimit,
+			quorum: 1,
+			vpn:    true,
+			reason: OnlineReason(CategoryVPN),
+		},
+		{
+			name:   "late answers are not counted",
+			ipapi:  tooSlow,
+			iqs:    iqsClean,
+			intel:  intelClean,
+			quorum: 1,
+			// the vpn answer of ip-api.com arrives after the timeout
+			whitelisted: true,
+		},
+		{
+			name:   "no answer",
+			ipapi:  serverDown,
+			iqs:    rateLimit,
+			intel:  tooSlow,
+			quorum: 1,
+		},
+		{
+			name:   "answers below quorum",
+			ipapi:  serverDown,
+			iqs:    iqsClean,
+			intel:  rateLimit,
+			quorum: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			rdb, options := newTestRedis(t)
+			bl := newTestBlacklist(t, rdb, options)
+			wl := NewRedisWhitelister(ctx, rdb, "test:whitelist:", time.Hour)
+
+			apis := []VPN{
+				NewIPAPI(http.DefaultClient, newFakeServer(t, tt.ipapi), "", testLimiter()),
+				NewIPQualityScore(http.DefaultClient, newFakeServer(t, tt.iqs), "secret", testLimiter()),
+				NewGetIPIntel(http.DefaultClient, newFakeServer(t, tt.intel), "admin@example.com", testLimiter()),
+			}
+			checker := NewVPNChecker(ctx, bl, nil, 0, wl, 0, apis, nil, weights, 500*time.Millisecond, tt.quorum, false, 0.6)
+
+			isVPN, reason, err := checker.IsVPN("1.2.3.4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isVPN != tt.vpn || reason != tt.reason {
+				t.Fatalf("expected vpn %t (%q), got %t (%q)", tt.vpn, tt.reason, isVPN, reason)
+			}
+
+			info, found, err := bl.Info(ctx, "1.2.3.4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.vpn || (found && info.Source != SourceAPI) {
+				t.Fatalf("expected blacklisted %t by the apis, got %t (%s)", tt.vpn, found, info.Source)
+			}
+
+			whitelisted, err := wl.Exists("1.2.3.4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if whitelisted != tt.whitelisted {
+				t.Fatalf("expected whitelisted %t, got %t", tt.whitelisted, whitelisted)
+			}
+		})
+	}
+}
+
+func TestVPNCheckerIsVPNCached(t *testing.T) {
+	ctx := context.Background()
+	rdb, options := newTestRedis(t)
+	bl := newTestBlacklist(t, rdb, options)
+
+	err := bl.Insert(ctx, "1.2.3.0/24", "banned", SourceManual, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// banned ranges are answered from the cache, other ips are asked online
+	apis := []VPN{NewIPAPI(http.DefaultClient, newFakeServer(t, rateLimit), "", testLimiter())}
+	checker := NewVPNChecker(ctx, bl, nil, 0, nil, 0, apis, nil, nil, time.Second, 1, false, 0.6)
+
+	for _, ip := range []string{"1.2.3.4", "::ffff:1.2.3.5"} {
+		isVPN, reason, err := checker.IsVPN(ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isVPN || reason != "banned" {
+			t.Fatalf("expected cached ban of %s, got %t (%q)", ip, isVPN, reason)
+		}
+	}
+
+	isVPN, _, err := checker.IsVPN("1.2.4.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isVPN {
+		t.Fatal("expected ip outside of the banned range not to be a vpn")
+	}
+
+	_, _, err = checker.IsVPN("not an ip")
+	if err == nil {
+		t.Fatal("expected an error for invalid ips")
+	}
+}
+
+func TestVPNCheckerRecheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		ipapi  fakeAnswer
+		iqs    fakeAnswer
+		quorum int
+		// source of the previous ban
+		source string
+
+		wantErr error
+		vpn     bool
+		banned  bool
+	}{
+		{
+			name:   "confirmed detection",
+			ipapi:  ipapiVPN,
+			iqs:    iqsVPN,
+			quorum: 1,
+			source: SourceAPI,
+			vpn:    true,
+			banned: true,
+		},
+		{
+			name:   "detection is removed",
+			ipapi:  ipapiClean,
+			iqs:    iqsClean,
+			quorum: 1,
+			source: SourceAPI,
+		},
+		{
+			name:   "manual bans are kept",
+			ipapi:  ipapiClean,
+			iqs:    iqsClean,
+			quorum: 1,
+			source: SourceManual,
+			banned: true,
+		},
+		{
+			name:    "no answer keeps the detection",
+			ipapi:   serverDown,
+			iqs:     rateLimit,
+			quorum:  1,
+			source:  SourceAPI,
+			wantErr: ErrNoQuorum,
+			banned:  true,
+		},
+		{
+			name:    "answers below quorum keep the detection",
+			ipapi:   ipapiClean,
+			iqs:     serverDown,
+			quorum:  2,
+			source:  SourceAPI,
+			wantErr: ErrNoQuorum,
+			banned:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			rdb, options := newTestRedis(t)
+			bl := newTestBlacklist(t, rdb, options)
+			wl := NewRedisWhitelister(ctx, rdb, "test:whitelist:", time.Hour)
+
+			err := bl.Insert(ctx, "1.2.3.4", "VPN (f/o)", tt.source, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			apis := []VPN{
+				NewIPAPI(http.DefaultClient, newFakeServer(t, tt.ipapi), "", testLimiter()),
+				NewIPQualityScore(http.DefaultClient, newFakeServer(t, tt.iqs), "secret", testLimiter()),
+			}
+			checker := NewVPNChecker(ctx, bl, nil, 0, wl, 0, apis, nil, nil, 500*time.Millisecond, tt.quorum, false, 0.6)
+
+			isVPN, _, err := checker.Recheck("1.2.3.4")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if isVPN != tt.vpn {
+				t.Fatalf("expected vpn %t, got %t", tt.vpn, isVPN)
+			}
+
+			_, banned, err := bl.Info(ctx, "1.2.3.4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if banned != tt.banned {
+				t.Fatalf("expected banned %t, got %t", tt.banned, banned)
+			}
+		})
+	}
+}
+
+// fakeLocal is a local database that answers every ip with the same verdict
+type fakeLocal struct {
+	verdict Verdict
+}
+
+func (f fakeLocal) String() string { return "local" }
+
+func (f fakeLocal) IsVPN(ctx context.Context, ip string) (Verdict, error) {
+	return f.verdict, nil
+}
+
+func TestVPNCheckerExplain(t *testing.T) {
+	const ip = "1.2.3.4"
+
+	tests := []struct {
+		name        string
+		allowed     bool
+		cached      bool
+		whitelisted bool
+		local       float64
+		online      fakeAnswer
+		noCache     bool
+		noWrite     bool
+
+		vpn    bool
+		reason string
+		source string
+		// whether the ip is detected by the apis and whitelisted after the check
+		detected       bool
+		whitelistAfter bool
+	}{
+		{
+			name:    "allowlist",
+			allowed: true,
+			online:  ipapiVPN,
+			source:  "allowlist",
+		},
+		{
+			// the allowlist is still honoured when caching the result
+			name:    "allowlist ignored without cache",
+			allowed: true,
+			online:  ipapiVPN,
+			noCache: true,
+			vpn:     true,
+			reason:  OnlineReason(CategoryVPN),
+			source:  "online",
+		},
+		{
+			name:    "allowlist ignored without cache and clean",
+			allowed: true,
+			online:  ipapiClean,
+			noCache: true,
+			source:  "online",
+		},
+		{
+			name:   "cache",
+			cached: true,
+			online: ipapiClean,
+			vpn:    true,
+			reason: "banned",
+			source: "cache",
+		},
+		{
+			name:   "local",
+			local:  1,
+			online: ipapiClean,
+			vpn:    true,
+			reason: LocalReason(CategoryVPN),
+			source: "local",
+		},
+		{
+			name:    "local without cache",
+			cached:  true,
+			local:   1,
+			online:  ipapiClean,
+			noCache: true,
+			vpn:     true,
+			reason:  LocalReason(CategoryVPN),
+			source:  "local",
+		},
+		{
+			name:           "whitelist",
+			whitelisted:    true,
+			online:         ipapiVPN,
+			source:         "whitelist",
+			whitelistAfter: true,
+		},
+		{
+			name:        "whitelist ignored without cache",
+			whitelisted: true,
+			online:      ipapiVPN,
+			noCache:     true,
+			vpn:         true,
+			reason:      OnlineReason(CategoryVPN),
+			source:      "online",
+			detected:    true,
+		},
+		{
+			name:           "online clean",
+			online:         ipapiClean,
+			source:         "online",
+			whitelistAfter: true,
+		},
+		{
+			name:    "online dry run",
+			online:  ipapiVPN,
+			noWrite: true,
+			vpn:     true,
+			reason:  OnlineReason(CategoryVPN),
+			source:  "online",
+		},
+	}
+
+	for _, tt := range tests {
+		// newChecker creates a checker with the state of the test case
+		newChecker := func(t *testing.T) (*VPNChecker, *Blacklist, Whitelister) {
+			ctx := context.Background()
+			rdb, options := newTestRedis(t)
+			bl := newTestBlacklist(t, rdb, options)
+			wl := NewRedisWhitelister(ctx, rdb, "test:whitelist:", time.Hour)
+
+			options.DB = 1
+			ripr, err := goripr.NewClient(ctx, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			al := NewAllowlist(ripr, rdb, "test:allow:")
+			t.Cleanup(func() { _ = al.Close() })
+
+			if tt.allowed {
+				err = al.Add(ctx, "1.2.3.0/24", "staff", SourceManual)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.cached {
+				err = bl.Insert(ctx, "1.2.3.0/24", "banned", SourceManual, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.whitelisted {
+				err = wl.Whitelist(ip)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			local := []VPN{fakeLocal{Verdict{Confidence: tt.local, Category: CategoryVPN}}}
+			apis := []VPN{NewIPAPI(http.DefaultClient, newFakeServer(t, tt.online), "", testLimiter())}
+			checker := NewVPNChecker(ctx, bl, al, 0, wl, 0, apis, local, nil, time.Second, 1, false, 0.6)
+			return checker, bl, wl
+		}
+
+		t.Run(tt.name, func(t *testing.T) {
+			checker, bl, wl := newChecker(t)
+			report, err := checker.Explain(ip, tt.noCache, tt.noWrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.VPN != tt.vpn || report.Reason != tt.reason || report.Source != tt.source {
+				t.Fatalf("expected vpn %t (%q) from %s, got %t (%q) from %s", tt.vpn, tt.reason, tt.source, report.VPN, report.Reason, report.Source)
+			}
+			if report.Allowed != tt.allowed || report.Cached != tt.cached || report.Whitelisted != tt.whitelisted {
+				t.Fatalf("expected reported states %t %t %t, got %t %t %t",
+					tt.allowed, tt.cached, tt.whitelisted, report.Allowed, report.Cached, report.Whitelisted)
+			}
+
+			info, found, err := bl.Info(context.Background(), ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detected := found && info.Source == SourceAPI; detected != tt.detected {
+				t.Fatalf("expected detected %t, got %t", tt.detected, detected)
+			}
+			whitelisted, err := wl.Exists(ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if whitelisted != tt.whitelistAfter {
+				t.Fatalf("expected whitelisted %t, got %t", tt.whitelistAfter, whitelisted)
+			}
+
+			if tt.noCache || tt.noWrite {
+				return
+			}
+
+			// the detector decides the same way
+			checker, _, _ = newChecker(t)
+			isVPN, reason, err := checker.IsVPN(ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isVPN != report.VPN || reason != report.Reason {
+				t.Fatalf("expected IsVPN to match the report %t (%q), got %t (%q)", report.VPN, report.Reason, isVPN, reason)
+			}
+		})
+	}
+}
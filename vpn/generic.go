@@ -0,0 +1,154 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var _ VPN = (*GenericAPI)(nil)
+
+// NewGenericAPI creates a new api that is purely defined by its configuration.
+// urlTemplate and the header values may contain the {ip} and {key} placeholders which are
+// replaced with the requested ip and the api key.
+// expression is evaluated on the json response and yields the confidence that the ip is a vpn,
+// see jsonExpression for its syntax. Detected ips are reported with the given category.
+func NewGenericAPI(
+	c *http.Client,
+	name string,
+	urlTemplate string,
+	apiKey string,
+	headers map[string]string,
+	expression string,
+	category Category,
+	limiter Limiter,
+) (*GenericAPI, error) {
+	expr, err := parseJSONExpression(expression)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	// validate the template with example values
+	_, err = url.Parse(expandTemplate(urlTemplate, "127.0.0.1", apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid url template: %w", name, err)
+	}
+
+	if category == CategoryNone {
+		category = CategoryVPN
+	}
+
+	return &GenericAPI{
+		client:      c,
+		name:        name,
+		urlTemplate: urlTemplate,
+		apiKey:      apiKey,
+		headers:     headers,
+		expr:        expr,
+		category:    category,
+		limiter:     limiter,
+	}, nil
+}
+
+// GenericAPI implements the VPN interface for arbitrary http apis that answer with json
+type GenericAPI struct {
+	client      *http.Client
+	name        string
+	urlTemplate string
+	apiKey      string
+	headers     map[string]string
+	expr        *jsonExpression
+	category    Category
+	limiter     Limiter
+	quota       suspender
+}
+
+// String implements the stringer interface
+func (g *GenericAPI) String() string {
+	return g.name
+}
+
+// expandTemplate replaces the {ip} and {key} placeholders with their url escaped values,
+// path escaped in the path and query escaped in the query of the url.
+func expandTemplate(template, IP, apiKey string) string {
+	path, query, hasQuery := strings.Cut(template, "?")
+	expanded := strings.NewReplacer(
+		"{ip}", url.PathEscape(IP),
+		"{key}", url.PathEscape(apiKey),
+	).Replace(path)
+	if !hasQuery {
+		return expanded
+	}
+	return expanded + "?" + strings.NewReplacer(
+		"{ip}", url.QueryEscape(IP),
+		"{key}", url.QueryEscape(apiKey),
+	).Replace(query)
+}
+
+// Fetch requests the ip information from the api endpoint and evaluates the expression on its response.
+func (g *GenericAPI) Fetch(ctx context.Context, IP string) (Verdict, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, expandTemplate(g.urlTemplate, IP, g.apiKey), http.NoBody)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to create request: %w", redactURL(err))
+	}
+
+	replacer := strings.NewReplacer("{ip}", IP, "{key}", g.apiKey)
+	for key, value := range g.headers {
+		request.Header.Set(key, replacer.Replace(value))
+	}
+
+	response, err := g.client.Do(request)
+	if err != nil {
+		return Verdict{}, redactURL(err)
+	}
+	defer response.Body.Close()
+
+	err = g.quota.CheckResponse(g.String(), response)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	// status
+	status := response.StatusCode
+	if status/100 != 2 {
+		return Verdict{}, fmt.Errorf("response code is not 200: %d", status)
+	}
+
+	// body
+	bytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("error while reading response body: %w", err)
+	}
+
+	confidence, err := g.expr.Eval(bytes, IP)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to evaluate response: %w", err)
+	}
+
+	verdict := Verdict{
+		Confidence: confidence,
+		Raw:        bytes,
+	}
+	if confidence > 0 {
+		verdict.Category = g.category
+	}
+	return verdict, nil
+}
+
+// IsVPN tests if a given IP is a VPN IP
+func (g *GenericAPI) IsVPN(ctx context.Context, IP string) (Verdict, error) {
+	err := g.quota.Check(g.String())
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	err = allow(ctx, g.limiter)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	return g.Fetch(ctx, IP)
+}
@@ -0,0 +1,111 @@
+package vpn
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		ip       string
+		key      string
+		want     string
+	}{
+		{
+			name:     "ipv4 in path",
+			template: "https://example.com/v2/{ip}",
+			ip:       "1.2.3.4",
+			want:     "https://example.com/v2/1.2.3.4",
+		},
+		{
+			name:     "ipv6 in path",
+			template: "https://example.com/v2/{ip}?vpn=1",
+			ip:       "2001:db8::1",
+			want:     "https://example.com/v2/2001:db8::1?vpn=1",
+		},
+		{
+			name:     "ipv6 in query",
+			template: "https://example.com/check?ip={ip}",
+			ip:       "2001:db8::1",
+			want:     "https://example.com/check?ip=2001%3Adb8%3A%3A1",
+		},
+		{
+			name:     "key in path and query",
+			template: "https://example.com/{key}/{ip}?key={key}",
+			ip:       "1.2.3.4",
+			key:      "a b/c+d",
+			want:     "https://example.com/a%20b%2Fc+d/1.2.3.4?key=a+b%2Fc%2Bd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expandTemplate(tt.template, tt.ip, tt.key)
+			if got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+			if _, err := url.Parse(got); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestGenericAPI(t *testing.T) {
+	newProvider := func(c *http.Client, baseURL *url.URL) VPN {
+		api, err := NewGenericAPI(
+			c,
+			"example.com",
+			baseURL.String()+"/v2/{ip}?key={key}",
+			"secret",
+			map[string]string{"X-Key": "{key}"},
+			`{ip}.proxy == "yes"`,
+			CategoryProxy,
+			testLimiter(),
+		)
+		if err != nil {
+			panic(err)
+		}
+		return api
+	}
+	checkRequest := func(t *testing.T, r *http.Request) {
+		if r.URL.Path != "/v2/1.2.3.4" || r.URL.Query().Get("key") != "secret" || r.Header.Get("X-Key") != "secret" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+	}
+
+	testProvider(t, newProvider, checkRequest, []providerTest{
+		{
+			name:       "proxy",
+			status:     http.StatusOK,
+			body:       `{"status":"ok","1.2.3.4":{"proxy":"yes"}}`,
+			confidence: 1,
+			category:   CategoryProxy,
+		},
+		{
+			name:   "clean",
+			status: http.StatusOK,
+			body:   `{"status":"ok","1.2.3.4":{"proxy":"no"}}`,
+		},
+		{
+			name:    "malformed json",
+			status:  http.StatusOK,
+			body:    `{"status":"ok",`,
+			wantErr: true,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+		{
+			name:    "too many requests",
+			status:  http.StatusTooManyRequests,
+			wantErr: true,
+			quota:   true,
+		},
+	})
+}
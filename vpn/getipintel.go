@@ -0,0 +1,131 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+var _ VPN = (*GetIPIntel)(nil)
+
+// GetIPIntelURL is the default base url of the getipintel api
+const GetIPIntelURL = "https://check.getipintel.net"
+
+// NewGetIPIntel creates a new api that can be checked for VPN IPs.
+// The api does not use api keys but requires a valid contact email address.
+// baseURL may be nil in order to use the default GetIPIntelURL.
+func NewGetIPIntel(c *http.Client, baseURL *url.URL, contact string, limiter Limiter) *GetIPIntel {
+	return &GetIPIntel{
+		client:  c,
+		baseURL: orDefaultURL(baseURL, GetIPIntelURL),
+		contact: contact,
+		limiter: limiter,
+	}
+}
+
+// GetIPIntel implements the VPN interface and checks whether a given IP is a vpn
+type GetIPIntel struct {
+	client  *http.Client
+	baseURL *url.URL
+	contact string
+	limiter Limiter
+	quota   suspender
+}
+
+// String implements the stringer interface
+func (*GetIPIntel) String() string {
+	return "getipintel.net"
+}
+
+type getIPIntelResponse struct {
+	Status  string `json:"status"`
+	Result  string `json:"result"`
+	Message string `json:"message"`
+}
+
+// Fetch requests the ip information from the api endpoint.
+// The result is the probability between 0 and 1 that the ip is a proxy or vpn.
+func (gi *GetIPIntel) Fetch(ctx context.Context, IP string) (Verdict, error) {
+	u := gi.baseURL.JoinPath("check.php")
+	u.RawQuery = url.Values{
+		"ip":      []string{IP},
+		"contact": []string{gi.contact},
+		"format":  []string{"json"},
+	}.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to create request: %w", redactURL(err))
+	}
+
+	response, err := gi.client.Do(request)
+	if err != nil {
+		return Verdict{}, redactURL(err)
+	}
+	defer response.Body.Close()
+
+	err = gi.quota.CheckResponse(gi.String(), response)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	// body
+	bytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("error while reading response body: %w", err)
+	}
+
+	data := getIPIntelResponse{}
+	err = json.Unmarshal(bytes, &data)
+	if err != nil {
+		// status
+		if status := response.StatusCode; status/100 != 2 {
+			return Verdict{}, fmt.Errorf("response code is not 200: %d", status)
+		}
+		return Verdict{}, err
+	}
+
+	// errors are reported with status codes 400 and the error in the message
+	if data.Status != "success" {
+		return Verdict{}, fmt.Errorf("response code %d: %w", response.StatusCode, errors.New(data.Message))
+	}
+
+	probability, err := strconv.ParseFloat(data.Result, 64)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("invalid result: %s: %w", data.Result, err)
+	}
+
+	// negative results are error codes
+	if probability < 0 || probability > 1 {
+		return Verdict{}, fmt.Errorf("invalid result: %s", data.Result)
+	}
+
+	verdict := Verdict{
+		Confidence: probability,
+		Raw:        bytes,
+	}
+	if probability > 0 {
+		verdict.Category = CategoryVPN
+	}
+	return verdict, nil
+}
+
+// IsVPN tests if a given IP is a VPN IP
+func (gi *GetIPIntel) IsVPN(ctx context.Context, IP string) (Verdict, error) {
+	err := gi.quota.Check(gi.String())
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	err = allow(ctx, gi.limiter)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	return gi.Fetch(ctx, IP)
+}
@@ -0,0 +1,65 @@
+package vpn
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestGetIPIntel(t *testing.T) {
+	newProvider := func(c *http.Client, baseURL *url.URL) VPN {
+		return NewGetIPIntel(c, baseURL, "admin@example.com", testLimiter())
+	}
+	checkRequest := func(t *testing.T, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/check.php" || query.Get("ip") != "1.2.3.4" || query.Get("contact") != "admin@example.com" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+	}
+
+	testProvider(t, newProvider, checkRequest, []providerTest{
+		{
+			name:       "vpn",
+			status:     http.StatusOK,
+			body:       `{"status":"success","result":"0.99"}`,
+			confidence: 0.99,
+			category:   CategoryVPN,
+		},
+		{
+			name:   "clean",
+			status: http.StatusOK,
+			body:   `{"status":"success","result":"0"}`,
+		},
+		{
+			name:    "error code",
+			status:  http.StatusOK,
+			body:    `{"status":"success","result":"-2"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid contact",
+			status:  http.StatusBadRequest,
+			body:    `{"status":"error","result":"-4","message":"Invalid contact information"}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed json",
+			status:  http.StatusOK,
+			body:    `{"status":"success","result":0.5}`,
+			wantErr: true,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			body:    `<html>internal server error</html>`,
+			wantErr: true,
+		},
+		{
+			name:    "too many requests",
+			status:  http.StatusTooManyRequests,
+			body:    `{"status":"error","result":"-5","message":"Too many queries"}`,
+			wantErr: true,
+			quota:   true,
+		},
+	})
+}
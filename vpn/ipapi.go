@@ -0,0 +1,154 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ VPN = (*IPAPI)(nil)
+
+const (
+	// IPAPIURL is the default base url of the free ip-api api, which does not support https
+	IPAPIURL = "http://ip-api.com"
+	// IPAPIProURL is the default base url of the ip-api api for api keys
+	IPAPIProURL = "https://pro.ip-api.com"
+)
+
+// NewIPAPI creates a new api that can be checked for VPN IPs.
+// baseURL may be nil in order to use the default IPAPIURL or IPAPIProURL in case an api key is provided.
+func NewIPAPI(c *http.Client, baseURL *url.URL, apiKey string, limiter Limiter) *IPAPI {
+	defaultURL := IPAPIURL
+	if apiKey != "" {
+		defaultURL = IPAPIProURL
+	}
+	return &IPAPI{
+		client:  c,
+		baseURL: orDefaultURL(baseURL, defaultURL),
+		apiKey:  apiKey,
+		limiter: limiter,
+	}
+}
+
+// IPAPI implements the VPN interface and checks whether a given IP is a vpn
+type IPAPI struct {
+	client  *http.Client
+	baseURL *url.URL
+	apiKey  string
+	limiter Limiter
+	quota   suspender
+}
+
+// String implements the stringer interface
+func (*IPAPI) String() string {
+	return "ip-api.com"
+}
+
+type ipAPIResponse struct {
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	CountryCode string `json:"countryCode"`
+	ISP         string `json:"isp"`
+	AS          string `json:"as"` // e.g. AS24940 Hetzner Online GmbH
+	Proxy       bool   `json:"proxy"`
+	Hosting     bool   `json:"hosting"`
+}
+
+// Fetch requests the ip information from the api endpoint.
+// proxy covers proxies, VPNs and TOR exit nodes, hosting covers data centers.
+func (ia *IPAPI) Fetch(ctx context.Context, IP string) (Verdict, error) {
+	query := url.Values{
+		"fields": []string{"status,message,countryCode,isp,as,proxy,hosting"},
+	}
+	if ia.apiKey != "" {
+		query.Set("key", ia.apiKey)
+	}
+	u := ia.baseURL.JoinPath("json", IP)
+	u.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to create request: %w", redactURL(err))
+	}
+
+	response, err := ia.client.Do(request)
+	if err != nil {
+		return Verdict{}, redactURL(err)
+	}
+	defer response.Body.Close()
+
+	err = ia.quota.CheckResponse(ia.String(), response)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	// ip-api reports the remaining requests in X-Rl and the seconds until the reset in X-Ttl
+	if remaining := response.Header.Get("X-Rl"); remaining == "0" {
+		ttl, err := strconv.Atoi(response.Header.Get("X-Ttl"))
+		if err == nil && ttl > 0 {
+			_ = ia.quota.Suspend(ia.String(), time.Now().Add(time.Duration(ttl)*time.Second))
+		}
+	}
+
+	// status
+	status := response.StatusCode
+	if status/100 != 2 {
+		return Verdict{}, fmt.Errorf("response code is not 200: %d", status)
+	}
+
+	// body
+	bytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("error while reading response body: %w", err)
+	}
+
+	data := ipAPIResponse{}
+	err = json.Unmarshal(bytes, &data)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	if data.Status != "success" {
+		return Verdict{}, errors.New(data.Message)
+	}
+
+	asn, _, _ := strings.Cut(data.AS, " ")
+	verdict := Verdict{
+		ASN:     parseASN(asn),
+		ISP:     data.ISP,
+		Country: data.CountryCode,
+		Raw:     bytes,
+	}
+
+	switch {
+	case data.Proxy:
+		verdict.Confidence = 1
+		verdict.Category = CategoryVPN
+	case data.Hosting:
+		verdict.Confidence = 0.5
+		verdict.Category = CategoryHosting
+	}
+	return verdict, nil
+}
+
+// IsVPN tests if a given IP is a VPN IP
+func (ia *IPAPI) IsVPN(ctx context.Context, IP string) (Verdict, error) {
+	err := ia.quota.Check(ia.String())
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	err = allow(ctx, ia.limiter)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	return ia.Fetch(ctx, IP)
+}
@@ -0,0 +1,77 @@
+package vpn
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestIPAPI(t *testing.T) {
+	newProvider := func(c *http.Client, baseURL *url.URL) VPN {
+		return NewIPAPI(c, baseURL, "secret", testLimiter())
+	}
+	checkRequest := func(t *testing.T, r *http.Request) {
+		if r.URL.Path != "/json/1.2.3.4" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if key := r.URL.Query().Get("key"); key != "secret" {
+			t.Errorf("unexpected key: %s", key)
+		}
+	}
+
+	testProvider(t, newProvider, checkRequest, []providerTest{
+		{
+			name:       "proxy",
+			status:     http.StatusOK,
+			body:       `{"status":"success","countryCode":"NL","isp":"M247","as":"AS9009 M247 Europe SRL","proxy":true,"hosting":true}`,
+			confidence: 1,
+			category:   CategoryVPN,
+		},
+		{
+			name:       "hosting",
+			status:     http.StatusOK,
+			body:       `{"status":"success","as":"AS24940 Hetzner Online GmbH","proxy":false,"hosting":true}`,
+			confidence: 0.5,
+			category:   CategoryHosting,
+		},
+		{
+			name:   "clean",
+			status: http.StatusOK,
+			body:   `{"status":"success","countryCode":"DE","isp":"Deutsche Telekom AG","as":"AS3320 Deutsche Telekom AG"}`,
+		},
+		{
+			name:    "failed lookup",
+			status:  http.StatusOK,
+			body:    `{"status":"fail","message":"reserved range"}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed json",
+			status:  http.StatusOK,
+			body:    `{"status":`,
+			wantErr: true,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			body:    `internal server error`,
+			wantErr: true,
+		},
+		{
+			name:    "too many requests",
+			status:  http.StatusTooManyRequests,
+			header:  map[string]string{"X-Rl": "0", "X-Ttl": "60"},
+			wantErr: true,
+			quota:   true,
+		},
+		{
+			name:       "last request",
+			status:     http.StatusOK,
+			header:     map[string]string{"X-Rl": "0", "X-Ttl": "60"},
+			body:       `{"status":"success","proxy":true}`,
+			confidence: 1,
+			category:   CategoryVPN,
+			quota:      true,
+		},
+	})
+}
@@ -1,21 +1,26 @@
 package vpn
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"time"
 )
 
 var _ VPN = (*IPHub)(nil)
 
-// NewIPHub reates a new api that can be checked for VPN IPs
-func NewIPHub(c *http.Client, apikey string) *IPHub {
+// IPHubURL is the default base url of the iphub api
+const IPHubURL = "https://v2.api.iphub.info"
+
+// NewIPHub reates a new api that can be checked for VPN IPs.
+// baseURL may be nil in order to use the default IPHubURL.
+func NewIPHub(c *http.Client, baseURL *url.URL, apikey string, limiter Limiter) *IPHub {
 	return &IPHub{
 		client:  c,
-		limiter: NewRateLimiter(24*time.Hour, 1000),
+		baseURL: orDefaultURL(baseURL, IPHubURL),
+		limiter: limiter,
 		headers: http.Header{
 			"X-Key": []string{apikey},
 		},
@@ -25,7 +30,9 @@ func NewIPHub(c *http.Client, apikey string) *IPHub {
 // IPHub implemets the VPNApi interface and checks whether a given IP is a vpn
 type IPHub struct {
 	client  *http.Client
-	limiter *RateLimiter
+	baseURL *url.URL
+	limiter Limiter
+	quota   suspender
 	headers http.Header
 }
 
@@ -45,63 +52,80 @@ type iPHubResponseData struct {
 	// Hostname    string `json:"hostname"` // deprecated
 }
 
-// Fetch :
-func (ih *IPHub) Fetch(IP string) (block int, err error) {
+// Fetch requests the ip information from the api endpoint.
+// block 1 is a non-residential ip (hosting provider, proxy, etc.),
+// block 2 is a non-residential & residential ip which may flag innocent people.
+func (ih *IPHub) Fetch(ctx context.Context, IP string) (Verdict, error) {
 
 	// for https we need to reuse an existing https connection in order not to
 	// stress the api endpoint with too many tls handshakes
 	// default client reuses tls connections
-	u := url.URL{
-		Scheme: "https",
-		Host:   "v2.api.iphub.info",
-		Path:   "/ip/" + IP,
-	}
+	u := ih.baseURL.JoinPath("ip", IP)
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), http.NoBody)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
 	if err != nil {
-		return 0, fmt.Errorf("error while creating request: %w", err)
+		return Verdict{}, fmt.Errorf("error while creating request: %w", redactURL(err))
 	}
 	req.Header = ih.headers
 	response, err := ih.client.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("error while fetching IPHub: %w", err)
+		return Verdict{}, fmt.Errorf("error while fetching IPHub: %w", redactURL(err))
 	}
 	defer response.Body.Close()
 
+	err = ih.quota.CheckResponse(ih.String(), response)
+	if err != nil {
+		return Verdict{}, err
+	}
+
 	// status
 	status := response.StatusCode
 	if status/100 != 2 {
-		return 0, fmt.Errorf("response code is not 200: %d, check your IPHub token in .env, IPHUB_TOKEN=", status)
+		return Verdict{}, fmt.Errorf("response code is not 200: %d, check your IPHub token in .env, IPHUB_TOKEN=", status)
 	}
 
 	// body
 	bytes, err := io.ReadAll(response.Body)
 	if err != nil {
-		return 0, fmt.Errorf("error while reading response body: %w", err)
+		return Verdict{}, fmt.Errorf("error while reading response body: %w", err)
 	}
 
 	data := iPHubResponseData{}
 	err = json.Unmarshal(bytes, &data)
 	if err != nil {
-		return 0, err
+		return Verdict{}, err
 	}
 
-	return data.Block, nil
+	verdict := Verdict{
+		ASN:     data.Asn,
+		ISP:     data.Isp,
+		Country: data.CountryCode,
+		Raw:     bytes,
+	}
 
+	switch data.Block {
+	case 1:
+		verdict.Confidence = 1
+		verdict.Category = CategoryHosting
+	case 2:
+		verdict.Confidence = 0.5
+		verdict.Category = CategoryHosting
+	}
+	return verdict, nil
 }
 
 // IsVPN tests if a given IP is a VPN IP
-func (ih *IPHub) IsVPN(IP string) (bool, error) {
-	if !ih.limiter.Allow() {
-		return false, ErrRateLimitReached
+func (ih *IPHub) IsVPN(ctx context.Context, IP string) (Verdict, error) {
+	err := ih.quota.Check(ih.String())
+	if err != nil {
+		return Verdict{}, err
 	}
 
-	// https://iphub.info/api
-	block, err := ih.Fetch(IP)
-
+	err = allow(ctx, ih.limiter)
 	if err != nil {
-		return false, err
+		return Verdict{}, err
 	}
 
-	return block == 1, nil
+	// https://iphub.info/api
+	return ih.Fetch(ctx, IP)
 }
@@ -0,0 +1,147 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var _ VPN = (*IPQualityScore)(nil)
+
+// IPQualityScoreURL is the default base url of the ipqualityscore api
+const IPQualityScoreURL = "https://ipqualityscore.com"
+
+// NewIPQualityScore creates a new api that can be checked for VPN IPs.
+// baseURL may be nil in order to use the default IPQualityScoreURL.
+func NewIPQualityScore(c *http.Client, baseURL *url.URL, apiKey string, limiter Limiter) *IPQualityScore {
+	return &IPQualityScore{
+		client:  c,
+		baseURL: orDefaultURL(baseURL, IPQualityScoreURL),
+		apiKey:  apiKey,
+		limiter: limiter,
+	}
+}
+
+// IPQualityScore implements the VPN interface and checks whether a given IP is a vpn
+type IPQualityScore struct {
+	client  *http.Client
+	baseURL *url.URL
+	apiKey  string
+	limiter Limiter
+	quota   suspender
+}
+
+// String implements the stringer interface
+func (*IPQualityScore) String() string {
+	return "ipqualityscore.com"
+}
+
+type ipQualityScoreResponse struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	FraudScore  int    `json:"fraud_score"`
+	CountryCode string `json:"country_code"`
+	ISP         string `json:"ISP"`
+	ASN         int    `json:"ASN"`
+	Proxy       bool   `json:"proxy"`
+	VPN         bool   `json:"vpn"`
+	Tor         bool   `json:"tor"`
+	ActiveVPN   bool   `json:"active_vpn"`
+	ActiveTor   bool   `json:"active_tor"`
+}
+
+// Fetch requests the ip information from the api endpoint.
+// Actively used VPNs and TOR exit nodes are certain detections, the proxy flag also covers
+// VPNs that were used in the past or that are only suspected.
+func (iq *IPQualityScore) Fetch(ctx context.Context, IP string) (Verdict, error) {
+	u := iq.baseURL.JoinPath("api/json/ip", iq.apiKey, IP)
+	u.RawQuery = url.Values{
+		"strictness": []string{"0"},
+	}.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to create request: %w", redactURL(err))
+	}
+
+	response, err := iq.client.Do(request)
+	if err != nil {
+		return Verdict{}, redactURL(err)
+	}
+	defer response.Body.Close()
+
+	err = iq.quota.CheckResponse(iq.String(), response)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	// status
+	status := response.StatusCode
+	if status/100 != 2 {
+		return Verdict{}, fmt.Errorf("response code is not 200: %d", status)
+	}
+
+	// body
+	bytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("error while reading response body: %w", err)
+	}
+
+	data := ipQualityScoreResponse{}
+	err = json.Unmarshal(bytes, &data)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	if !data.Success {
+		// errors are reported with status code 200
+		if strings.Contains(strings.ToLower(data.Message), "quota") {
+			return Verdict{}, iq.quota.Suspend(iq.String(), time.Now().Add(defaultSuspension))
+		}
+		return Verdict{}, errors.New(data.Message)
+	}
+
+	verdict := Verdict{
+		ASN:     data.ASN,
+		ISP:     data.ISP,
+		Country: data.CountryCode,
+		Raw:     bytes,
+	}
+
+	switch {
+	case data.Tor, data.ActiveTor:
+		verdict.Confidence = 1
+		verdict.Category = CategoryTor
+	case data.ActiveVPN:
+		verdict.Confidence = 1
+		verdict.Category = CategoryVPN
+	case data.VPN:
+		verdict.Confidence = 0.75
+		verdict.Category = CategoryVPN
+	case data.Proxy:
+		verdict.Confidence = 0.75
+		verdict.Category = CategoryProxy
+	}
+	return verdict, nil
+}
+
+// IsVPN tests if a given IP is a VPN IP
+func (iq *IPQualityScore) IsVPN(ctx context.Context, IP string) (Verdict, error) {
+	err := iq.quota.Check(iq.String())
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	err = allow(ctx, iq.limiter)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	return iq.Fetch(ctx, IP)
+}
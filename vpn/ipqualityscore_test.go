@@ -0,0 +1,107 @@
+package vpn
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIPQualityScore(t *testing.T) {
+	newProvider := func(c *http.Client, baseURL *url.URL) VPN {
+		return NewIPQualityScore(c, baseURL, "secret", testLimiter())
+	}
+	checkRequest := func(t *testing.T, r *http.Request) {
+		if r.URL.Path != "/api/json/ip/secret/1.2.3.4" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+	}
+
+	testProvider(t, newProvider, checkRequest, []providerTest{
+		{
+			name:       "active vpn",
+			status:     http.StatusOK,
+			body:       `{"success":true,"fraud_score":100,"country_code":"NL","ISP":"M247","ASN":9009,"proxy":true,"vpn":true,"active_vpn":true}`,
+			confidence: 1,
+			category:   CategoryVPN,
+		},
+		{
+			name:       "tor",
+			status:     http.StatusOK,
+			body:       `{"success":true,"proxy":true,"tor":true}`,
+			confidence: 1,
+			category:   CategoryTor,
+		},
+		{
+			name:       "past vpn",
+			status:     http.StatusOK,
+			body:       `{"success":true,"proxy":true,"vpn":true}`,
+			confidence: 0.75,
+			category:   CategoryVPN,
+		},
+		{
+			name:       "proxy",
+			status:     http.StatusOK,
+			body:       `{"success":true,"proxy":true}`,
+			confidence: 0.75,
+			category:   CategoryProxy,
+		},
+		{
+			name:   "clean",
+			status: http.StatusOK,
+			body:   `{"success":true,"fraud_score":0,"country_code":"DE","ISP":"Deutsche Telekom","ASN":3320}`,
+		},
+		{
+			name:    "invalid key",
+			status:  http.StatusOK,
+			body:    `{"success":false,"message":"Invalid or unauthorized key."}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed json",
+			status:  http.StatusOK,
+			body:    `{"success":tru`,
+			wantErr: true,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusBadGateway,
+			wantErr: true,
+		},
+		{
+			name:    "too many requests",
+			status:  http.StatusTooManyRequests,
+			header:  map[string]string{"Retry-After": "3600"},
+			wantErr: true,
+			quota:   true,
+		},
+		{
+			name:    "quota exceeded",
+			status:  http.StatusOK,
+			body:    `{"success":false,"message":"You have exceeded your request quota of 5000 per month."}`,
+			wantErr: true,
+			quota:   true,
+		},
+	})
+}
+
+func TestIPQualityScoreErrorsDoNotContainKey(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	baseURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// connection refused
+	srv.Close()
+
+	api := NewIPQualityScore(http.DefaultClient, baseURL, "secret", testLimiter())
+	_, err = api.IsVPN(context.Background(), "1.2.3.4")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("error contains the api key: %v", err)
+	}
+}
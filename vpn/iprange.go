@@ -0,0 +1,216 @@
+package vpn
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// ParseRange parses a single ipv4/ipv6 address, a CIDR range or an address range
+// of the form from-to and returns its first and last address.
+func ParseRange(ipRange string) (first, last netip.Addr, err error) {
+	ipRange = strings.TrimSpace(ipRange)
+
+	if strings.Contains(ipRange, "/") {
+		prefix, err := netip.ParsePrefix(ipRange)
+		if err != nil {
+			return netip.Addr{}, netip.Addr{}, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefix = prefix.Masked()
+		return prefix.Addr(), lastAddr(prefix), nil
+	}
+
+	from, to, isRange := strings.Cut(ipRange, "-")
+	first, err = netip.ParseAddr(strings.TrimSpace(from))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	first = first.Unmap().WithZone("")
+	if !isRange {
+		return first, first, nil
+	}
+
+	last, err = netip.ParseAddr(strings.TrimSpace(to))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	last = last.Unmap().WithZone("")
+	if first.Is4() != last.Is4() {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid ip range, mixed ipv4 and ipv6 boundaries: %s", ipRange)
+	}
+	if last.Less(first) {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid ip range, lower boundary is bigger than the upper boundary: %s", ipRange)
+	}
+	return first, last, nil
+}
+
+// RangePrefixes splits the ip range into the smallest set of CIDR prefixes that cover it exactly.
+func RangePrefixes(ipRange string) ([]netip.Prefix, error) {
+	first, last, err := ParseRange(ipRange)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []netip.Prefix
+	for {
+		// biggest prefix that starts at first and does not exceed last
+		prefix := netip.PrefixFrom(first, first.BitLen())
+		for bits := 0; bits < first.BitLen(); bits++ {
+			p := netip.PrefixFrom(first, bits)
+			if p.Masked().Addr() == first && !last.Less(lastAddr(p)) {
+				prefix = p
+				break
+			}
+		}
+		prefixes = append(prefixes, prefix)
+
+		end := lastAddr(prefix)
+		if end == last {
+			return prefixes, nil
+		}
+		first = end.Next()
+	}
+}
+
+// prefixKey is the key of a prefix in the whitelist, single addresses are stored without their prefix length.
+func prefixKey(p netip.Prefix) string {
+	if p.IsSingleIP() {
+		return p.Addr().String()
+	}
+	return p.Masked().String()
+}
+
+// containingKeys returns the keys of all prefixes that contain the ip, from the most specific to the least specific.
+func containingKeys(ip netip.Addr) []string {
+	keys := make([]string, 0, ip.BitLen()+1)
+	for bits := ip.BitLen(); bits >= 0; bits-- {
+		keys = append(keys, prefixKey(netip.PrefixFrom(ip, bits)))
+	}
+	return keys
+}
+
+// parseKey parses a whitelist key, which is either a single address or a CIDR prefix
+func parseKey(key string) (netip.Prefix, error) {
+	if strings.Contains(key, "/") {
+		return netip.ParsePrefix(key)
+	}
+	addr, err := netip.ParseAddr(key)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// interval is an inclusive range of addresses
+type interval struct {
+	first netip.Addr
+	last  netip.Addr
+}
+
+// intervals is a sorted list of non-overlapping intervals
+type intervals []interval
+
+// newIntervals parses the ip ranges and merges overlapping ones.
+func newIntervals(ipRanges []string) (intervals, error) {
+	result := make(intervals, 0, len(ipRanges))
+	for _, ipRange := range ipRanges {
+		first, last, err := ParseRange(ipRange)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, interval{first, last})
+	}
+	return mergeIntervals(result), nil
+}
+
+// mergeIntervals sorts the intervals and merges overlapping ones.
+func mergeIntervals(result []interval) intervals {
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].first.Less(result[j].first)
+	})
+
+	merged := result[:0]
+	for _, iv := range result {
+		if n := len(merged); n > 0 && !merged[n-1].last.Less(iv.first) {
+			if merged[n-1].last.Less(iv.last) {
+				merged[n-1].last = iv.last
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// overlaps returns whether the prefix shares at least one address with the intervals
+func (ivs intervals) overlaps(p netip.Prefix) bool {
+	p = p.Masked()
+	return ivs.overlapsInterval(interval{p.Addr(), lastAddr(p)})
+}
+
+// overlapsInterval returns whether the interval shares at least one address with the intervals
+func (ivs intervals) overlapsInterval(iv interval) bool {
+	// first interval that does not end before the given one
+	idx := sort.Search(len(ivs), func(i int) bool {
+		return !ivs[i].last.Less(iv.first)
+	})
+	return idx < len(ivs) && !iv.last.Less(ivs[idx].first)
+}
+
+// subtract returns the parts of the interval that are not covered by the intervals
+func (ivs intervals) subtract(iv interval) []interval {
+	var parts []interval
+	next := iv.first
+	for _, cut := range ivs {
+		if cut.last.Less(next) {
+			continue
+		}
+		if iv.last.Less(cut.first) {
+			break
+		}
+		if next.Less(cut.first) {
+			parts = append(parts, interval{next, cut.first.Prev()})
+		}
+		if !cut.last.Less(iv.last) {
+			return parts
+		}
+		next = cut.last.Next()
+	}
+	return append(parts, interval{next, iv.last})
+}
+
+// formatInterval returns the interval as a single address, a CIDR prefix or an address range of the form from-to
+func formatInterval(iv interval) string {
+	if iv.first == iv.last {
+		return iv.first.String()
+	}
+	for bits := 0; bits < iv.first.BitLen(); bits++ {
+		p := netip.PrefixFrom(iv.first, bits)
+		if p.Masked().Addr() == iv.first && lastAddr(p) == iv.last {
+			return p.String()
+		}
+	}
+	return iv.first.String() + "-" + iv.last.String()
+}
+
+// lastAddr returns the last address of the prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	p = p.Masked()
+	if p.Addr().Is4() {
+		a := p.Addr().As4()
+		for i := p.Bits(); i < 32; i++ {
+			a[i/8] |= 1 << (7 - i%8)
+		}
+		return netip.AddrFrom4(a)
+	}
+
+	a := p.Addr().As16()
+	for i := p.Bits(); i < 128; i++ {
+		a[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom16(a)
+}
@@ -0,0 +1,272 @@
+package vpn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ordered by length in order to match >= before >
+	jsonOperators    = []string{"==", "!=", ">=", "<=", ">", "<"}
+	jsonIndexRegex   = regexp.MustCompile(`\[(\d+)\]`)
+	errJSONNoOperand = errors.New("missing operand")
+)
+
+// jsonExpression is a gjson-like path expression that is evaluated on json documents, e.g.
+//
+//	security.vpn
+//	$.data.abuseConfidenceScore >= 50
+//	{ip}.proxy == "yes"
+//	results[0].type != "residential"
+//
+// Path segments are separated by dots, literal dots can be escaped with a backslash.
+// The {ip} placeholder is replaced with the requested ip.
+// The optional operand on the right side of the operator is a json value.
+type jsonExpression struct {
+	path     []string
+	operator string
+	operand  any
+}
+
+// parseJSONExpression parses a path expression with an optional comparison.
+func parseJSONExpression(expr string) (*jsonExpression, error) {
+	pathExpr, operator, operandExpr := splitJSONExpression(expr)
+
+	pathExpr = strings.TrimSpace(pathExpr)
+	pathExpr = strings.TrimPrefix(pathExpr, "$")
+	pathExpr = strings.TrimPrefix(pathExpr, ".")
+	pathExpr = jsonIndexRegex.ReplaceAllString(pathExpr, ".$1")
+	if pathExpr == "" {
+		return nil, fmt.Errorf("invalid expression: %q: empty path", expr)
+	}
+
+	path := splitJSONPath(pathExpr)
+	for _, segment := range path {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid expression: %q: empty path segment", expr)
+		}
+	}
+
+	e := &jsonExpression{
+		path:     path,
+		operator: operator,
+	}
+	if operator == "" {
+		return e, nil
+	}
+
+	operandExpr = strings.TrimSpace(operandExpr)
+	if operandExpr == "" {
+		return nil, fmt.Errorf("invalid expression: %q: %w", expr, errJSONNoOperand)
+	}
+
+	err := json.Unmarshal([]byte(operandExpr), &e.operand)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %q: operand must be a json value: %w", expr, err)
+	}
+
+	switch e.operand.(type) {
+	case float64, string:
+	default:
+		if operator != "==" && operator != "!=" {
+			return nil, fmt.Errorf("invalid expression: %q: %s requires a number or a string", expr, operator)
+		}
+	}
+	return e, nil
+}
+
+// splitJSONExpression splits the expression at the first operator outside of quotes.
+func splitJSONExpression(expr string) (path, operator, operand string) {
+	inQuotes := false
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '\\':
+			i++
+			continue
+		case '"':
+			inQuotes = !inQuotes
+			continue
+		}
+		if inQuotes {
+			continue
+		}
+		for _, op := range jsonOperators {
+			if strings.HasPrefix(expr[i:], op) {
+				return expr[:i], op, expr[i+len(op):]
+			}
+		}
+	}
+	return expr, "", ""
+}
+
+// splitJSONPath splits the path at every unescaped dot
+func splitJSONPath(path string) []string {
+	var (
+		segments = make([]string, 0, strings.Count(path, ".")+1)
+		segment  strings.Builder
+	)
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path):
+			i++
+			segment.WriteByte(path[i])
+		case path[i] == '.':
+			segments = append(segments, segment.String())
+			segment.Reset()
+		default:
+			segment.WriteByte(path[i])
+		}
+	}
+	return append(segments, segment.String())
+}
+
+// Eval evaluates the expression on the json document and returns the confidence between 0 and 1.
+// Comparisons and booleans result in either 0 or 1, numbers without comparison are used as
+// confidence directly. Any other value results in 1 in case it is neither empty nor null.
+func (e *jsonExpression) Eval(document []byte, IP string) (float64, error) {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+
+	var data any
+	err := decoder.Decode(&data)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := e.lookup(data, IP)
+	if err != nil {
+		return 0, err
+	}
+
+	if e.operator == "" {
+		return confidenceOf(value), nil
+	}
+
+	ok, err := e.compare(value)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// lookup returns the value at the path, missing values are returned as nil (json null).
+func (e *jsonExpression) lookup(data any, IP string) (any, error) {
+	current := data
+	for _, segment := range e.path {
+		segment = strings.ReplaceAll(segment, "{ip}", IP)
+
+		switch node := current.(type) {
+		case map[string]any:
+			current = node[segment]
+		case []any:
+			idx, err := strconv.Atoi(segment)
+			if err != nil {
+				return nil, fmt.Errorf("invalid array index: %s", segment)
+			}
+			if idx < 0 || idx >= len(node) {
+				return nil, nil
+			}
+			current = node[idx]
+		default:
+			return nil, nil
+		}
+	}
+
+	if number, ok := current.(json.Number); ok {
+		f, err := number.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	return current, nil
+}
+
+func (e *jsonExpression) compare(value any) (bool, error) {
+	switch e.operator {
+	case "==":
+		return equalJSON(value, e.operand), nil
+	case "!=":
+		return !equalJSON(value, e.operand), nil
+	}
+
+	var cmp int
+	switch operand := e.operand.(type) {
+	case float64:
+		v, ok := value.(float64)
+		if !ok {
+			return false, fmt.Errorf("cannot compare %v with number %v", value, operand)
+		}
+		switch {
+		case v < operand:
+			cmp = -1
+		case v > operand:
+			cmp = 1
+		}
+	case string:
+		v, ok := value.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot compare %v with string %q", value, operand)
+		}
+		cmp = strings.Compare(v, operand)
+	}
+
+	switch e.operator {
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	default:
+		return cmp <= 0, nil
+	}
+}
+
+// equalJSON compares scalar json values, arrays and objects are never equal
+func equalJSON(a, b any) bool {
+	switch a.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	switch b.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	return a == b
+}
+
+func confidenceOf(value any) float64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case float64:
+		return min(max(v, 0), 1)
+	case string:
+		if v == "" {
+			return 0
+		}
+	case []any:
+		if len(v) == 0 {
+			return 0
+		}
+	case map[string]any:
+		if len(v) == 0 {
+			return 0
+		}
+	}
+	return 1
+}
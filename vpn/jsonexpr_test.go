@@ -0,0 +1,132 @@
+package vpn
+
+import (
+	"testing"
+)
+
+func TestParseJSONExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "empty", expr: ""},
+		{name: "root only", expr: "$."},
+		{name: "empty segment", expr: "security..vpn"},
+		{name: "trailing dot", expr: "security."},
+		{name: "missing operand", expr: "score >= "},
+		{name: "invalid operand", expr: "type == residential"},
+		{name: "ordered comparison with bool", expr: "score > true"},
+		{name: "ordered comparison with null", expr: "score <= null"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseJSONExpression(tt.expr)
+			if err == nil {
+				t.Fatalf("expected an error, got %+v", e)
+			}
+		})
+	}
+}
+
+func TestJSONExpressionEval(t *testing.T) {
+	const document = `{
+		"security": {"vpn": true, "proxy": false, "tor": null},
+		"data": {"abuseConfidenceScore": 75, "usageType": "Data Center", "empty": "", "list": [], "object": {}},
+		"results": [{"type": "hosting", "score": 0.42}, {"type": "residential", "score": 1.5}],
+		"1.2.3.4": {"proxy": "yes", "risk": -3},
+		"dotted.key": 1,
+		"matrix": [[0, 1], [1, 0]]
+	}`
+
+	tests := []struct {
+		name    string
+		expr    string
+		want    float64
+		wantErr bool
+	}{
+		// paths
+		{name: "nested bool", expr: "security.vpn", want: 1},
+		{name: "nested false", expr: "security.proxy", want: 0},
+		{name: "nested null", expr: "security.tor", want: 0},
+		{name: "root prefix", expr: "$.security.vpn", want: 1},
+		{name: "number as confidence", expr: "results[0].score", want: 0.42},
+		{name: "number clamped to 1", expr: "results[1].score", want: 1},
+		{name: "number clamped to 0", expr: "{ip}.risk", want: 0},
+		{name: "ip placeholder", expr: "{ip}.proxy", want: 1},
+		{name: "escaped dot", expr: `dotted\.key`, want: 1},
+		{name: "nested array index", expr: "matrix[1][0]", want: 1},
+		{name: "array index as segment", expr: "results.1.type", want: 1},
+		{name: "non empty string", expr: "data.usageType", want: 1},
+		{name: "empty string", expr: "data.empty", want: 0},
+		{name: "empty array", expr: "data.list", want: 0},
+		{name: "empty object", expr: "data.object", want: 0},
+		{name: "non empty object", expr: "security", want: 1},
+
+		// missing fields
+		{name: "missing field", expr: "security.relay", want: 0},
+		{name: "missing parent", expr: "network.asn", want: 0},
+		{name: "index out of range", expr: "results[5].type", want: 0},
+		{name: "index of object", expr: "security[0]", want: 0},
+		{name: "field of scalar", expr: "security.vpn.value", want: 0},
+		{name: "missing field equals null", expr: "security.relay == null", want: 1},
+		{name: "missing field not equal", expr: "security.relay != \"yes\"", want: 1},
+		{name: "invalid array index", expr: "results.first.type", wantErr: true},
+
+		// operators
+		{name: "== string", expr: `{ip}.proxy == "yes"`, want: 1},
+		{name: "== string mismatch", expr: `{ip}.proxy == "no"`, want: 0},
+		{name: "== bool", expr: "security.vpn == true", want: 1},
+		{name: "== number", expr: "data.abuseConfidenceScore == 75", want: 1},
+		{name: "!= string", expr: `results[1].type != "residential"`, want: 0},
+		{name: "!= string mismatch", expr: `results[0].type != "residential"`, want: 1},
+		{name: "> number", expr: "data.abuseConfidenceScore > 50", want: 1},
+		{name: "> equal number", expr: "data.abuseConfidenceScore > 75", want: 0},
+		{name: ">= number", expr: "$.data.abuseConfidenceScore >= 75", want: 1},
+		{name: ">= smaller number", expr: "data.abuseConfidenceScore >= 76", want: 0},
+		{name: "< number", expr: "results[0].score < 0.5", want: 1},
+		{name: "< bigger number", expr: "results[1].score < 0.5", want: 0},
+		{name: "<= number", expr: "results[0].score <= 0.42", want: 1},
+		{name: "<= smaller number", expr: "results[0].score <= 0.4", want: 0},
+		{name: "> string", expr: `results[1].type > "hosting"`, want: 1},
+		{name: "< string", expr: `results[1].type < "hosting"`, want: 0},
+		{name: "operator inside quotes", expr: `data.usageType == "a>=b"`, want: 0},
+		{name: "without spaces", expr: "data.abuseConfidenceScore>=50", want: 1},
+
+		// type mismatches
+		{name: "== number with string", expr: `data.abuseConfidenceScore == "75"`, want: 0},
+		{name: "== object", expr: "security == null", want: 0},
+		{name: "!= array", expr: "data.list != null", want: 1},
+		{name: "> string with number", expr: "data.usageType > 5", wantErr: true},
+		{name: "< number with string", expr: `data.abuseConfidenceScore < "a"`, wantErr: true},
+		{name: ">= missing field", expr: "security.relay >= 1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseJSONExpression(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := e.Eval([]byte(document), "1.2.3.4")
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestJSONExpressionEvalInvalidDocument(t *testing.T) {
+	e, err := parseJSONExpression("security.vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.Eval([]byte(`{"security":`), "1.2.3.4")
+	if err == nil {
+		t.Fatal("expected an error for malformed json")
+	}
+}
@@ -0,0 +1,147 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	_ Limiter = (*RateLimiter)(nil)
+	_ Limiter = (*RedisRateLimiter)(nil)
+	_ Limiter = (MultiLimiter)(nil)
+)
+
+// Limiter limits the requests to an api endpoint.
+// Allow returns true in case a request is allowed to be made.
+// Remaining returns the number of requests that are currently allowed without taking a token.
+type Limiter interface {
+	Allow(ctx context.Context) (bool, error)
+	Remaining(ctx context.Context) (int, error)
+}
+
+// NewLimiterFunc creates the rate limiter of the api with the given name
+// that allows for limit requests per window.
+type NewLimiterFunc func(name string, window time.Duration, limit int) (Limiter, error)
+
+// allow asks the limiter whether a request can be made and returns ErrRateLimitReached if not.
+func allow(ctx context.Context, limiter Limiter) error {
+	ok, err := limiter.Allow(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrRateLimitReached
+	}
+	return nil
+}
+
+// Window allows for Limit requests per Duration
+type Window struct {
+	Limit    int
+	Duration time.Duration
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%d/%s", w.Limit, w.Duration)
+}
+
+// ParseWindows parses a comma separated list of rate limit windows of the form limit/duration,
+// e.g. 10/1s,1000/24h allows for 10 requests per second and 1000 requests per day.
+func ParseWindows(s string) ([]Window, error) {
+	parts := strings.Split(s, ",")
+	windows := make([]Window, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		limitStr, durationStr, found := strings.Cut(part, "/")
+		if !found {
+			return nil, fmt.Errorf("invalid rate limit %q: expected limit/duration, e.g. 1000/24h", part)
+		}
+
+		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: limit must be a positive number", part)
+		}
+
+		duration, err := time.ParseDuration(strings.TrimSpace(durationStr))
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: duration must be positive, e.g. 1s, 1h or 24h", part)
+		}
+
+		windows = append(windows, Window{
+			Limit:    limit,
+			Duration: duration,
+		})
+	}
+
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("invalid rate limit %q: at least one limit/duration is required", s)
+	}
+
+	// short windows first in order not to waste tokens of long windows on denied bursts
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].Duration < windows[j].Duration
+	})
+	return windows, nil
+}
+
+// NewLimiter creates one rate limiter per window for the api with the given name.
+// Requests are only allowed if all of the windows allow them.
+// A single window keeps the plain api name, e.g. iphub.info, in order to keep the persisted
+// state of previous versions, multiple windows are named after the api and the window, e.g. iphub.info:10/1s.
+func NewLimiter(newLimiter NewLimiterFunc, name string, windows []Window) (Limiter, error) {
+	limiters := make(MultiLimiter, 0, len(windows))
+	for _, w := range windows {
+		limiterName := name
+		if len(windows) > 1 {
+			limiterName = fmt.Sprintf("%s:%s", name, w)
+		}
+		limiter, err := newLimiter(limiterName, w.Duration, w.Limit)
+		if err != nil {
+			return nil, err
+		}
+		limiters = append(limiters, limiter)
+	}
+
+	if len(limiters) == 1 {
+		return limiters[0], nil
+	}
+	return limiters, nil
+}
+
+// MultiLimiter allows requests only if all of its limiters allow them.
+// The limiters are asked in order and tokens that were taken from the limiters
+// before a denying limiter are not given back.
+type MultiLimiter []Limiter
+
+func (m MultiLimiter) Allow(ctx context.Context) (bool, error) {
+	for _, limiter := range m {
+		ok, err := limiter.Allow(ctx)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Remaining returns the smallest number of remaining requests of all limiters.
+func (m MultiLimiter) Remaining(ctx context.Context) (int, error) {
+	remaining := -1
+	for _, limiter := range m {
+		r, err := limiter.Remaining(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if remaining < 0 || r < remaining {
+			remaining = r
+		}
+	}
+	return max(remaining, 0), nil
+}
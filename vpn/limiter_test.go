@@ -0,0 +1,47 @@
+package vpn
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNewLimiterNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows string
+		want    []string
+	}{
+		{
+			name:    "single window keeps the api name",
+			windows: "1000/24h",
+			want:    []string{"iphub.info"},
+		},
+		{
+			name:    "multiple windows are named after their window",
+			windows: "1000/24h,10/1s",
+			want:    []string{"iphub.info:10/1s", "iphub.info:1000/24h0m0s"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := ParseWindows(tt.windows)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			_, err = NewLimiter(func(name string, window time.Duration, limit int) (Limiter, error) {
+				names = append(names, name)
+				return NewRateLimiter(window, limit), nil
+			}, "iphub.info", windows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Fatalf("expected limiter names %v, got %v", tt.want, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package vpn
+
+import "sync"
+
+// lookup is an in-flight or completed IP lookup
+type lookup struct {
+	wg     sync.WaitGroup
+	isVPN  bool
+	reason string
+	err    error
+}
+
+// lookupGroup deduplicates concurrent lookups of the same IP.
+// Every caller that asks for an IP that is currently being looked up
+// waits for the in-flight lookup and gets the same result.
+// The zero value is ready to use.
+type lookupGroup struct {
+	mu      sync.Mutex
+	lookups map[string]*lookup
+}
+
+// Do executes fn for the given ip, unless there is already a lookup of the same ip in flight.
+// In that case Do waits for the in-flight lookup to finish and returns its result.
+// shared is true in case the result was given to multiple callers.
+func (g *lookupGroup) Do(ip string, fn func() (bool, string, error)) (isVPN bool, reason string, shared bool, err error) {
+	g.mu.Lock()
+	if g.lookups == nil {
+		g.lookups = make(map[string]*lookup)
+	}
+
+	if l, found := g.lookups[ip]; found {
+		g.mu.Unlock()
+		l.wg.Wait()
+		return l.isVPN, l.reason, true, l.err
+	}
+
+	l := &lookup{}
+	l.wg.Add(1)
+	g.lookups[ip] = l
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.lookups, ip)
+		g.mu.Unlock()
+		l.wg.Done()
+	}()
+
+	l.isVPN, l.reason, l.err = fn()
+	return l.isVPN, l.reason, false, l.err
+}
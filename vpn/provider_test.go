@@ -0,0 +1,156 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// providerTest is the answer of a fake api endpoint and the expected verdict
+type providerTest struct {
+	name   string
+	status int
+	header map[string]string
+	body   string
+
+	wantErr bool
+	// quota expects the api to be suspended afterwards
+	quota      bool
+	confidence float64
+	category   Category
+}
+
+// newProviderFunc creates the api that is tested against the fake api endpoint
+type newProviderFunc func(c *http.Client, baseURL *url.URL) VPN
+
+// testProvider asks the api created by newProvider for the ip 1.2.3.4 with every answer of the tests.
+// checkRequest may be nil and validates the requests of the api.
+func testProvider(t *testing.T, newProvider newProviderFunc, checkRequest func(t *testing.T, r *http.Request), tests []providerTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if checkRequest != nil {
+					checkRequest(t, r)
+				}
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			baseURL, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			api := newProvider(srv.Client(), baseURL)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			verdict, err := api.IsVPN(ctx, "1.2.3.4")
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr && tt.quota != errors.Is(err, ErrQuotaExhausted) {
+				t.Fatalf("expected quota exhausted error %t, got %v", tt.quota, err)
+			}
+			if err == nil {
+				if verdict.Confidence != tt.confidence {
+					t.Errorf("expected confidence %.2f, got %.2f", tt.confidence, verdict.Confidence)
+				}
+				if verdict.Category != tt.category {
+					t.Errorf("expected category %q, got %q", tt.category, verdict.Category)
+				}
+			}
+
+			// suspended apis do not send any requests until their quota resets
+			_, err = api.IsVPN(ctx, "1.2.3.4")
+			if tt.quota {
+				if !errors.Is(err, ErrQuotaExhausted) {
+					t.Fatalf("expected the api to be suspended, got %v", err)
+				}
+				if n := requests.Load(); n != 1 {
+					t.Fatalf("expected a single request, got %d", n)
+				}
+			} else if errors.Is(err, ErrQuotaExhausted) {
+				t.Fatalf("expected the api not to be suspended, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProviderErrorsDoNotContainKey(t *testing.T) {
+	newGeneric := func(c *http.Client, baseURL *url.URL, apiKey string) VPN {
+		api, err := NewGenericAPI(c, "example.com", baseURL.String()+"/{ip}?key={key}", apiKey, nil, `{ip}.proxy == "yes"`, CategoryProxy, testLimiter())
+		if err != nil {
+			panic(err)
+		}
+		return api
+	}
+
+	tests := []struct {
+		name        string
+		newProvider func(c *http.Client, baseURL *url.URL, apiKey string) VPN
+	}{
+		{"abuseipdb", func(c *http.Client, baseURL *url.URL, apiKey string) VPN {
+			return NewAbuseIPDB(c, baseURL, apiKey, testLimiter())
+		}},
+		{"generic", newGeneric},
+		{"getipintel", func(c *http.Client, baseURL *url.URL, apiKey string) VPN {
+			return NewGetIPIntel(c, baseURL, apiKey, testLimiter())
+		}},
+		{"ipapi", func(c *http.Client, baseURL *url.URL, apiKey string) VPN {
+			return NewIPAPI(c, baseURL, apiKey, testLimiter())
+		}},
+		{"iphub", func(c *http.Client, baseURL *url.URL, apiKey string) VPN {
+			return NewIPHub(c, baseURL, apiKey, testLimiter())
+		}},
+		{"ipqualityscore", func(c *http.Client, baseURL *url.URL, apiKey string) VPN {
+			return NewIPQualityScore(c, baseURL, apiKey, testLimiter())
+		}},
+		{"proxycheck", func(c *http.Client, baseURL *url.URL, apiKey string) VPN {
+			return NewProxyCheck(c, baseURL, apiKey, testLimiter())
+		}},
+		{"vpnapi", func(c *http.Client, baseURL *url.URL, apiKey string) VPN {
+			return NewVPNAPI(c, baseURL, apiKey, testLimiter())
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.NotFoundHandler())
+			baseURL, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// connection refused
+			srv.Close()
+
+			api := tt.newProvider(http.DefaultClient, baseURL, "secret")
+			_, err = api.IsVPN(context.Background(), "1.2.3.4")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if strings.Contains(err.Error(), "secret") {
+				t.Fatalf("error contains the api key: %v", err)
+			}
+		})
+	}
+}
+
+// testLimiter does not limit the requests of the tests
+func testLimiter() Limiter {
+	return NewRateLimiter(time.Hour, 100)
+}
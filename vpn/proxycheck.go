@@ -1,23 +1,31 @@
 package vpn
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/netip"
 	"net/url"
-	"path"
+	"strings"
 	"time"
 )
 
 var _ VPN = (*ProxyCheck)(nil)
 
-// NewProxyCheck reates a new api that can be checked for VPN IPs
-func NewProxyCheck(c *http.Client, apikey string) *ProxyCheck {
+// ProxyCheckURL is the default base url of the proxycheck api
+const ProxyCheckURL = "https://proxycheck.io"
+
+// NewProxyCheck reates a new api that can be checked for VPN IPs.
+// baseURL may be nil in order to use the default ProxyCheckURL.
+func NewProxyCheck(c *http.Client, baseURL *url.URL, apikey string, limiter Limiter) *ProxyCheck {
 	return &ProxyCheck{
 		client:  c,
-		limiter: NewRateLimiter(24*time.Hour, 1000),
+		baseURL: orDefaultURL(baseURL, ProxyCheckURL),
+		limiter: limiter,
 		apiKey:  apikey,
 	}
 }
@@ -25,7 +33,9 @@ func NewProxyCheck(c *http.Client, apikey string) *ProxyCheck {
 // ProxyCheck implemets the VPNApi interface and checks whether a given IP is a vpn
 type ProxyCheck struct {
 	client  *http.Client
-	limiter *RateLimiter
+	baseURL *url.URL
+	limiter Limiter
+	quota   suspender
 	apiKey  string
 }
 
@@ -50,79 +60,148 @@ type ProxyCheckInfoResponseData struct {
 	Type       string
 }
 
-// Fetch :
-func (ih *ProxyCheck) Fetch(IP string) (bool, error) {
+// Fetch requests the ip information from the api endpoint.
+// Only explicitly as VPN or TOR typed proxies are considered to be certain detections.
+func (ih *ProxyCheck) Fetch(ctx context.Context, IP string) (Verdict, error) {
 
-	u := url.URL{
-		Scheme: "https",
-		Host:   "proxycheck.io",
-		Path:   path.Join("/v2/", IP),
-		RawQuery: url.Values{
-			"vpn": []string{"1"},
-			"asn": []string{"1"},
-			"key": []string{ih.apiKey},
-		}.Encode(),
-	}
+	u := ih.baseURL.JoinPath("v2", IP)
+	u.RawQuery = url.Values{
+		"vpn": []string{"1"},
+		"asn": []string{"1"},
+		"key": []string{ih.apiKey},
+	}.Encode()
 
-	request, err := http.NewRequest(http.MethodGet, u.String(), http.NoBody)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
 	if err != nil {
-		return false, fmt.Errorf("failed to create request: %w", err)
+		return Verdict{}, fmt.Errorf("failed to create request: %w", redactURL(err))
 	}
 
 	response, err := ih.client.Do(request)
 	if err != nil {
-		return false, err
+		return Verdict{}, redactURL(err)
 	}
 	defer response.Body.Close()
 
+	err = ih.quota.CheckResponse(ih.String(), response)
+	if err != nil {
+		return Verdict{}, err
+	}
+
 	// status
 	status := response.StatusCode
 	if status/100 != 2 {
-		return false, fmt.Errorf("response code is not 200: %d", status)
+		return Verdict{}, fmt.Errorf("response code is not 200: %d", status)
 	}
 
 	// body
 	bytes, err := io.ReadAll(response.Body)
 	if err != nil {
-		return false, fmt.Errorf("error while reading response body: %w", err)
+		return Verdict{}, fmt.Errorf("error while reading response body: %w", err)
 	}
 	var data map[string]json.RawMessage
 	err = json.Unmarshal(bytes, &data)
 	if err != nil {
-		return false, err
+		return Verdict{}, err
 	}
 
 	var statusApi string
 	err = json.Unmarshal(data["status"], &statusApi)
 	if err != nil {
-		return false, err
+		return Verdict{}, err
 	}
 
 	if statusApi != "ok" {
 		var messageApi string
 		err = json.Unmarshal(data["message"], &messageApi)
 		if err != nil {
-			return false, err
+			return Verdict{}, err
+		}
+
+		if statusApi == "denied" {
+			// denied queries reset daily at midnight UTC
+			log.Printf("[ERROR]: %s: %s\n", ih.String(), messageApi)
+			now := time.Now().UTC()
+			midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+			return Verdict{}, ih.quota.Suspend(ih.String(), midnight)
 		}
 
-		return false, errors.New(messageApi)
+		return Verdict{}, errors.New(messageApi)
+	}
+
+	info := lookupProxyCheckIP(data, IP)
+	if info == nil {
+		return Verdict{}, fmt.Errorf("ip %s not found in response", IP)
 	}
 
 	responseApi := ProxyCheckInfoResponseData{}
-	err = json.Unmarshal(data[IP], &responseApi)
+	err = json.Unmarshal(info, &responseApi)
 	if err != nil {
-		return false, err
+		return Verdict{}, err
+	}
+
+	verdict := Verdict{
+		ASN:     parseASN(responseApi.Asn),
+		ISP:     responseApi.Provider,
+		Country: responseApi.Isocode,
+		Raw:     info,
 	}
 
-	return responseApi.Proxy == "yes", nil
+	if responseApi.Proxy != "yes" {
+		return verdict, nil
+	}
+
+	switch strings.ToUpper(responseApi.Type) {
+	case "VPN":
+		verdict.Confidence = 1
+		verdict.Category = CategoryVPN
+	case "TOR":
+		verdict.Confidence = 1
+		verdict.Category = CategoryTor
+	case "INFERENCE ENGINE":
+		// guessed by proxycheck's machine learning
+		verdict.Confidence = 0.5
+		verdict.Category = CategoryProxy
+	default:
+		// SOCKS, HTTP, Compromised Server, etc.
+		verdict.Confidence = 0.75
+		verdict.Category = CategoryProxy
+	}
+	return verdict, nil
 
 }
 
+// lookupProxyCheckIP returns the ip information of the response.
+// ipv6 addresses might be returned in a different notation than requested.
+func lookupProxyCheckIP(data map[string]json.RawMessage, IP string) json.RawMessage {
+	if info, found := data[IP]; found {
+		return info
+	}
+
+	ip, err := netip.ParseAddr(IP)
+	if err != nil {
+		return nil
+	}
+
+	for key, info := range data {
+		addr, err := netip.ParseAddr(key)
+		if err == nil && addr == ip {
+			return info
+		}
+	}
+	return nil
+}
+
 // IsVPN tests if a given IP is a VPN IP
-func (ih *ProxyCheck) IsVPN(IP string) (bool, error) {
-	if !ih.limiter.Allow() {
-		return false, ErrRateLimitReached
+func (ih *ProxyCheck) IsVPN(ctx context.Context, IP string) (Verdict, error) {
+	err := ih.quota.Check(ih.String())
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	err = allow(ctx, ih.limiter)
+	if err != nil {
+		return Verdict{}, err
 	}
 
-	return ih.Fetch(IP)
+	return ih.Fetch(ctx, IP)
 }
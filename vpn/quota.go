@@ -0,0 +1,138 @@
+package vpn
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultSuspension is used in case an api endpoint does not tell us when its quota resets
+const defaultSuspension = time.Hour
+
+var (
+	// ErrQuotaExhausted is returned by api endpoints that reported that their quota is exhausted.
+	// Use errors.As with *QuotaExhaustedError in order to get the time when the quota resets.
+	ErrQuotaExhausted = errors.New("quota exhausted")
+
+	// exposed via expvar at /debug/vars of the admin api
+	quotaSuspensions    = expvar.NewMap("vpn_api_quota_suspensions")
+	quotaSuspendedUntil = expvar.NewMap("vpn_api_quota_suspended_until")
+)
+
+// QuotaExhaustedError is returned in case an api endpoint is suspended until its quota resets
+type QuotaExhaustedError struct {
+	API   string
+	Until time.Time
+}
+
+func (e *QuotaExhaustedError) Error() string {
+	return fmt.Sprintf("%s: %v until %s", e.API, ErrQuotaExhausted, e.Until.Format(time.RFC3339))
+}
+
+func (e *QuotaExhaustedError) Is(target error) bool {
+	return target == ErrQuotaExhausted
+}
+
+// suspender suspends the requests to an api endpoint until its quota resets.
+// The zero value is ready to use.
+type suspender struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// Check returns a *QuotaExhaustedError in case the api endpoint is currently suspended.
+func (s *suspender) Check(api string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Now().Before(s.until) {
+		return &QuotaExhaustedError{
+			API:   api,
+			Until: s.until,
+		}
+	}
+	return nil
+}
+
+// Suspend suspends the api endpoint until the given time.
+func (s *suspender) Suspend(api string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if until.After(s.until) {
+		s.until = until
+		log.Printf("[suspended]: %s: quota exhausted, suspended until %s\n", api, until.Format(time.RFC3339))
+
+		quotaSuspensions.Add(api, 1)
+		var v expvar.String
+		v.Set(until.Format(time.RFC3339))
+		quotaSuspendedUntil.Set(api, &v)
+	}
+
+	return &QuotaExhaustedError{
+		API:   api,
+		Until: s.until,
+	}
+}
+
+// CheckResponse suspends the api endpoint in case the response reports an exhausted quota.
+// An error is returned for 429 Too Many Requests and 403 Forbidden responses without remaining requests.
+// Successful responses without any remaining requests are valid, but the api endpoint is suspended afterwards.
+func (s *suspender) CheckResponse(api string, response *http.Response) error {
+	now := time.Now()
+	remaining, hasRemaining := rateLimitRemaining(response.Header)
+	exhausted := hasRemaining && remaining <= 0
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests,
+		response.StatusCode == http.StatusForbidden && exhausted:
+		return s.Suspend(api, quotaReset(response.Header, now))
+	case response.StatusCode/100 == 2 && exhausted:
+		_ = s.Suspend(api, quotaReset(response.Header, now))
+	}
+	return nil
+}
+
+// rateLimitRemaining returns the value of the X-RateLimit-Remaining header
+func rateLimitRemaining(header http.Header) (int, bool) {
+	value := header.Get("X-RateLimit-Remaining")
+	if value == "" {
+		return 0, false
+	}
+	remaining, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// quotaReset returns the time when the quota resets based on the Retry-After or X-RateLimit-Reset headers.
+func quotaReset(header http.Header, now time.Time) time.Time {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 0 {
+			return now.Add(time.Duration(seconds) * time.Second)
+		}
+		if date, err := http.ParseTime(value); err == nil && date.After(now) {
+			return date
+		}
+	}
+
+	if value := header.Get("X-RateLimit-Reset"); value != "" {
+		if reset, err := strconv.ParseInt(value, 10, 64); err == nil && reset > 0 {
+			// either a unix timestamp or the number of seconds until the reset
+			if reset > 1_000_000_000 {
+				if date := time.Unix(reset, 0); date.After(now) {
+					return date
+				}
+			} else {
+				return now.Add(time.Duration(reset) * time.Second)
+			}
+		}
+	}
+	return now.Add(defaultSuspension)
+}
@@ -0,0 +1,148 @@
+package vpn
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSuspenderCheckResponse(t *testing.T) {
+	now := time.Now()
+	resetAt := now.Add(2 * time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name   string
+		status int
+		header map[string]string
+		// wantErr is whether the response itself is rejected
+		wantErr bool
+		// suspended is the expected suspension relative to now, 0 is not suspended
+		suspended time.Duration
+	}{
+		{
+			name:   "ok",
+			status: http.StatusOK,
+		},
+		{
+			name:   "ok with remaining requests",
+			status: http.StatusOK,
+			header: map[string]string{"X-RateLimit-Remaining": "5"},
+		},
+		{
+			name:      "ok with last request",
+			status:    http.StatusOK,
+			header:    map[string]string{"X-RateLimit-Remaining": "0", "Retry-After": "60"},
+			suspended: time.Minute,
+		},
+		{
+			name:      "too many requests without headers",
+			status:    http.StatusTooManyRequests,
+			wantErr:   true,
+			suspended: defaultSuspension,
+		},
+		{
+			name:      "too many requests with retry after seconds",
+			status:    http.StatusTooManyRequests,
+			header:    map[string]string{"Retry-After": "120"},
+			wantErr:   true,
+			suspended: 2 * time.Minute,
+		},
+		{
+			name:      "too many requests with retry after date",
+			status:    http.StatusTooManyRequests,
+			header:    map[string]string{"Retry-After": resetAt.UTC().Format(http.TimeFormat)},
+			wantErr:   true,
+			suspended: resetAt.Sub(now),
+		},
+		{
+			name:      "too many requests with invalid retry after",
+			status:    http.StatusTooManyRequests,
+			header:    map[string]string{"Retry-After": "soon"},
+			wantErr:   true,
+			suspended: defaultSuspension,
+		},
+		{
+			name:      "too many requests with reset in seconds",
+			status:    http.StatusTooManyRequests,
+			header:    map[string]string{"X-RateLimit-Reset": "30"},
+			wantErr:   true,
+			suspended: 30 * time.Second,
+		},
+		{
+			name:      "too many requests with reset timestamp",
+			status:    http.StatusTooManyRequests,
+			header:    map[string]string{"X-RateLimit-Reset": strconv.FormatInt(resetAt.Unix(), 10)},
+			wantErr:   true,
+			suspended: resetAt.Sub(now),
+		},
+		{
+			name:      "forbidden without remaining requests",
+			status:    http.StatusForbidden,
+			header:    map[string]string{"X-RateLimit-Remaining": "0"},
+			wantErr:   true,
+			suspended: defaultSuspension,
+		},
+		{
+			name:   "forbidden with remaining requests",
+			status: http.StatusForbidden,
+			header: map[string]string{"X-RateLimit-Remaining": "10"},
+		},
+		{
+			name:   "forbidden with invalid remaining requests",
+			status: http.StatusForbidden,
+			header: map[string]string{"X-RateLimit-Remaining": "none"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{},
+			}
+			for k, v := range tt.header {
+				response.Header.Set(k, v)
+			}
+
+			var s suspender
+			err := s.CheckResponse("test", response)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrQuotaExhausted) {
+				t.Fatalf("expected quota exhausted error, got %v", err)
+			}
+
+			err = s.Check("test")
+			if tt.suspended == 0 {
+				if err != nil {
+					t.Fatalf("expected no suspension, got %v", err)
+				}
+				return
+			}
+
+			var qe *QuotaExhaustedError
+			if !errors.As(err, &qe) {
+				t.Fatalf("expected suspension, got %v", err)
+			}
+			if d := qe.Until.Sub(now.Add(tt.suspended)); d < -2*time.Second || d > 2*time.Second {
+				t.Fatalf("expected suspension for %s, got until %s", tt.suspended, qe.Until)
+			}
+		})
+	}
+}
+
+func TestSuspenderSuspendKeepsLatest(t *testing.T) {
+	var s suspender
+	later := time.Now().Add(time.Hour)
+
+	_ = s.Suspend("test", later)
+	err := s.Suspend("test", time.Now().Add(time.Minute))
+
+	var qe *QuotaExhaustedError
+	if !errors.As(err, &qe) || !qe.Until.Equal(later) {
+		t.Fatalf("expected suspension until %s, got %v", later, err)
+	}
+}
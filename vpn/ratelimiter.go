@@ -2,6 +2,9 @@ package vpn
 
 import (
 	"container/ring"
+	"context"
+	"log"
+	"sort"
 	"sync"
 	"time"
 )
@@ -14,8 +17,19 @@ type RateLimiter struct {
 	size      int
 	mutex     sync.Mutex
 	now       func() time.Time
+
+	// optional persistence of the token expiration times
+	name         string
+	store        RateLimiterStore
+	persistEvery time.Duration
+	saveMutex    sync.Mutex
+	saved        time.Time
+	pending      bool
 }
 
+// persistInterval is the minimum time between two saves of the state of a persistent rate limiter
+const persistInterval = time.Second
+
 // NewRateLimiter initializes the RateLimiter with the current time
 // expirationDuration What is the time duration each token expires in
 // rateLimit is the amount of requests per expirationDiration, like 1000 requests per Day
@@ -38,9 +52,64 @@ func NewRateLimiter(expirationDuration time.Duration, rateLimit int) *RateLimite
 	return r
 }
 
+// NewPersistentRateLimiter initializes the RateLimiter with the token expiration times that were
+// saved in the store under the given name and saves them there in the background after allowed requests.
+// Bursts of requests are saved at most once per second, the latest state is always saved.
+func NewPersistentRateLimiter(store RateLimiterStore, name string, expirationDuration time.Duration, rateLimit int) (*RateLimiter, error) {
+	r := NewRateLimiter(expirationDuration, rateLimit)
+	r.name = name
+	r.store = store
+	r.persistEvery = persistInterval
+
+	expirations, err := store.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	r.restore(expirations)
+	return r, nil
+}
+
+// restore fills the ring buffer with the given token expiration times.
+// the ring buffer position points to the newest token, the next one is the oldest token.
+func (r *RateLimiter) restore(expirations []time.Time) {
+	sort.Slice(expirations, func(i, j int) bool {
+		return expirations[i].Before(expirations[j])
+	})
+
+	// more tokens than the limit allows in case the limit was lowered
+	if len(expirations) > r.size {
+		expirations = expirations[len(expirations)-r.size:]
+	}
+
+	initialValue := r.now()
+	values := make([]time.Time, r.size)
+	offset := r.size - len(expirations)
+	for i := 0; i < offset; i++ {
+		values[i] = initialValue
+	}
+	copy(values[offset:], expirations)
+
+	for _, value := range values {
+		r.buffer = r.buffer.Next()
+		r.buffer.Value = value
+	}
+}
+
+// snapshot returns the expiration times of all tokens that did not expire, yet.
+func (r *RateLimiter) snapshot(now time.Time) []time.Time {
+	expirations := make([]time.Time, 0, r.size)
+	r.buffer.Do(func(value any) {
+		expiresAt := value.(time.Time)
+		if expiresAt.After(now) {
+			expirations = append(expirations, expiresAt)
+		}
+	})
+	return expirations
+}
+
 // Allow returns true if the rate has not yet been exceeded, returns false otherwise.
 // Info: goroutine safe
-func (r *RateLimiter) Allow() bool {
+func (r *RateLimiter) Allow(_ context.Context) (bool, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
@@ -52,8 +121,42 @@ func (r *RateLimiter) Allow() bool {
 		r.buffer = r.buffer.Next()
 		// and inser our new expiration for the action that is going to happen after this function call
 		r.buffer.Value = now.Add(r.expiresIn)
-		return true
+
+		if r.store != nil && !r.pending {
+			// the request is not delayed by the store, subsequent requests are saved together
+			r.pending = true
+			time.AfterFunc(r.saved.Add(r.persistEvery).Sub(now), r.save)
+		}
+		return true, nil
 	}
 	// the next token has not yet expired, so we cannot do any more requests
-	return false
+	return false, nil
+}
+
+// save persists the current token expiration times.
+// The request is allowed even if the state cannot be persisted.
+func (r *RateLimiter) save() {
+	// saves of concurrent timers are not reordered
+	r.saveMutex.Lock()
+	defer r.saveMutex.Unlock()
+
+	r.mutex.Lock()
+	r.pending = false
+	r.saved = r.now()
+	expirations := r.snapshot(r.saved)
+	r.mutex.Unlock()
+
+	err := r.store.Save(r.name, expirations)
+	if err != nil {
+		log.Printf("[ERROR]: %v", err)
+	}
+}
+
+// Remaining returns the number of tokens that expired and can be used for new requests.
+// Info: goroutine safe
+func (r *RateLimiter) Remaining(_ context.Context) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.size - len(r.snapshot(r.now())), nil
 }
@@ -0,0 +1,88 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindowScript implements a sliding window log in a sorted set.
+// The redis server time is used in order not to depend on the clocks of the detector instances.
+// KEYS[1]: key, ARGV[1]: window in milliseconds, ARGV[2]: limit, ARGV[3]: unique member suffix
+var slidingWindowScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local window = tonumber(ARGV[1])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], now, now .. '-' .. ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return 1
+end
+return 0
+`)
+
+// remainingScript counts the requests of the sliding window without adding a new one.
+// KEYS[1]: key, ARGV[1]: window in milliseconds
+var remainingScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+return redis.call('ZCOUNT', KEYS[1], '(' .. (now - tonumber(ARGV[1])), '+inf')
+`)
+
+// NewRedisRateLimiter creates a rate limiter that shares its rate limit with all
+// rate limiters that use the same redis database and key.
+// limit is the amount of requests per window, like 1000 requests per day.
+func NewRedisRateLimiter(rdb *redis.Client, key string, window time.Duration, limit int) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		rdb:    rdb,
+		key:    key,
+		window: window,
+		limit:  limit,
+	}
+}
+
+// RedisRateLimiter is a sliding window rate limiter that is stored in redis.
+// Multiple detector instances that share the same redis database share the same rate limit.
+type RedisRateLimiter struct {
+	rdb    *redis.Client
+	key    string
+	window time.Duration
+	limit  int
+}
+
+// Allow returns true if the rate has not yet been exceeded by any of the instances, returns false otherwise.
+// Info: goroutine safe
+func (r *RedisRateLimiter) Allow(ctx context.Context) (bool, error) {
+	allowed, err := slidingWindowScript.Run(
+		ctx,
+		r.rdb,
+		[]string{r.key},
+		r.window.Milliseconds(),
+		r.limit,
+		strconv.FormatUint(rand.Uint64(), 36),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to check shared rate limit %s: %w", r.key, err)
+	}
+	return allowed == 1, nil
+}
+
+// Remaining returns the number of requests that all instances can still make within the current window.
+func (r *RedisRateLimiter) Remaining(ctx context.Context) (int, error) {
+	used, err := remainingScript.Run(
+		ctx,
+		r.rdb,
+		[]string{r.key},
+		r.window.Milliseconds(),
+	).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check shared rate limit %s: %w", r.key, err)
+	}
+	return max(r.limit-used, 0), nil
+}
@@ -0,0 +1,138 @@
+package vpn
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nutsdb/nutsdb"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimiterStore persists the token expiration times of rate limiters
+// in order for the rate limits to be honored across restarts.
+type RateLimiterStore interface {
+	Load(name string) ([]time.Time, error)
+	Save(name string, expirations []time.Time) error
+}
+
+var (
+	_ RateLimiterStore = (*NutsRateLimiterStore)(nil)
+	_ RateLimiterStore = (*RedisRateLimiterStore)(nil)
+)
+
+// NewNutsRateLimiterStore persists the rate limiter states in the given nutsdb bucket.
+func NewNutsRateLimiterStore(nuts *nutsdb.DB, bucket string) *NutsRateLimiterStore {
+	return &NutsRateLimiterStore{
+		nuts:       nuts,
+		nutsBucket: bucket,
+	}
+}
+
+// NutsRateLimiterStore persists the rate limiter states in the local nutsdb database
+type NutsRateLimiterStore struct {
+	nuts       *nutsdb.DB
+	nutsBucket string
+}
+
+func (s *NutsRateLimiterStore) Load(name string) (expirations []time.Time, err error) {
+	err = s.nuts.View(func(tx *nutsdb.Tx) error {
+		value, err := tx.Get(s.nutsBucket, []byte(name))
+		if err != nil {
+			return err
+		}
+		expirations, err = decodeExpirations(value)
+		return err
+	})
+	if errors.Is(err, nutsdb.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rate limit of %s: %w", name, err)
+	}
+	return expirations, nil
+}
+
+func (s *NutsRateLimiterStore) Save(name string, expirations []time.Time) error {
+	err := s.nuts.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put(s.nutsBucket, []byte(name), encodeExpirations(expirations), nutsdb.Persistent)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save rate limit of %s: %w", name, err)
+	}
+	return nil
+}
+
+// NewRedisRateLimiterStore persists the rate limiter states in redis keys with the given prefix.
+func NewRedisRateLimiterStore(ctx context.Context, rdb *redis.Client, prefix string) *RedisRateLimiterStore {
+	return &RedisRateLimiterStore{
+		ctx:    ctx,
+		rdb:    rdb,
+		prefix: prefix,
+	}
+}
+
+// RedisRateLimiterStore persists the rate limiter states in the redis database
+type RedisRateLimiterStore struct {
+	ctx    context.Context
+	rdb    *redis.Client
+	prefix string
+}
+
+func (s *RedisRateLimiterStore) Load(name string) ([]time.Time, error) {
+	value, err := s.rdb.Get(s.ctx, s.prefix+name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rate limit of %s: %w", name, err)
+	}
+	return decodeExpirations(value)
+}
+
+func (s *RedisRateLimiterStore) Save(name string, expirations []time.Time) error {
+	// the state is not needed anymore after the last token expired
+	var ttl time.Duration
+	for _, e := range expirations {
+		if d := time.Until(e); d > ttl {
+			ttl = d
+		}
+	}
+
+	if ttl <= 0 {
+		err := s.rdb.Del(s.ctx, s.prefix+name).Err()
+		if err != nil {
+			return fmt.Errorf("failed to save rate limit of %s: %w", name, err)
+		}
+		return nil
+	}
+
+	err := s.rdb.Set(s.ctx, s.prefix+name, encodeExpirations(expirations), ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save rate limit of %s: %w", name, err)
+	}
+	return nil
+}
+
+// encodeExpirations encodes the expiration times as unix nanoseconds
+func encodeExpirations(expirations []time.Time) []byte {
+	data := make([]byte, 0, 8*len(expirations))
+	for _, e := range expirations {
+		data = binary.BigEndian.AppendUint64(data, uint64(e.UnixNano()))
+	}
+	return data
+}
+
+func decodeExpirations(data []byte) ([]time.Time, error) {
+	if len(data)%8 != 0 {
+		return nil, fmt.Errorf("invalid rate limit state of length %d", len(data))
+	}
+
+	expirations := make([]time.Time, 0, len(data)/8)
+	for i := 0; i < len(data); i += 8 {
+		expirations = append(expirations, time.Unix(0, int64(binary.BigEndian.Uint64(data[i:i+8]))))
+	}
+	return expirations, nil
+}
@@ -0,0 +1,81 @@
+package vpn
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore counts the saves of the rate limiter states
+type memoryStore struct {
+	mu          sync.Mutex
+	saves       int
+	expirations map[string][]time.Time
+}
+
+func (s *memoryStore) Load(name string) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expirations[name], nil
+}
+
+func (s *memoryStore) Save(name string, expirations []time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saves++
+	s.expirations[name] = expirations
+	return nil
+}
+
+func (s *memoryStore) state(name string) (saves, tokens int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saves, len(s.expirations[name])
+}
+
+func TestPersistentRateLimiterThrottlesSaves(t *testing.T) {
+	store := &memoryStore{expirations: map[string][]time.Time{}}
+	r, err := NewPersistentRateLimiter(store, "test", time.Hour, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.persistEvery = 50 * time.Millisecond
+
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		ok, err := r.Allow(ctx)
+		if err != nil || !ok {
+			t.Fatalf("expected request %d to be allowed: %v", i, err)
+		}
+	}
+
+	// the leading save and one trailing save
+	deadline := time.Now().Add(time.Second)
+	for {
+		saves, tokens := store.state("test")
+		if tokens == 50 {
+			if saves > 2 {
+				t.Fatalf("expected at most 2 saves, got %d", saves)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 50 saved tokens, got %d after %d saves", tokens, saves)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the saved state is restored
+	restored, err := NewPersistentRateLimiter(store, "test", time.Hour, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining, err := restored.Remaining(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 50 {
+		t.Fatalf("expected 50 remaining requests, got %d", remaining)
+	}
+}
@@ -0,0 +1,95 @@
+package vpn
+
+// APIAnswer is the answer of a single local database or api endpoint, local databases are not weighted
+type APIAnswer struct {
+	API        string   `json:"api"`
+	Weight     float64  `json:"weight,omitempty"`
+	Valid      bool     `json:"valid"`
+	Confidence float64  `json:"confidence"`
+	Category   Category `json:"category,omitempty"`
+	ASN        int      `json:"asn,omitempty"`
+	ISP        string   `json:"isp,omitempty"`
+	Country    string   `json:"country,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func newAPIAnswer(api VPN, weight float64, valid Valid) APIAnswer {
+	a := APIAnswer{
+		API:    api.String(),
+		Weight: weight,
+		Valid:  valid.IsValid,
+	}
+	if !valid.IsValid {
+		if valid.Err != nil {
+			a.Error = valid.Err.Error()
+		}
+		return a
+	}
+	a.Confidence = valid.Verdict.Confidence
+	a.Category = valid.Verdict.Category
+	a.ASN = valid.Verdict.ASN
+	a.ISP = valid.Verdict.ISP
+	a.Country = valid.Verdict.Country
+	return a
+}
+
+// Report explains the decision of the checker for a single ip
+type Report struct {
+	IP string `json:"ip"`
+
+	Allowed     bool   `json:"allowed"`
+	AllowReason string `json:"allow_reason,omitempty"`
+	Cached      bool   `json:"cached"`
+	CacheReason string `json:"cache_reason,omitempty"`
+	Whitelisted bool   `json:"whitelisted"`
+
+	Local  []APIAnswer `json:"local,omitempty"`
+	Online []APIAnswer `json:"online,omitempty"`
+	// Score is the weighted score of the valid online answers
+	Score     float64 `json:"score"`
+	Threshold float64 `json:"threshold"`
+	// Answers is the number of valid online answers, ips are only whitelisted with at least Quorum answers
+	Answers int `json:"answers"`
+	Quorum  int `json:"quorum"`
+
+	// VPN is the final verdict and Reason the cache reason of detected ips
+	VPN    bool   `json:"vpn"`
+	Reason string `json:"reason,omitempty"`
+	// Source of the final verdict: allowlist, cache, local, whitelist, online or offline
+	Source string `json:"source"`
+}
+
+// Explain runs the detection pipeline of IsVPN for the ip and reports every intermediate result.
+// noCache ignores the allowlist, the blacklist and the whitelist, which are still reported, and asks
+// the local databases and the online apis in any case.
+// noWrite does not cache the online result, neither in the blacklist nor in the whitelist.
+func (rdb *VPNChecker) Explain(sIP string, noCache, noWrite bool) (Report, error) {
+	IPStr, err := normalizeIP(sIP)
+	if err != nil {
+		return Report{}, err
+	}
+
+	// the states are read before the pipeline caches the online result and reported
+	// even if the pipeline ignored them or decided before reaching them
+	allowed, allowReason, err := rdb.allowed(IPStr)
+	if err != nil {
+		return Report{}, err
+	}
+	cached, _, cacheReason, err := rdb.foundInCache(IPStr)
+	if err != nil {
+		return Report{}, err
+	}
+	whitelisted, err := rdb.whitelisted(IPStr)
+	if err != nil {
+		return Report{}, err
+	}
+
+	r, err := rdb.check(IPStr, noCache, noWrite)
+	if err != nil {
+		return r, err
+	}
+	r.Allowed, r.AllowReason = allowed, allowReason
+	r.Cached, r.CacheReason = cached, cacheReason
+	r.Whitelisted = whitelisted
+	return r, nil
+}
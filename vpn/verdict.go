@@ -0,0 +1,133 @@
+package vpn
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Category is the kind of service that an IP was flagged as.
+type Category string
+
+const (
+	CategoryNone    Category = ""
+	CategoryVPN     Category = "vpn"
+	CategoryProxy   Category = "proxy"
+	CategoryTor     Category = "tor"
+	CategoryRelay   Category = "relay"
+	CategoryHosting Category = "hosting"
+)
+
+// Verdict is the answer of a VPN api endpoint for a single IP.
+// Everything except for the confidence is optional and depends on
+// the information that the api endpoint provides.
+type Verdict struct {
+	// Confidence between 0 (no vpn) and 1 (certainly a vpn) that the ip is a vpn
+	Confidence float64
+	// Category of the ip, CategoryNone in case the ip was not flagged
+	Category Category
+
+	ASN     int
+	ISP     string
+	Country string
+
+	// Raw is the unmodified response payload of the api endpoint
+	Raw json.RawMessage
+}
+
+// String returns a short human readable summary of the verdict, e.g.
+// vpn (1.00) AS9009 M247 Ltd RO
+func (v Verdict) String() string {
+	var sb strings.Builder
+	category := v.Category
+	if category == CategoryNone {
+		category = "clean"
+	}
+	sb.WriteString(fmt.Sprintf("%s (%.2f)", category, v.Confidence))
+
+	if v.ASN > 0 {
+		sb.WriteString(" AS")
+		sb.WriteString(strconv.Itoa(v.ASN))
+	}
+	if v.ISP != "" {
+		sb.WriteString(" ")
+		sb.WriteString(v.ISP)
+	}
+	if v.Country != "" {
+		sb.WriteString(" ")
+		sb.WriteString(v.Country)
+	}
+	return sb.String()
+}
+
+// parseASN parses autonomous system numbers of the form AS1234 or 1234
+func parseASN(asn string) int {
+	asn = strings.TrimSpace(asn)
+	if len(asn) >= 2 && strings.EqualFold(asn[:2], "AS") {
+		asn = asn[2:]
+	}
+	i, err := strconv.Atoi(asn)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+const (
+	// onlineReasonSuffix marks cache entries of ips that were flagged by the online detection
+	onlineReasonSuffix = " (f/o)"
+	// localReasonSuffix marks ips that were flagged by a local database
+	localReasonSuffix = " (local)"
+	// feedReasonPrefix marks cache entries that were imported from a feed, e.g. TOR (feed:torproject)
+	feedReasonPrefix = " (feed:"
+)
+
+// OnlineReason returns the cache reason of an ip that was flagged by the online detection, e.g. TOR (f/o)
+func OnlineReason(category Category) string {
+	if category == CategoryNone {
+		category = CategoryVPN
+	}
+	return strings.ToUpper(string(category)) + onlineReasonSuffix
+}
+
+// LocalReason returns the reason of an ip that was flagged by a local database, e.g. HOSTING (local)
+func LocalReason(category Category) string {
+	if category == CategoryNone {
+		category = CategoryVPN
+	}
+	return strings.ToUpper(string(category)) + localReasonSuffix
+}
+
+// FeedReason returns the cache reason of an ip range that was imported from the named feed, e.g. TOR (feed:torproject)
+func FeedReason(category Category, feed string) string {
+	if category == CategoryNone {
+		category = CategoryVPN
+	}
+	return strings.ToUpper(string(category)) + feedReasonPrefix + feed + ")"
+}
+
+// CategoryOf returns the category of an ip that was flagged by the online detection, by a local database
+// or imported from a feed based on its reason. Returns CategoryNone for reasons that were not created
+// by the detection, e.g. manually added ip ranges.
+func CategoryOf(reason string) Category {
+	var name string
+	switch {
+	case strings.HasSuffix(reason, onlineReasonSuffix):
+		name = strings.TrimSuffix(reason, onlineReasonSuffix)
+	case strings.HasSuffix(reason, localReasonSuffix):
+		name = strings.TrimSuffix(reason, localReasonSuffix)
+	case strings.Contains(reason, feedReasonPrefix) && strings.HasSuffix(reason, ")"):
+		name = reason[:strings.Index(reason, feedReasonPrefix)]
+	default:
+		return CategoryNone
+	}
+
+	category := Category(strings.ToLower(name))
+	switch category {
+	case CategoryVPN, CategoryProxy, CategoryTor, CategoryRelay, CategoryHosting:
+		return category
+	default:
+		return CategoryNone
+	}
+}
@@ -1,8 +1,10 @@
 package vpn
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/url"
 )
 
 var (
@@ -10,7 +12,32 @@ var (
 )
 
 // VPN API interface. Provides a method to test IPs for whether they are VPNs or not.
+// The passed context is canceled as soon as the answer is not needed anymore.
+// IsVPN returns the verdict of the api, which contains the confidence between 0 (no vpn)
+// and 1 (certainly a vpn) that the IP is a VPN as well as the reason why it was flagged.
 type VPN interface {
 	fmt.Stringer
-	IsVPN(IP string) (bool, error)
+	IsVPN(ctx context.Context, IP string) (Verdict, error)
+}
+
+// orDefaultURL returns the base url of an api or the parsed default url in case it is nil.
+func orDefaultURL(baseURL *url.URL, defaultURL string) *url.URL {
+	if baseURL != nil {
+		return baseURL
+	}
+	u, err := url.Parse(defaultURL)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// redactURL removes the request url from errors of the http client,
+// as the url may contain the api key.
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s request failed: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
 }
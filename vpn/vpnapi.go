@@ -1,33 +1,39 @@
 package vpn
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"path"
 	"strconv"
-	"time"
 )
 
 var _ VPN = (*VPNAPI)(nil)
 
+// VPNAPIURL is the default base url of the vpnapi api
+const VPNAPIURL = "https://vpnapi.io"
+
 // NewVPNAPI creates a new api endpoint that can check IPs for whether they are VPNs or not.
-func NewVPNAPI(c *http.Client, apiKey string) *VPNAPI {
+// baseURL may be nil in order to use the default VPNAPIURL.
+func NewVPNAPI(c *http.Client, baseURL *url.URL, apiKey string, limiter Limiter) *VPNAPI {
 	return &VPNAPI{
 		client:  c,
+		baseURL: orDefaultURL(baseURL, VPNAPIURL),
 		apiKey:  apiKey,
-		limiter: NewRateLimiter(24*time.Hour, 1000),
+		limiter: limiter,
 	}
 }
 
 // VPNAPI implements the VPNApi and allows to check if an ip is a vpn
 type VPNAPI struct {
 	client  *http.Client
+	baseURL *url.URL
 	apiKey  string
-	limiter *RateLimiter
+	limiter Limiter
+	quota   suspender
 }
 
 // String implements the stinger interface
@@ -37,6 +43,8 @@ func (*VPNAPI) String() string {
 
 type vpnAPIResponse struct {
 	Security Security `json:"security"`
+	Location Location `json:"location"`
+	Network  Network  `json:"network"`
 }
 
 type Security struct {
@@ -46,57 +54,93 @@ type Security struct {
 	Relay bool `json:"relay"`
 }
 
-// Fetch :
-func (it *VPNAPI) Fetch(IP string) (bool, error) {
-	u := url.URL{
-		Scheme: "https",
-		Host:   "vpnapi.io",
-		Path:   path.Join("/api/", IP),
-		RawQuery: url.Values{
-			"key": []string{it.apiKey},
-		}.Encode(),
-	}
+type Location struct {
+	CountryCode string `json:"country_code"`
+}
+
+type Network struct {
+	AutonomousSystemNumber       string `json:"autonomous_system_number"`
+	AutonomousSystemOrganization string `json:"autonomous_system_organization"`
+}
+
+// Fetch requests the ip information from the api endpoint.
+// Relays (e.g. iCloud Private Relay) are shared by many regular users,
+// which is why they are weaker signals than VPNs, proxies or TOR.
+func (it *VPNAPI) Fetch(ctx context.Context, IP string) (Verdict, error) {
+	u := it.baseURL.JoinPath("api", IP)
+	u.RawQuery = url.Values{
+		"key": []string{it.apiKey},
+	}.Encode()
 
-	request, err := http.NewRequest(http.MethodGet, u.String(), http.NoBody)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
 	if err != nil {
-		return false, err
+		return Verdict{}, fmt.Errorf("failed to create request: %w", redactURL(err))
 	}
 
 	response, err := it.client.Do(request)
 	if err != nil {
-		return false, err
+		return Verdict{}, redactURL(err)
 	}
 	defer response.Body.Close()
 
+	err = it.quota.CheckResponse(it.String(), response)
+	if err != nil {
+		return Verdict{}, err
+	}
+
 	// status
 	status := response.StatusCode
 	if status != 200 {
-		return false, errors.New("response code is not 200: " + strconv.Itoa(status))
+		return Verdict{}, errors.New("response code is not 200: " + strconv.Itoa(status))
 	}
 
 	// body
 	bytes, err := io.ReadAll(response.Body)
 	if err != nil {
-		return false, fmt.Errorf("error while reading response body: %w", err)
+		return Verdict{}, fmt.Errorf("error while reading response body: %w", err)
 	}
 
 	data := vpnAPIResponse{}
 	err = json.Unmarshal(bytes, &data)
 	if err != nil {
-		return false, err
+		return Verdict{}, err
+	}
+
+	verdict := Verdict{
+		ASN:     parseASN(data.Network.AutonomousSystemNumber),
+		ISP:     data.Network.AutonomousSystemOrganization,
+		Country: data.Location.CountryCode,
+		Raw:     bytes,
 	}
 
-	return data.Security.VPN ||
-		data.Security.Proxy ||
-		data.Security.Tor ||
-		data.Security.Relay, nil
+	switch {
+	case data.Security.Tor:
+		verdict.Confidence = 1
+		verdict.Category = CategoryTor
+	case data.Security.VPN:
+		verdict.Confidence = 1
+		verdict.Category = CategoryVPN
+	case data.Security.Proxy:
+		verdict.Confidence = 1
+		verdict.Category = CategoryProxy
+	case data.Security.Relay:
+		verdict.Confidence = 0.5
+		verdict.Category = CategoryRelay
+	}
+	return verdict, nil
 }
 
 // IsVPN requests the api endpoint to test whether an IP is a VPN
-func (it *VPNAPI) IsVPN(IP string) (bool, error) {
-	if !it.limiter.Allow() {
-		return false, ErrRateLimitReached
+func (it *VPNAPI) IsVPN(ctx context.Context, IP string) (Verdict, error) {
+	err := it.quota.Check(it.String())
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	err = allow(ctx, it.limiter)
+	if err != nil {
+		return Verdict{}, err
 	}
 
-	return it.Fetch(IP)
+	return it.Fetch(ctx, IP)
 }
@@ -1,59 +1,610 @@
 package vpn
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nutsdb/nutsdb"
+	"github.com/redis/go-redis/v9"
 )
 
-type Whitelister struct {
+// Whitelister caches ips that were not detected as vpn for a limited time
+// in order not to request the apis again for the same ip.
+// Ranges are stored as the CIDR prefixes that cover them, which is why a lookup
+// only needs to check the prefixes that contain the ip.
+type Whitelister interface {
+	// Exists returns whether the ip is part of a whitelisted ip or range
+	Exists(ip string) (found bool, err error)
+	// Whitelist caches the ip with the whitelist ttl
+	Whitelist(ip string) error
+	// WhitelistRange caches an ip, CIDR range or from-to range, a ttl of 0 keeps it forever
+	WhitelistRange(ipRange string, ttl time.Duration) error
+	// Since returns when the single ip was whitelisted, found is false for ips that are only part of a range
+	Since(ip string) (since time.Time, found bool, err error)
+	// Evict removes all whitelisted ips and ranges that overlap with the given ip ranges.
+	// Single ips only remove their own entry, the ranges that contain them are kept.
+	Evict(ipRanges ...string) (evicted int, err error)
+	// Get returns the whitelisted ip and ranges that contain the ip
+	Get(ip string) ([]WhitelistEntry, error)
+	// List returns all whitelisted ips and ranges sorted by their address
+	List() ([]WhitelistEntry, error)
+	// Purge removes all whitelisted ips and ranges
+	Purge() (purged int, err error)
+}
+
+// WhitelistEntry is a whitelisted ip or CIDR range
+type WhitelistEntry struct {
+	// Key is a single ip or a CIDR range
+	Key string `json:"key"`
+	// Since is zero for entries of older versions
+	Since time.Time `json:"since,omitempty"`
+	// Expires is zero for entries that never expire
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// newWhitelistEntry creates the entry of the key, ttl is the remaining time to live, negative for entries that never expire.
+func newWhitelistEntry(key, value string, ttl time.Duration, now time.Time) WhitelistEntry {
+	e := WhitelistEntry{Key: key}
+	e.Since, _ = parseWhitelistValue(value)
+	if ttl >= 0 {
+		e.Expires = now.Add(ttl)
+	}
+	return e
+}
+
+// sortEntries sorts the entries by their address and the less specific prefixes first
+func sortEntries(entries []WhitelistEntry) {
+	prefixes := make(map[string]netip.Prefix, len(entries))
+	for _, e := range entries {
+		p, err := parseKey(e.Key)
+		if err == nil {
+			prefixes[e.Key] = p
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := prefixes[entries[i].Key], prefixes[entries[j].Key]
+		switch {
+		case a.IsValid() && b.IsValid() && a.Addr() != b.Addr():
+			return a.Addr().Less(b.Addr())
+		case a.IsValid() && b.IsValid() && a.Bits() != b.Bits():
+			return a.Bits() < b.Bits()
+		case a.IsValid() != b.IsValid():
+			return a.IsValid()
+		default:
+			return entries[i].Key < entries[j].Key
+		}
+	})
+}
+
+var (
+	_ Whitelister = (*NutsWhitelister)(nil)
+	_ Whitelister = (*RedisWhitelister)(nil)
+)
+
+// whitelistValue is the value of whitelisted keys, the unix time of the whitelisting
+func whitelistValue(now time.Time) string {
+	return strconv.FormatInt(now.Unix(), 10)
+}
+
+// parseWhitelistValue returns the time of the whitelisting, found is false for values of older versions.
+func parseWhitelistValue(value string) (since time.Time, found bool) {
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// splitSingleIPs returns the keys of the single addresses and the intervals of the remaining ip ranges.
+// A single ip does not contradict the whole range that contains it, which is why only its own key is evicted.
+func splitSingleIPs(ipRanges []string) (keys []string, ivs intervals, err error) {
+	ranges := make([]string, 0, len(ipRanges))
+	for _, ipRange := range ipRanges {
+		first, last, err := ParseRange(ipRange)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid ip range: %s: %w", ipRange, err)
+		}
+		if first == last {
+			keys = append(keys, first.String())
+			continue
+		}
+		ranges = append(ranges, ipRange)
+	}
+
+	ivs, err = newIntervals(ranges)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, ivs, nil
+}
+
+// NutsWhitelister caches the whitelisted ips in the local nutsdb database
+type NutsWhitelister struct {
 	nuts         *nutsdb.DB
 	nutsBucket   string
 	whitelistTTL uint32
 }
 
-func NewWhitelister(nuts *nutsdb.DB, bucket string, ttl time.Duration) *Whitelister {
-	return &Whitelister{
+// NewNutsWhitelister caches the whitelisted ips in the given nutsdb bucket.
+func NewNutsWhitelister(nuts *nutsdb.DB, bucket string, ttl time.Duration) *NutsWhitelister {
+	return &NutsWhitelister{
 		nuts:         nuts,
 		nutsBucket:   bucket,
 		whitelistTTL: uint32(ttl.Seconds()),
 	}
 }
 
-func (wl *Whitelister) Exists(ip string) (found bool, err error) {
+func (wl *NutsWhitelister) Exists(ip string) (found bool, err error) {
 	if wl == nil {
 		return false, nil
 	}
 
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if ip exists in whitelist: %w", err)
+	}
+
 	err = wl.nuts.View(func(tx *nutsdb.Tx) error {
-		_, err := tx.Get(wl.nutsBucket, []byte(ip))
-		if err != nil {
-			return err
+		for _, key := range containingKeys(addr.Unmap()) {
+			_, err := tx.Get(wl.nutsBucket, []byte(key))
+			if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrNotFoundKey) {
+				// missing or expired
+				continue
+			} else if errors.Is(err, nutsdb.ErrNotFoundBucket) {
+				// nothing was whitelisted, yet
+				return nil
+			} else if err != nil {
+				return err
+			}
+			found = true
+			return nil
 		}
 		return nil
 	})
-	if err == nil {
-		return true, nil
+	if err != nil {
+		return false, fmt.Errorf("failed to check if ip exists in whitelist: %w", err)
 	}
+	return found, nil
+}
 
-	if errors.Is(err, nutsdb.ErrKeyNotFound) {
-		return false, nil
+func (wl *NutsWhitelister) Whitelist(ip string) error {
+	if wl == nil {
+		return nil
+	}
+	err := wl.nuts.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put(wl.nutsBucket, []byte(ip), []byte(whitelistValue(time.Now())), wl.whitelistTTL)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to whitelist ip: %s: %w", ip, err)
+	}
+	return nil
+}
+
+func (wl *NutsWhitelister) WhitelistRange(ipRange string, ttl time.Duration) error {
+	if wl == nil {
+		return nil
 	}
 
-	return false, fmt.Errorf("failed to check if ip exists in whitelist: %w", err)
+	prefixes, err := RangePrefixes(ipRange)
+	if err != nil {
+		return fmt.Errorf("failed to whitelist range: %s: %w", ipRange, err)
+	}
+
+	value := []byte(whitelistValue(time.Now()))
+	err = wl.nuts.Update(func(tx *nutsdb.Tx) error {
+		for _, p := range prefixes {
+			err := tx.Put(wl.nutsBucket, []byte(prefixKey(p)), value, uint32(ttl.Seconds()))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to whitelist range: %s: %w", ipRange, err)
+	}
+	return nil
 }
 
-func (wl *Whitelister) Whitelist(ip string) error {
+func (wl *NutsWhitelister) Since(ip string) (since time.Time, found bool, err error) {
 	if wl == nil {
+		return time.Time{}, false, nil
+	}
+
+	var value []byte
+	err = wl.nuts.View(func(tx *nutsdb.Tx) error {
+		value, err = tx.Get(wl.nutsBucket, []byte(ip))
+		return err
+	})
+	if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrNotFoundKey) || errors.Is(err, nutsdb.ErrNotFoundBucket) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to get whitelisted ip: %s: %w", ip, err)
+	}
+
+	since, found = parseWhitelistValue(string(value))
+	return since, found, nil
+}
+
+func (wl *NutsWhitelister) Evict(ipRanges ...string) (evicted int, err error) {
+	if wl == nil || len(ipRanges) == 0 {
+		return 0, nil
+	}
+
+	keys, ivs, err := splitSingleIPs(ipRanges)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict whitelisted ips: %w", err)
+	}
+
+	if len(ivs) > 0 {
+		err = wl.nuts.View(func(tx *nutsdb.Tx) error {
+			all, err := tx.GetKeys(wl.nutsBucket)
+			if err != nil {
+				return err
+			}
+			for _, key := range all {
+				p, err := parseKey(string(key))
+				if err == nil && ivs.overlaps(p) {
+					keys = append(keys, string(key))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to evict whitelisted ips: %w", err)
+		}
+		// single ips may also be part of the ranges
+		slices.Sort(keys)
+		keys = slices.Compact(keys)
+	}
+
+	err = wl.nuts.Update(func(tx *nutsdb.Tx) error {
+		for _, key := range keys {
+			err := tx.Delete(wl.nutsBucket, []byte(key))
+			if errors.Is(err, nutsdb.ErrKeyNotFound) {
+				continue
+			} else if errors.Is(err, nutsdb.ErrNotFoundBucket) {
+				// nothing was whitelisted, yet
+				return nil
+			} else if err != nil {
+				return err
+			}
+			evicted++
+		}
 		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict whitelisted ips: %w", err)
 	}
-	err := wl.nuts.Update(func(tx *nutsdb.Tx) error {
-		return tx.Put(wl.nutsBucket, []byte(ip), []byte("no vpn"), wl.whitelistTTL)
+	return evicted, nil
+}
+
+func (wl *NutsWhitelister) Get(ip string) ([]WhitelistEntry, error) {
+	if wl == nil {
+		return nil, nil
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get whitelisted ip: %w", err)
+	}
+
+	var entries []WhitelistEntry
+	err = wl.nuts.View(func(tx *nutsdb.Tx) error {
+		var err error
+		entries, err = wl.entries(tx, containingKeys(addr.Unmap()))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get whitelisted ip: %s: %w", ip, err)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func (wl *NutsWhitelister) List() ([]WhitelistEntry, error) {
+	if wl == nil {
+		return nil, nil
+	}
+
+	var entries []WhitelistEntry
+	err := wl.nuts.View(func(tx *nutsdb.Tx) error {
+		all, err := tx.GetKeys(wl.nutsBucket)
+		if errors.Is(err, nutsdb.ErrNotFoundBucket) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(all))
+		for _, key := range all {
+			keys = append(keys, string(key))
+		}
+		entries, err = wl.entries(tx, keys)
+		return err
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list whitelisted ips: %w", err)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// entries returns the entries of the existing keys
+func (wl *NutsWhitelister) entries(tx *nutsdb.Tx, keys []string) ([]WhitelistEntry, error) {
+	now := time.Now()
+	entries := make([]WhitelistEntry, 0, len(keys))
+	for _, key := range keys {
+		value, err := tx.Get(wl.nutsBucket, []byte(key))
+		if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrNotFoundKey) {
+			// missing or expired
+			continue
+		} else if errors.Is(err, nutsdb.ErrNotFoundBucket) {
+			// nothing was whitelisted, yet
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		// in seconds, -1 for persistent keys
+		ttl, err := tx.GetTTL(wl.nutsBucket, []byte(key))
+		if errors.Is(err, nutsdb.ErrKeyNotFound) {
+			// expired in the meantime
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if ttl > 0 {
+			ttl *= int64(time.Second)
+		}
+		entries = append(entries, newWhitelistEntry(key, string(value), time.Duration(ttl), now))
+	}
+	return entries, nil
+}
+
+func (wl *NutsWhitelister) Purge() (purged int, err error) {
+	if wl == nil {
+		return 0, nil
+	}
+
+	err = wl.nuts.Update(func(tx *nutsdb.Tx) error {
+		keys, err := tx.GetKeys(wl.nutsBucket)
+		if errors.Is(err, nutsdb.ErrNotFoundBucket) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			err := tx.Delete(wl.nutsBucket, key)
+			if errors.Is(err, nutsdb.ErrKeyNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge whitelist: %w", err)
+	}
+	return purged, nil
+}
+
+// NewRedisWhitelister caches the whitelisted ips in redis with the given key prefix,
+// which allows multiple detector instances to share their whitelist.
+func NewRedisWhitelister(ctx context.Context, rdb *redis.Client, keyPrefix string, ttl time.Duration) *RedisWhitelister {
+	return &RedisWhitelister{
+		ctx:          ctx,
+		rdb:          rdb,
+		keyPrefix:    keyPrefix,
+		whitelistTTL: ttl,
+	}
+}
+
+// RedisWhitelister caches the whitelisted ips as redis keys that expire after the whitelist ttl
+type RedisWhitelister struct {
+	ctx          context.Context
+	rdb          *redis.Client
+	keyPrefix    string
+	whitelistTTL time.Duration
+}
+
+func (wl *RedisWhitelister) Exists(ip string) (found bool, err error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if ip exists in whitelist: %w", err)
+	}
+
+	containing := containingKeys(addr.Unmap())
+	keys := make([]string, 0, len(containing))
+	for _, key := range containing {
+		keys = append(keys, wl.keyPrefix+key)
+	}
+
+	// a single round trip for all prefixes that contain the ip
+	n, err := wl.rdb.Exists(wl.ctx, keys...).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check if ip exists in whitelist: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (wl *RedisWhitelister) Whitelist(ip string) error {
+	err := wl.rdb.Set(wl.ctx, wl.keyPrefix+ip, whitelistValue(time.Now()), wl.whitelistTTL).Err()
 	if err != nil {
 		return fmt.Errorf("failed to whitelist ip: %s: %w", ip, err)
 	}
 	return nil
 }
+
+func (wl *RedisWhitelister) WhitelistRange(ipRange string, ttl time.Duration) error {
+	prefixes, err := RangePrefixes(ipRange)
+	if err != nil {
+		return fmt.Errorf("failed to whitelist range: %s: %w", ipRange, err)
+	}
+
+	value := whitelistValue(time.Now())
+	_, err = wl.rdb.Pipelined(wl.ctx, func(p redis.Pipeliner) error {
+		for _, prefix := range prefixes {
+			p.Set(wl.ctx, wl.keyPrefix+prefixKey(prefix), value, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to whitelist range: %s: %w", ipRange, err)
+	}
+	return nil
+}
+
+func (wl *RedisWhitelister) Since(ip string) (since time.Time, found bool, err error) {
+	value, err := wl.rdb.Get(wl.ctx, wl.keyPrefix+ip).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to get whitelisted ip: %s: %w", ip, err)
+	}
+
+	since, found = parseWhitelistValue(value)
+	return since, found, nil
+}
+
+func (wl *RedisWhitelister) Evict(ipRanges ...string) (evicted int, err error) {
+	if len(ipRanges) == 0 {
+		return 0, nil
+	}
+
+	singles, ivs, err := splitSingleIPs(ipRanges)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict whitelisted ips: %w", err)
+	}
+
+	keys := make([]string, 0, len(singles))
+	for _, key := range singles {
+		keys = append(keys, wl.keyPrefix+key)
+	}
+	if len(ivs) > 0 {
+		iter := wl.rdb.Scan(wl.ctx, 0, wl.keyPrefix+"*", 1000).Iterator()
+		for iter.Next(wl.ctx) {
+			key := iter.Val()
+			p, err := parseKey(strings.TrimPrefix(key, wl.keyPrefix))
+			if err == nil && ivs.overlaps(p) {
+				keys = append(keys, key)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return 0, fmt.Errorf("failed to evict whitelisted ips: %w", err)
+		}
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	n, err := wl.rdb.Del(wl.ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict whitelisted ips: %w", err)
+	}
+	return int(n), nil
+}
+
+func (wl *RedisWhitelister) Get(ip string) ([]WhitelistEntry, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get whitelisted ip: %w", err)
+	}
+
+	entries, err := wl.entries(containingKeys(addr.Unmap()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get whitelisted ip: %s: %w", ip, err)
+	}
+	return entries, nil
+}
+
+func (wl *RedisWhitelister) List() ([]WhitelistEntry, error) {
+	keys, err := wl.keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list whitelisted ips: %w", err)
+	}
+
+	entries, err := wl.entries(keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list whitelisted ips: %w", err)
+	}
+	return entries, nil
+}
+
+// keys returns the keys of all whitelisted ips and ranges without the key prefix
+func (wl *RedisWhitelister) keys() ([]string, error) {
+	var keys []string
+	iter := wl.rdb.Scan(wl.ctx, 0, wl.keyPrefix+"*", 1000).Iterator()
+	for iter.Next(wl.ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), wl.keyPrefix))
+	}
+	return keys, iter.Err()
+}
+
+// entries returns the sorted entries of the existing keys in a single round trip
+func (wl *RedisWhitelister) entries(keys []string) ([]WhitelistEntry, error) {
+	values := make([]*redis.StringCmd, 0, len(keys))
+	ttls := make([]*redis.DurationCmd, 0, len(keys))
+	_, err := wl.rdb.Pipelined(wl.ctx, func(p redis.Pipeliner) error {
+		for _, key := range keys {
+			values = append(values, p.Get(wl.ctx, wl.keyPrefix+key))
+			ttls = append(ttls, p.PTTL(wl.ctx, wl.keyPrefix+key))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	now := time.Now()
+	entries := make([]WhitelistEntry, 0, len(keys))
+	for idx, key := range keys {
+		value, err := values[idx].Result()
+		if errors.Is(err, redis.Nil) {
+			// missing or expired
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// negative for keys without expiry
+		ttl := ttls[idx].Val()
+		entries = append(entries, newWhitelistEntry(key, value, ttl, now))
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func (wl *RedisWhitelister) Purge() (purged int, err error) {
+	keys, err := wl.keys()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge whitelist: %w", err)
+	}
+
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), 1000)]
+		keys = keys[len(batch):]
+
+		for idx, key := range batch {
+			batch[idx] = wl.keyPrefix + key
+		}
+		n, err := wl.rdb.Del(wl.ctx, batch...).Result()
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge whitelist: %w", err)
+		}
+		purged += int(n)
+	}
+	return purged, nil
+}
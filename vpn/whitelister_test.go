@@ -0,0 +1,333 @@
+package vpn
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nutsdb/nutsdb"
+)
+
+var whitelisterBackends = []string{"nutsdb", "redis"}
+
+// newTestWhitelisters creates both whitelister whitelisterBackends with the given ttl of single ips
+func newTestWhitelisters(t *testing.T, ttl time.Duration) map[string]Whitelister {
+	t.Helper()
+
+	nuts, err := nutsdb.Open(
+		nutsdb.DefaultOptions,
+		nutsdb.WithDir(t.TempDir()),
+		nutsdb.WithSegmentSize(1024*1024),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = nuts.Close() })
+
+	err = nuts.Update(func(tx *nutsdb.Tx) error {
+		return tx.NewKVBucket("whitelist")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rdb, _ := newTestRedis(t)
+	return map[string]Whitelister{
+		"nutsdb": NewNutsWhitelister(nuts, "whitelist", ttl),
+		"redis":  NewRedisWhitelister(context.Background(), rdb, "test:whitelist:", ttl),
+	}
+}
+
+// whitelistKeys returns the keys of all whitelisted ips and ranges
+func whitelistKeys(t *testing.T, wl Whitelister) []string {
+	t.Helper()
+	entries, err := wl.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestWhitelisterEvict(t *testing.T) {
+	tests := []struct {
+		name  string
+		ips   []string
+		evict []string
+
+		evicted int
+		keys    []string
+	}{
+		{
+			name:    "single ip keeps the containing range",
+			ips:     []string{"10.0.1.5"},
+			evict:   []string{"10.0.1.5"},
+			evicted: 1,
+			keys:    []string{"10.0.0.0/16"},
+		},
+		{
+			name:    "single ip that is only part of the range",
+			evict:   []string{"10.0.1.5"},
+			evicted: 0,
+			keys:    []string{"10.0.0.0/16"},
+		},
+		{
+			name:    "range evicts the overlapping range",
+			ips:     []string{"10.0.1.5", "10.1.0.1"},
+			evict:   []string{"10.0.1.0/24"},
+			evicted: 2,
+			keys:    []string{"10.1.0.1"},
+		},
+		{
+			name:    "single ips and ranges",
+			ips:     []string{"10.0.1.5", "10.1.0.1"},
+			evict:   []string{"10.1.0.1", "10.1.0.0/24"},
+			evicted: 1,
+			keys:    []string{"10.0.0.0/16", "10.0.1.5"},
+		},
+		{
+			name:    "single ip part of an evicted range",
+			ips:     []string{"10.0.1.5"},
+			evict:   []string{"10.0.1.5", "10.0.0.0/8"},
+			evicted: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		whitelisters := newTestWhitelisters(t, time.Hour)
+		for _, backend := range whitelisterBackends {
+			wl := whitelisters[backend]
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				err := wl.WhitelistRange("10.0.0.0/16", 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, ip := range tt.ips {
+					err = wl.Whitelist(ip)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				evicted, err := wl.Evict(tt.evict...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if evicted != tt.evicted {
+					t.Errorf("expected %d evicted entries, got %d", tt.evicted, evicted)
+				}
+
+				keys := whitelistKeys(t, wl)
+				if !slices.Equal(keys, tt.keys) {
+					t.Fatalf("expected the entries %v, got %v", tt.keys, keys)
+				}
+			})
+		}
+	}
+}
+
+func TestWhitelisterExists(t *testing.T) {
+	tests := []struct {
+		name    string
+		ips     []string
+		ranges  []string
+		ip      string
+		found   bool
+		entries []string
+	}{
+		{
+			name: "empty",
+			ip:   "10.0.0.1",
+		},
+		{
+			name:    "single ip",
+			ips:     []string{"10.0.0.1"},
+			ip:      "10.0.0.1",
+			found:   true,
+			entries: []string{"10.0.0.1"},
+		},
+		{
+			name: "other single ip",
+			ips:  []string{"10.0.0.1"},
+			ip:   "10.0.0.2",
+		},
+		{
+			name:    "CIDR range",
+			ranges:  []string{"10.0.0.0/16"},
+			ip:      "10.0.255.255",
+			found:   true,
+			entries: []string{"10.0.0.0/16"},
+		},
+		{
+			name:   "outside of the CIDR range",
+			ranges: []string{"10.0.0.0/16"},
+			ip:     "10.1.0.0",
+		},
+		{
+			name:    "from-to range is split into prefixes",
+			ranges:  []string{"10.0.0.1-10.0.0.6"},
+			ip:      "10.0.0.5",
+			found:   true,
+			entries: []string{"10.0.0.4/31"},
+		},
+		{
+			name:   "outside of the from-to range",
+			ranges: []string{"10.0.0.1-10.0.0.6"},
+			ip:     "10.0.0.7",
+		},
+		{
+			name:    "nested ranges and single ip",
+			ips:     []string{"10.0.1.5"},
+			ranges:  []string{"10.0.0.0/16", "10.0.1.0/24"},
+			ip:      "10.0.1.5",
+			found:   true,
+			entries: []string{"10.0.0.0/16", "10.0.1.0/24", "10.0.1.5"},
+		},
+		{
+			name:    "IPv6 range",
+			ranges:  []string{"2001:db8::/32"},
+			ip:      "2001:db8::1",
+			found:   true,
+			entries: []string{"2001:db8::/32"},
+		},
+		{
+			name:    "IPv4-mapped IPv6 address",
+			ranges:  []string{"10.0.0.0/16"},
+			ip:      "::ffff:10.0.0.1",
+			found:   true,
+			entries: []string{"10.0.0.0/16"},
+		},
+	}
+
+	for _, tt := range tests {
+		whitelisters := newTestWhitelisters(t, time.Hour)
+		for _, backend := range whitelisterBackends {
+			wl := whitelisters[backend]
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				for _, ip := range tt.ips {
+					err := wl.Whitelist(ip)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				for _, ipRange := range tt.ranges {
+					err := wl.WhitelistRange(ipRange, 0)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				found, err := wl.Exists(tt.ip)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found != tt.found {
+					t.Fatalf("expected %s to be whitelisted %t, got %t", tt.ip, tt.found, found)
+				}
+
+				entries, err := wl.Get(tt.ip)
+				if err != nil {
+					t.Fatal(err)
+				}
+				keys := make([]string, 0, len(entries))
+				for _, e := range entries {
+					keys = append(keys, e.Key)
+				}
+				if !slices.Equal(keys, tt.entries) {
+					t.Fatalf("expected the entries %v, got %v", tt.entries, keys)
+				}
+			})
+		}
+	}
+}
+
+func TestWhitelisterTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		// ttl of single ips and the ranges, 0 keeps the range forever
+		whitelistTTL time.Duration
+		rangeTTL     time.Duration
+		// expected ttls of the entries, 0 for entries that never expire
+		ttls map[string]time.Duration
+	}{
+		{
+			name:         "range that never expires",
+			whitelistTTL: time.Hour,
+			ttls:         map[string]time.Duration{"10.0.0.0/16": 0, "10.0.1.5": time.Hour},
+		},
+		{
+			name:         "range with its own ttl",
+			whitelistTTL: time.Hour,
+			rangeTTL:     24 * time.Hour,
+			ttls:         map[string]time.Duration{"10.0.0.0/16": 24 * time.Hour, "10.0.1.5": time.Hour},
+		},
+		{
+			name:         "range shorter than the single ip",
+			whitelistTTL: 48 * time.Hour,
+			rangeTTL:     time.Minute,
+			ttls:         map[string]time.Duration{"10.0.0.0/16": time.Minute, "10.0.1.5": 48 * time.Hour},
+		},
+	}
+
+	for _, tt := range tests {
+		whitelisters := newTestWhitelisters(t, tt.whitelistTTL)
+		for _, backend := range whitelisterBackends {
+			wl := whitelisters[backend]
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				now := time.Now()
+				err := wl.WhitelistRange("10.0.0.0/16", tt.rangeTTL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = wl.Whitelist("10.0.1.5")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				entries, err := wl.Get("10.0.1.5")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) != len(tt.ttls) {
+					t.Fatalf("expected the entries %v, got %v", tt.ttls, entries)
+				}
+				for _, e := range entries {
+					ttl, ok := tt.ttls[e.Key]
+					if !ok {
+						t.Fatalf("unexpected entry %s", e.Key)
+					}
+					if ttl == 0 {
+						if !e.Expires.IsZero() {
+							t.Errorf("expected %s to never expire, got %s", e.Key, e.Expires)
+						}
+						continue
+					}
+					if d := e.Expires.Sub(now.Add(ttl)); d < -2*time.Second || d > 2*time.Second {
+						t.Errorf("expected %s to expire in %s, got %s", e.Key, ttl, e.Expires.Sub(now))
+					}
+				}
+
+				since, found, err := wl.Since("10.0.1.5")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !found || now.Sub(since) > 2*time.Second {
+					t.Fatalf("expected the single ip to be whitelisted now, got %t %s", found, since)
+				}
+
+				// ips that are only part of a range were not whitelisted themselves
+				_, found, err = wl.Since("10.0.1.6")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found {
+					t.Fatal("expected no since for an ip that is only part of a range")
+				}
+			})
+		}
+	}
+}